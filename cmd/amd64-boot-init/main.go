@@ -0,0 +1,144 @@
+//go:build linux
+
+// amd64-boot-init is the init program of the initramfs which amd64-boot-test
+// boots the kernel with. It checks the config of the running kernel, probes
+// the feature groups of the config addendum (e.g. WireGuard, nftables,
+// overlayfs) and reports the results on the console (see package boottest),
+// then powers off.
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/boottest"
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/kconfig"
+)
+
+func report(kind, msg string) {
+	boottest.Report(os.Stdout, kind, msg)
+}
+
+func reportError(format string, args ...interface{}) {
+	report(boottest.KindError, fmt.Sprintf(format, args...))
+}
+
+func mountFilesystems() error {
+	for _, m := range []struct{ fstype, target string }{
+		{"proc", "/proc"},
+		{"sysfs", "/sys"},
+		{"devtmpfs", "/dev"},
+		{"tmpfs", "/tmp"},
+	} {
+		if err := syscall.Mount(m.fstype, m.target, m.fstype, 0, ""); err != nil {
+			return fmt.Errorf("mount %s: %v", m.target, err)
+		}
+	}
+	return nil
+}
+
+// reportRoot reports the block device of the root file system, unless it is
+// the initramfs.
+func reportRoot() error {
+	var st syscall.Stat_t
+	if err := syscall.Stat("/", &st); err != nil {
+		return err
+	}
+	// See new_decode_dev in include/linux/kdev_t.h.
+	major := (st.Dev>>8)&0xfff | (st.Dev>>32)&^0xfff
+	minor := st.Dev&0xff | (st.Dev>>12)&^0xff
+	if major == 0 {
+		return nil // the initramfs (rootfs) has no block device
+	}
+	uevent := fmt.Sprintf("/sys/dev/block/%d:%d/uevent", major, minor)
+	b, err := os.ReadFile(uevent)
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if name, ok := strings.CutPrefix(line, "DEVNAME="); ok {
+			report(boottest.KindRoot, name)
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: no DEVNAME", uevent)
+}
+
+// checkConfig compares /proc/config.gz with the expected config.
+func checkConfig() error {
+	f, err := os.Open("/proc/config.gz")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		return fmt.Errorf("/proc/config.gz: %v", err)
+	}
+	sum := sha256.Sum256(b)
+	report(boottest.KindConfig, hex.EncodeToString(sum[:]))
+
+	config, err := kconfig.Parse(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	expected, err := kconfig.ParseFragment(os.DirFS("/"), boottest.ExpectedConfigPath)
+	if err != nil {
+		return err
+	}
+	for _, o := range expected {
+		got := config.Value(o.Name)
+		if got == o.Value {
+			continue
+		}
+		b, err := json.Marshal(boottest.Mismatch{Option: o.Name, Want: o.Value, Got: got})
+		if err != nil {
+			return err
+		}
+		report(boottest.KindMismatch, string(b))
+	}
+	return nil
+}
+
+func main() {
+	if os.Getpid() != 1 {
+		fmt.Fprintln(os.Stderr, "amd64-boot-init is the init of the amd64-boot-test initramfs and must run as pid 1")
+		os.Exit(2)
+	}
+	report(boottest.KindUserspace, "")
+
+	if err := mountFilesystems(); err != nil {
+		reportError("%v", err)
+	} else {
+		if err := reportRoot(); err != nil {
+			reportError("root device: %v", err)
+		}
+		if err := checkConfig(); err != nil {
+			reportError("config check: %v", err)
+		}
+		if err := runProbes(); err != nil {
+			reportError("probes: %v", err)
+		}
+	}
+
+	report(boottest.KindDone, "")
+	syscall.Sync()
+	if err := syscall.Reboot(syscall.LINUX_REBOOT_CMD_POWER_OFF); err != nil {
+		reportError("power off: %v", err)
+	}
+	// Exiting init panics the kernel, which QEMU (-no-reboot) turns into an
+	// exit.
+	os.Exit(1)
+}
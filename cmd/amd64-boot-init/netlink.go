@@ -0,0 +1,110 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// netlinkConn is a minimal netlink client: enough to send requests and
+// batches and wait for their acknowledgements.
+type netlinkConn struct {
+	fd  int
+	seq uint32
+}
+
+func dialNetlink(proto int) (*netlinkConn, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return nil, fmt.Errorf("netlink socket: %v", err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("netlink bind: %v", err)
+	}
+	// Do not hang the boot test if the kernel never answers.
+	tv := syscall.NsecToTimeval((5 * time.Second).Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return &netlinkConn{fd: fd}, nil
+}
+
+func (c *netlinkConn) Close() error {
+	return syscall.Close(c.fd)
+}
+
+type netlinkMessage struct {
+	typ   uint16
+	flags uint16
+	body  []byte
+}
+
+// netlinkAttr encodes an attribute (struct nlattr/rtattr), padded to 4
+// bytes.
+func netlinkAttr(typ uint16, data []byte) []byte {
+	b := binary.LittleEndian.AppendUint16(nil, uint16(4+len(data)))
+	b = binary.LittleEndian.AppendUint16(b, typ)
+	b = append(b, data...)
+	return append(b, make([]byte, (4-len(b)%4)%4)...)
+}
+
+// cstring returns s as NUL-terminated string attribute data.
+func cstring(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+// request sends msgs in one datagram (e.g. a netfilter batch) and waits for
+// the acknowledgements of all messages with NLM_F_ACK. It returns the other
+// messages received meanwhile, e.g. the reply to a get request.
+func (c *netlinkConn) request(msgs ...netlinkMessage) ([]netlinkMessage, error) {
+	var buf []byte
+	acks := make(map[uint32]bool)
+	for _, m := range msgs {
+		c.seq++
+		if m.flags&syscall.NLM_F_ACK != 0 {
+			acks[c.seq] = true
+		}
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(syscall.NLMSG_HDRLEN+len(m.body)))
+		buf = binary.LittleEndian.AppendUint16(buf, m.typ)
+		buf = binary.LittleEndian.AppendUint16(buf, m.flags)
+		buf = binary.LittleEndian.AppendUint32(buf, c.seq)
+		buf = binary.LittleEndian.AppendUint32(buf, 0)
+		buf = append(buf, m.body...)
+		buf = append(buf, make([]byte, (4-len(buf)%4)%4)...)
+	}
+	if err := syscall.Sendto(c.fd, buf, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("netlink send: %v", err)
+	}
+
+	var replies []netlinkMessage
+	rbuf := make([]byte, 65536)
+	for len(acks) > 0 {
+		n, _, err := syscall.Recvfrom(c.fd, rbuf, 0)
+		if err != nil {
+			return nil, fmt.Errorf("netlink receive: %v", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(rbuf[:n])
+		if err != nil {
+			return nil, fmt.Errorf("netlink receive: %v", err)
+		}
+		for _, m := range msgs {
+			if m.Header.Type != syscall.NLMSG_ERROR {
+				replies = append(replies, netlinkMessage{typ: m.Header.Type, flags: m.Header.Flags, body: m.Data})
+				continue
+			}
+			if len(m.Data) < 4 {
+				return nil, fmt.Errorf("netlink: short error message")
+			}
+			if errno := -int32(binary.LittleEndian.Uint32(m.Data)); errno != 0 {
+				return nil, syscall.Errno(errno)
+			}
+			delete(acks, m.Header.Seq)
+		}
+	}
+	return replies, nil
+}
@@ -0,0 +1,283 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/boottest"
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/kconfig"
+)
+
+// probe exercises a feature group of the config addendum in the running
+// kernel.
+type probe struct {
+	name string
+	// option enables the feature. The probe is skipped unless the expected
+	// config builds it in: the initramfs cannot load modules.
+	option string
+	run    func(expected map[string]string) error
+}
+
+var probes = []probe{
+	{"wireguard", "CONFIG_WIREGUARD", probeWireGuard},
+	{"nftables", "CONFIG_NF_TABLES", probeNftables},
+	{"overlayfs", "CONFIG_OVERLAY_FS", probeOverlay},
+	{"squashfs", "CONFIG_SQUASHFS", probeSquashfs},
+	{"bbr", "CONFIG_TCP_CONG_BBR", probeBBR},
+	{"fuse", "CONFIG_FUSE_FS", probeFUSE},
+}
+
+// runProbes runs all probes and reports their outcomes.
+func runProbes() error {
+	options, err := kconfig.ParseFragment(os.DirFS("/"), boottest.ExpectedConfigPath)
+	if err != nil {
+		return err
+	}
+	expected := make(map[string]string)
+	for _, o := range options {
+		expected[o.Name] = o.Value
+	}
+	for _, p := range probes {
+		result := boottest.Probe{Name: p.name, Option: p.option, Status: boottest.ProbePass}
+		if v, ok := expected[p.option]; !ok || v != "y" {
+			result.Status = boottest.ProbeSkip
+			result.Detail = fmt.Sprintf("%s is not built in by the expected config", p.option)
+		} else if err := p.run(expected); err != nil {
+			result.Status = boottest.ProbeFail
+			result.Detail = strings.ReplaceAll(err.Error(), "\n", " ")
+		}
+		b, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		report(boottest.KindProbe, string(b))
+	}
+	return nil
+}
+
+const (
+	iflaInfoKind = 1 // IFLA_INFO_KIND, nested in IFLA_LINKINFO
+
+	// nfnetlink message types and nf_tables subsystem (see
+	// include/uapi/linux/netfilter/nfnetlink.h and nf_tables.h).
+	nfnlMsgBatchBegin  = 0x10
+	nfnlMsgBatchEnd    = 0x11
+	nfnlSubsysNftables = 10
+	nftMsgNewTable     = 0
+	nftMsgGetTable     = 1
+	nftMsgDelTable     = 2
+	nftaTableName      = 1
+
+	// Loop device ioctls (see include/uapi/linux/loop.h).
+	loopSetFD      = 0x4c00
+	loopClrFD      = 0x4c01
+	loopCtlGetFree = 0x4c82
+)
+
+// probeWireGuard creates (and deletes) a WireGuard link via rtnetlink.
+func probeWireGuard(map[string]string) error {
+	c, err := dialNetlink(syscall.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	const name = "wgprobe0"
+	link := func(typ uint16, flags uint16, attrs ...[]byte) netlinkMessage {
+		body := make([]byte, syscall.SizeofIfInfomsg) // struct ifinfomsg, AF_UNSPEC
+		for _, a := range attrs {
+			body = append(body, a...)
+		}
+		return netlinkMessage{typ: typ, flags: syscall.NLM_F_REQUEST | syscall.NLM_F_ACK | flags, body: body}
+	}
+	if _, err := c.request(link(syscall.RTM_NEWLINK, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL,
+		netlinkAttr(syscall.IFLA_IFNAME, cstring(name)),
+		netlinkAttr(syscall.IFLA_LINKINFO, netlinkAttr(iflaInfoKind, cstring("wireguard"))))); err != nil {
+		return fmt.Errorf("creating wireguard link: %v", err)
+	}
+	if _, err := os.Stat("/sys/class/net/" + name); err != nil {
+		return fmt.Errorf("wireguard link created, but: %v", err)
+	}
+	if _, err := c.request(link(syscall.RTM_DELLINK, 0, netlinkAttr(syscall.IFLA_IFNAME, cstring(name)))); err != nil {
+		return fmt.Errorf("deleting wireguard link: %v", err)
+	}
+	return nil
+}
+
+// probeNftables loads an (empty) nftables table, reads it back and deletes
+// it again, each in a netfilter batch like nft(8) does.
+func probeNftables(map[string]string) error {
+	c, err := dialNetlink(syscall.NETLINK_NETFILTER)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	const table = "boottest"
+	// nfgenmsg returns struct nfgenmsg followed by attrs. res_id is in
+	// network byte order.
+	nfgenmsg := func(family uint8, resID uint16, attrs ...[]byte) []byte {
+		b := binary.BigEndian.AppendUint16([]byte{family, 0 /* NFNETLINK_V0 */}, resID)
+		for _, a := range attrs {
+			b = append(b, a...)
+		}
+		return b
+	}
+	batch := func(msgType uint16, flags uint16) []netlinkMessage {
+		return []netlinkMessage{
+			{typ: nfnlMsgBatchBegin, flags: syscall.NLM_F_REQUEST, body: nfgenmsg(syscall.AF_UNSPEC, nfnlSubsysNftables)},
+			{
+				typ:   nfnlSubsysNftables<<8 | msgType,
+				flags: syscall.NLM_F_REQUEST | syscall.NLM_F_ACK | flags,
+				body:  nfgenmsg(syscall.AF_INET, 0, netlinkAttr(nftaTableName, cstring(table))),
+			},
+			{typ: nfnlMsgBatchEnd, flags: syscall.NLM_F_REQUEST, body: nfgenmsg(syscall.AF_UNSPEC, nfnlSubsysNftables)},
+		}
+	}
+	if _, err := c.request(batch(nftMsgNewTable, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL)...); err != nil {
+		return fmt.Errorf("loading table: %v", err)
+	}
+	replies, err := c.request(netlinkMessage{
+		typ:   nfnlSubsysNftables<<8 | nftMsgGetTable,
+		flags: syscall.NLM_F_REQUEST | syscall.NLM_F_ACK,
+		body:  nfgenmsg(syscall.AF_INET, 0, netlinkAttr(nftaTableName, cstring(table))),
+	})
+	if err != nil {
+		return fmt.Errorf("getting table: %v", err)
+	}
+	if len(replies) == 0 || !bytes.Contains(replies[0].body, cstring(table)) {
+		return fmt.Errorf("table %s loaded, but not returned by the kernel", table)
+	}
+	if _, err := c.request(batch(nftMsgDelTable, 0)...); err != nil {
+		return fmt.Errorf("deleting table: %v", err)
+	}
+	return nil
+}
+
+// probeOverlay mounts an overlay of two tmpfs directories (like podman does
+// for containers) and checks copy-up.
+func probeOverlay(map[string]string) error {
+	dir := "/tmp/overlay"
+	for _, d := range []string{"lower", "upper", "work", "merged"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "lower", "file"), []byte("lower"), 0644); err != nil {
+		return err
+	}
+	merged := filepath.Join(dir, "merged")
+	opts := fmt.Sprintf("lowerdir=%s/lower,upperdir=%s/upper,workdir=%s/work", dir, dir, dir)
+	if err := syscall.Mount("overlay", merged, "overlay", 0, opts); err != nil {
+		return fmt.Errorf("mount overlay: %v", err)
+	}
+	defer syscall.Unmount(merged, 0)
+	if err := os.WriteFile(filepath.Join(merged, "file"), []byte("upper"), 0644); err != nil {
+		return err
+	}
+	lower, err := os.ReadFile(filepath.Join(dir, "lower", "file"))
+	if err != nil {
+		return err
+	}
+	upper, err := os.ReadFile(filepath.Join(dir, "upper", "file"))
+	if err != nil {
+		return fmt.Errorf("write to the overlay was not copied up: %v", err)
+	}
+	if string(lower) != "lower" || string(upper) != "upper" {
+		return fmt.Errorf("unexpected content after copy-up: lower %q, upper %q", lower, upper)
+	}
+	return nil
+}
+
+// probeSquashfs mounts the squashfs image of the initramfs (the gokrazy root
+// file system is squashfs) via a loop device and reads a file from it.
+func probeSquashfs(map[string]string) error {
+	image, err := os.Open("/" + boottest.ProbeImagePath)
+	if err != nil {
+		return err
+	}
+	defer image.Close()
+	ctl, err := os.OpenFile("/dev/loop-control", os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("%v (is CONFIG_BLK_DEV_LOOP built in?)", err)
+	}
+	defer ctl.Close()
+	n, _, errno := syscall.Syscall(syscall.SYS_IOCTL, ctl.Fd(), loopCtlGetFree, 0)
+	if errno != 0 {
+		return fmt.Errorf("LOOP_CTL_GET_FREE: %v", errno)
+	}
+	loopPath := fmt.Sprintf("/dev/loop%d", n)
+	loop, err := os.OpenFile(loopPath, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer loop.Close()
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, loop.Fd(), loopSetFD, image.Fd()); errno != 0 {
+		return fmt.Errorf("LOOP_SET_FD: %v", errno)
+	}
+	defer syscall.Syscall(syscall.SYS_IOCTL, loop.Fd(), loopClrFD, 0)
+
+	mnt := "/tmp/squashfs"
+	if err := os.MkdirAll(mnt, 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount(loopPath, mnt, "squashfs", syscall.MS_RDONLY, ""); err != nil {
+		return fmt.Errorf("mount squashfs: %v", err)
+	}
+	defer syscall.Unmount(mnt, 0)
+	b, err := os.ReadFile(filepath.Join(mnt, boottest.ProbeImageFile))
+	if err != nil {
+		return err
+	}
+	if string(b) != boottest.ProbeImageContent {
+		return fmt.Errorf("%s: got %q, want %q", boottest.ProbeImageFile, b, boottest.ProbeImageContent)
+	}
+	return nil
+}
+
+// probeBBR checks that BBR is available and, if the expected config makes
+// it the default, used.
+func probeBBR(expected map[string]string) error {
+	sysctl := func(name string) (string, error) {
+		b, err := os.ReadFile("/proc/sys/net/ipv4/" + name)
+		return strings.TrimSpace(string(b)), err
+	}
+	available, err := sysctl("tcp_available_congestion_control")
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, cc := range strings.Fields(available) {
+		found = found || cc == "bbr"
+	}
+	if !found {
+		return fmt.Errorf("bbr is not available (tcp_available_congestion_control: %s)", available)
+	}
+	if expected["CONFIG_DEFAULT_BBR"] != "y" {
+		return nil
+	}
+	current, err := sysctl("tcp_congestion_control")
+	if err != nil {
+		return err
+	}
+	if current != "bbr" {
+		return fmt.Errorf("tcp_congestion_control is %s, want bbr", current)
+	}
+	return nil
+}
+
+// probeFUSE opens /dev/fuse, which the FUSE daemon (e.g. cpu(1)) mounts
+// file systems with.
+func probeFUSE(map[string]string) error {
+	f, err := os.OpenFile("/dev/fuse", os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,174 @@
+// amd64-boot-test boots a kernel under QEMU (software emulation, no KVM
+// required) with a tiny initramfs and checks that it reaches userspace, that
+// its /proc/config.gz contains the config addendum and that the feature
+// groups of the addendum (WireGuard, nftables, overlayfs, squashfs, BBR,
+// FUSE) work at runtime. With -disk-controllers, the kernel boots from a
+// gokrazy disk image instead, using the root= and init= of cmdline.txt, on
+// each of the specified disk controllers.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/boottest"
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/kconfig"
+)
+
+var (
+	kernel = flag.String("kernel", "vmlinuz", "Path to the kernel (bzImage) to boot")
+
+	cmdlinePath = flag.String("cmdline",
+		"cmdline.txt",
+		"Path to the file containing the kernel command line. The serial console and the initramfs init are appended")
+
+	configDir = flag.String("config-dir",
+		"cmd/amd64-build-kernel/config",
+		"Directory containing the *.config fragments the kernel was built with")
+
+	fragments = flag.String("fragments",
+		"",
+		"Comma-separated list of config fragments the kernel was built with. Empty means all")
+
+	disableFragments = flag.String("disable-fragments",
+		"",
+		"Comma-separated list of config fragments the kernel was built without")
+
+	configReport = flag.String("config-report",
+		"",
+		"Path to the config-report.json of the build. Options it reports as not having taken effect are not checked again")
+
+	buildInfo = flag.String("build-info",
+		"",
+		"Path to the build-info.json or build-manifest.json of the build. If set, /proc/config.gz must match its config_sha256")
+
+	diskControllers = flag.String("disk-controllers",
+		"",
+		"Comma-separated list of disk controllers (sata, virtio-blk, nvme) to boot a gokrazy disk image from, one boot each, instead of booting the initramfs. The root= and init= of -cmdline must resolve on each of them")
+
+	qemu = flag.String("qemu", "qemu-system-x86_64", "QEMU binary")
+
+	memory = flag.Int("memory", 512, "Memory of the virtual machine in MiB")
+
+	timeout = flag.Duration("timeout",
+		10*time.Minute,
+		"Maximum time the boot may take. Software emulation is slow")
+
+	verbose = flag.Bool("v", false, "Print the serial console output while booting (it is printed on failure regardless)")
+)
+
+// configSHA256 returns the config_sha256 recorded in the build info at path.
+func configSHA256(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var info struct {
+		ConfigSHA256 string `json:"config_sha256"`
+	}
+	if err := json.Unmarshal(b, &info); err != nil {
+		return "", fmt.Errorf("%s: %v", path, err)
+	}
+	if info.ConfigSHA256 == "" {
+		return "", fmt.Errorf("%s: no config_sha256", path)
+	}
+	return info.ConfigSHA256, nil
+}
+
+func bootTest() error {
+	cmdline, err := os.ReadFile(*cmdlinePath)
+	if err != nil {
+		return err
+	}
+	expected, err := kconfig.LoadAddendum(os.DirFS(*configDir), kconfig.SplitList(*fragments), kconfig.SplitList(*disableFragments))
+	if err != nil {
+		return err
+	}
+	if *configReport != "" {
+		if expected, err = boottest.SkipReported(expected, *configReport); err != nil {
+			return err
+		}
+	}
+	c := &boottest.Config{
+		Kernel:   *kernel,
+		Cmdline:  strings.TrimSpace(string(cmdline)),
+		Expected: expected,
+		QEMU:     *qemu,
+		Memory:   *memory,
+		Timeout:  *timeout,
+	}
+	if *buildInfo != "" {
+		if c.ConfigSHA256, err = configSHA256(*buildInfo); err != nil {
+			return err
+		}
+	}
+	if *diskControllers == "" {
+		return run(c, "initramfs")
+	}
+	var controllers []*boottest.Controller
+	for _, name := range strings.Split(*diskControllers, ",") {
+		ctrl, err := boottest.LookupController(strings.TrimSpace(name))
+		if err != nil {
+			return err
+		}
+		controllers = append(controllers, ctrl)
+	}
+	var failed []string
+	for _, ctrl := range controllers {
+		dc := *c
+		dc.Controller = ctrl
+		if err := run(&dc, "disk image on "+ctrl.Name); err != nil {
+			log.Print(err)
+			failed = append(failed, ctrl.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("boot test failed on %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// run boots the kernel as configured by c, describing the boot medium as
+// medium in the log.
+func run(c *boottest.Config, medium string) error {
+	var console bytes.Buffer
+	c.Console = &console
+	if *verbose {
+		c.Console = io.MultiWriter(&console, os.Stdout)
+	}
+	log.Printf("booting %s from the %s with %s (checking %d config options)", *kernel, medium, *qemu, len(c.Expected))
+	result, err := boottest.Run(c)
+	if err != nil {
+		return err
+	}
+	if err := result.Err(c); err != nil {
+		if !*verbose {
+			os.Stdout.Write(console.Bytes())
+		}
+		result.WriteProbes(os.Stdout)
+		return err
+	}
+	result.WriteProbes(os.Stdout)
+	root := ""
+	if result.Root != "" {
+		root = " from /dev/" + result.Root
+	}
+	log.Printf("boot test passed: %s reached userspace%s, all %d config options present, no probe failed", *kernel, root, len(c.Expected))
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	if err := bootTest(); err != nil {
+		log.Fatal(err)
+	}
+}
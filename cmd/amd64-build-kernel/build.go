@@ -1,67 +1,41 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"os"
-	"os/exec"
-	"path/filepath"
 	"runtime"
 	"strconv"
-	"strings"
 	"time"
+
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/kconfig"
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/source"
 )
 
-func downloadKernel() error {
-	out, err := os.Create(filepath.Base(latest))
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-	resp, err := http.Get(latest)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if got, want := resp.StatusCode, http.StatusOK; got != want {
-		return fmt.Errorf("unexpected HTTP status code for %s: got %d, want %d", latest, got, want)
-	}
-	if _, err := io.Copy(out, resp.Body); err != nil {
-		return err
-	}
-	return out.Close()
-}
+var (
+	keyringPath = flag.String("keyring",
+		"",
+		"Path to an OpenPGP keyring containing the kernel.org signing keys. If empty, the kernel.org keyring pinned in internal/source/keyring is used")
 
-func applyPatches(srcdir string) error {
-	patches, err := filepath.Glob("*.patch")
-	if err != nil {
-		return err
+	skipVerify = flag.Bool("insecure-skip-verify", false, "Skip checksum and signature verification of the kernel source")
+)
+
+// discardDownload removes the cached copy of url (if -cache-dir is set) after
+// it failed verification, so that later builds do not reuse it.
+func discardDownload(url string) {
+	if *cacheDir == "" {
+		return
 	}
-	for _, patch := range patches {
-		log.Printf("applying patch %q", patch)
-		f, err := os.Open(patch)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-		cmd := exec.Command("patch", "-p1")
-		cmd.Dir = srcdir
-		cmd.Stdin = f
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
-			return err
-		}
-		f.Close()
+	cache := &source.Cache{Dir: *cacheDir}
+	if err := cache.Remove(url); err != nil {
+		log.Printf("removing %s from the cache failed: %v", url, err)
 	}
-
-	return nil
 }
 
-func compile() error {
-	defconfig := exec.Command("make", "defconfig")
+func compile(configAddendum []kconfig.Option, info *buildInfo) error {
+	defconfig := makeCommand("defconfig")
 	defconfig.Stdout = os.Stdout
 	defconfig.Stderr = os.Stderr
 	if err := defconfig.Run(); err != nil {
@@ -74,30 +48,52 @@ func compile() error {
 	}
 	defer f.Close()
 
-	// create addendum to be updated in .config
-	configAddendum := []string{}
-	for k, v := range configAddendumMap {
-		configAddendum = append(configAddendum, k+"="+v)
-	}
-	if _, err := fmt.Fprintln(f, strings.Join(configAddendum, "\n")); err != nil {
-		return err
+	// Append the addendum in a stable order, so that the resulting .config
+	// is identical across builds of the same inputs.
+	for _, o := range configAddendum {
+		if _, err := fmt.Fprintln(f, o); err != nil {
+			return err
+		}
 	}
 	if err := f.Close(); err != nil {
 		return err
 	}
 
-	olddefconfig := exec.Command("make", "olddefconfig")
+	olddefconfig := makeCommand("olddefconfig")
 	olddefconfig.Stdout = os.Stdout
 	olddefconfig.Stderr = os.Stderr
 	if err := olddefconfig.Run(); err != nil {
 		return fmt.Errorf("make olddefconfig: %v", err)
 	}
 
-	make := exec.Command("make", "bzImage", "modules", "-j"+strconv.Itoa(runtime.NumCPU()))
+	report, err := checkConfig(".", configAddendum)
+	if err != nil {
+		return err
+	}
+	report.logSummary()
+	if err := report.writeJSON("/tmp/buildresult/config-report.json"); err != nil {
+		return err
+	}
+	if err := report.err(*strict); err != nil {
+		return err
+	}
+	if err := checkPolicy(".", configAddendum); err != nil {
+		return err
+	}
+
+	ts, err := buildTimestamp()
+	if err != nil {
+		return err
+	}
+	log.Printf("using build timestamp %v", ts)
+	info.BuildTimestamp = ts
+
+	make := makeCommand("bzImage", "modules", "-j"+strconv.Itoa(runtime.NumCPU()))
 	env := append(os.Environ(),
 		"KBUILD_BUILD_USER=gokrazy",
 		"KBUILD_BUILD_HOST=worker.thatwebsite.xyz",
-		"KBUILD_BUILD_TIMESTAMP="+time.Now().UTC().Format(time.UnixDate),
+		"KBUILD_BUILD_TIMESTAMP="+ts.Format(time.UnixDate),
+		"KBUILD_BUILD_VERSION=1",
 	)
 	make.Env = env
 	make.Stdout = os.Stdout
@@ -106,7 +102,7 @@ func compile() error {
 		return fmt.Errorf("make: %v", err)
 	}
 
-	make = exec.Command("make", "INSTALL_MOD_PATH=/tmp/buildresult", "modules_install", "-j"+strconv.Itoa(runtime.NumCPU()))
+	make = makeCommand("INSTALL_MOD_PATH=/tmp/buildresult", "modules_install", "-j"+strconv.Itoa(runtime.NumCPU()))
 	make.Env = env
 	make.Stdout = os.Stdout
 	make.Stderr = os.Stderr
@@ -114,6 +110,10 @@ func compile() error {
 		return fmt.Errorf("make: %v", err)
 	}
 
+	if err := normalizeModules("/tmp/buildresult/lib/modules", ts); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -145,23 +145,34 @@ func copyFile(dest, src string) error {
 }
 
 func main() {
-	log.Printf("downloading kernel source: %s", latest)
-	if err := downloadKernel(); err != nil {
+	flag.Parse()
+
+	if _, err := makeVars(); err != nil {
 		log.Fatal(err)
 	}
 
-	log.Printf("unpacking kernel source")
-	untar := exec.Command("tar", "xf", filepath.Base(latest))
-	untar.Stdout = os.Stdout
-	untar.Stderr = os.Stderr
-	if err := untar.Run(); err != nil {
-		log.Fatalf("untar: %v", err)
+	srcdir, tree, err := prepareSource()
+	if err != nil {
+		log.Fatalf("refusing to build: %v", err)
 	}
+	log.Printf("kernel source (tree digest %s) in %s", tree.TreeSHA256, srcdir)
 
-	srcdir := strings.TrimSuffix(filepath.Base(latest), ".tar.xz")
+	configAddendum, err := loadConfigAddendum()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	log.Printf("applying patches")
-	if err := applyPatches(srcdir); err != nil {
+	patches, err := applyPatches(srcdir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	info := &buildInfo{
+		SourceURL:    latest.SourceURL,
+		SourceSHA256: tree.SourceSHA256,
+		SourceTree:   tree,
+	}
+	if info.Patches, err = patchHashes(patches); err != nil {
 		log.Fatal(err)
 	}
 
@@ -170,11 +181,21 @@ func main() {
 	}
 
 	log.Printf("compiling kernel")
-	if err := compile(); err != nil {
+	if err := compile(configAddendum, info); err != nil {
 		log.Fatal(err)
 	}
 
 	if err := copyFile("/tmp/buildresult/vmlinuz", "arch/x86/boot/bzImage"); err != nil {
 		log.Fatal(err)
 	}
+
+	// amd64-rebuild-kernel commits the final .config per version, see its
+	// config-diff command.
+	if err := copyFile("/tmp/buildresult/config", ".config"); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := writeBuildInfo("/tmp/buildresult/build-info.json", info); err != nil {
+		log.Fatal(err)
+	}
 }
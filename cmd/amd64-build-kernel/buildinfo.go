@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/source"
+)
+
+// buildInfo describes how the kernel was built. It is written to the build
+// result directory and amended by amd64-rebuild-kernel into
+// build-manifest.json.
+type buildInfo struct {
+	KernelVersion string `json:"kernel_version"`
+	SourceURL     string `json:"source_url"`
+	SourceSHA256  string `json:"source_sha256"`
+	// SourceTree describes how the unpatched source tree was obtained, e.g.
+	// by an incremental upgrade, in which case SourceSHA256 is empty.
+	SourceTree     *source.TreeInfo  `json:"source_tree"`
+	Patches        []fileHash        `json:"patches"`
+	ConfigSHA256   string            `json:"config_sha256"`
+	Toolchain      map[string]string `json:"toolchain"`
+	BuildTimestamp time.Time         `json:"build_timestamp"`
+}
+
+type fileHash struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// toolVersions returns the first line of the --version output of the tools
+// used for compiling the kernel.
+func toolVersions() map[string]string {
+	versions := make(map[string]string)
+	for _, tool := range toolchainTools() {
+		out, err := exec.Command(tool, "--version").Output()
+		if err != nil {
+			continue
+		}
+		versions[tool] = strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	}
+	return versions
+}
+
+// writeBuildInfo must be called from within the kernel source directory after
+// compilation.
+func writeBuildInfo(path string, info *buildInfo) error {
+	release, err := makeCommand("-s", "kernelrelease").Output()
+	if err != nil {
+		return err
+	}
+	info.KernelVersion = strings.TrimSpace(string(release))
+	if info.ConfigSHA256, err = hashFile(".config"); err != nil {
+		return err
+	}
+	info.Toolchain = toolVersions()
+	b, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// patchHashes returns the checksums of the specified patch files.
+func patchHashes(patches []string) ([]fileHash, error) {
+	hashes := []fileHash{}
+	for _, patch := range patches {
+		sum, err := hashFile(patch)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, fileHash{Name: filepath.Base(patch), SHA256: sum})
+	}
+	return hashes, nil
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"flag"
+	"time"
+)
+
+var (
+	cacheDir = flag.String("cache-dir",
+		"",
+		"Directory in which downloaded kernel sources are cached across runs. Empty disables caching")
+
+	cacheMaxAge = flag.Duration("cache-max-age",
+		30*24*time.Hour,
+		"Evict cached downloads which were not used for longer than this. 0 disables eviction by age")
+
+	cacheMaxSize = flag.Int64("cache-max-size",
+		2<<30,
+		"Evict the least recently used cached downloads until the cache is smaller than this many bytes. 0 disables eviction by size")
+)
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"embed"
+	"flag"
+	"io/fs"
+	"os"
+
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/kconfig"
+)
+
+// configFragments contains the kernel config fragments which are appended to
+// the defconfig. Add a new *.config file to enable more options; it is picked
+// up without any code changes.
+//
+//go:embed config/*.config
+var configFragments embed.FS
+
+var (
+	configDir = flag.String("config-dir",
+		"",
+		"Directory containing *.config fragments to use instead of the built-in ones")
+
+	enableFragments = flag.String("fragments",
+		"",
+		"Comma-separated list of config fragments (file names without .config) to use. Empty means all")
+
+	disableFragments = flag.String("disable-fragments",
+		"",
+		"Comma-separated list of config fragments (file names without .config) to skip")
+)
+
+// loadConfigAddendum returns the options of all enabled fragments, see
+// kconfig.LoadAddendum.
+func loadConfigAddendum() ([]kconfig.Option, error) {
+	var fsys fs.FS
+	if *configDir != "" {
+		fsys = os.DirFS(*configDir)
+	} else {
+		sub, err := fs.Sub(configFragments, "config")
+		if err != nil {
+			return nil, err
+		}
+		fsys = sub
+	}
+	return kconfig.LoadAddendum(fsys, kconfig.SplitList(*enableFragments), kconfig.SplitList(*disableFragments))
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/kconfig"
+)
+
+var strict = flag.Bool("strict",
+	false,
+	"Fail the build if any option of the config addendum did not take effect after make olddefconfig")
+
+// Possible values of configProblem.Status.
+const (
+	// The option exists, but olddefconfig did not set it (usually because
+	// of unmet dependencies).
+	statusDropped = "dropped"
+	// The option does not exist (anymore) in any Kconfig file.
+	statusUnknown = "unknown"
+	// The option was requested as built-in, but ended up as a module.
+	statusDowngraded = "downgraded"
+	// The option ended up with a different value.
+	statusChanged = "changed"
+)
+
+type configProblem struct {
+	Option    string `json:"option"`
+	Requested string `json:"requested"`
+	Actual    string `json:"actual,omitempty"`
+	Status    string `json:"status"`
+	// Source is the config fragment line which requested the option.
+	Source string `json:"source"`
+	// Candidates lists existing options the option might have been renamed
+	// to, if Status is statusUnknown.
+	Candidates []string `json:"candidates,omitempty"`
+}
+
+type configReport struct {
+	Requested int             `json:"requested"`
+	Problems  []configProblem `json:"problems"`
+}
+
+// kconfigSymbols returns the names (with CONFIG_ prefix) of all options
+// declared in the Kconfig files of the kernel source tree srcdir.
+func kconfigSymbols(srcdir string) (map[string]bool, error) {
+	symbols := make(map[string]bool)
+	err := filepath.Walk(srcdir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasPrefix(info.Name(), "Kconfig") {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 2 && (fields[0] == "config" || fields[0] == "menuconfig") {
+				symbols["CONFIG_"+fields[1]] = true
+			}
+		}
+		return scanner.Err()
+	})
+	return symbols, err
+}
+
+// renameCandidates guesses which existing options option was merged into by
+// dropping one component of its name, e.g. CONFIG_NF_NAT_IPV4 → CONFIG_NF_NAT.
+func renameCandidates(option string, symbols map[string]bool) []string {
+	parts := strings.Split(strings.TrimPrefix(option, "CONFIG_"), "_")
+	if len(parts) < 3 {
+		return nil
+	}
+	var candidates []string
+	for i := range parts {
+		rest := append(append([]string{}, parts[:i]...), parts[i+1:]...)
+		if candidate := "CONFIG_" + strings.Join(rest, "_"); symbols[candidate] {
+			candidates = append(candidates, candidate)
+		}
+	}
+	return candidates
+}
+
+// checkConfig compares the .config in srcdir after make olddefconfig with the
+// requested addendum.
+func checkConfig(srcdir string, addendum []kconfig.Option) (*configReport, error) {
+	config, err := kconfig.ParseFile(filepath.Join(srcdir, ".config"))
+	if err != nil {
+		return nil, err
+	}
+	symbols, err := kconfigSymbols(srcdir)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &configReport{
+		Requested: len(addendum),
+		Problems:  []configProblem{},
+	}
+	for _, o := range addendum {
+		option, want := o.Name, o.Value
+		got := config.Value(option)
+		if got == want {
+			continue
+		}
+		p := configProblem{
+			Option:    option,
+			Requested: want,
+			Actual:    got,
+			Source:    o.Source,
+		}
+		switch {
+		case !symbols[option]:
+			if want == "n" {
+				continue // not set either way
+			}
+			p.Status = statusUnknown
+			p.Actual = ""
+			p.Candidates = renameCandidates(option, symbols)
+		case want == "y" && got == "m":
+			p.Status = statusDowngraded
+		case got == "n":
+			p.Status = statusDropped
+		default:
+			p.Status = statusChanged
+		}
+		report.Problems = append(report.Problems, p)
+	}
+	return report, nil
+}
+
+// logSummary prints a human-readable summary of the report.
+func (r *configReport) logSummary() {
+	if len(r.Problems) == 0 {
+		log.Printf("config check: all %d requested options took effect", r.Requested)
+		return
+	}
+	log.Printf("config check: %d of %d requested options did not take effect:", len(r.Problems), r.Requested)
+	for _, p := range r.Problems {
+		switch p.Status {
+		case statusUnknown:
+			msg := fmt.Sprintf("  %s=%s: %s (no longer exists)", p.Option, p.Requested, p.Status)
+			if len(p.Candidates) > 0 {
+				msg += fmt.Sprintf(", renamed to %s?", strings.Join(p.Candidates, " or "))
+			}
+			log.Print(msg)
+		default:
+			log.Printf("  %s=%s: %s (now %s)", p.Option, p.Requested, p.Status, p.Actual)
+		}
+	}
+}
+
+// err returns an error if strict is set and any requested option did not take
+// effect.
+func (r *configReport) err(strict bool) error {
+	if strict && len(r.Problems) > 0 {
+		return fmt.Errorf("-strict: %d requested config options did not take effect", len(r.Problems))
+	}
+	return nil
+}
+
+func (r *configReport) writeJSON(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
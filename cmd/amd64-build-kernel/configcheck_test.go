@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/kconfig"
+)
+
+// checkTestConfig checks testdata/olddefconfig/src, a stripped-down kernel
+// source tree after make olddefconfig, against testdata/olddefconfig/addendum.
+func checkTestConfig(t *testing.T) *configReport {
+	t.Helper()
+	addendum, err := kconfig.ParseFragment(os.DirFS("testdata/olddefconfig"), "addendum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := checkConfig("testdata/olddefconfig/src", addendum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestCheckConfig(t *testing.T) {
+	report := checkTestConfig(t)
+	if report.Requested != 8 {
+		t.Errorf("Requested = %d, want 8", report.Requested)
+	}
+	want := []configProblem{
+		{Option: "CONFIG_HZ", Requested: "1000", Actual: "300", Status: statusChanged, Source: "addendum:7"},
+		{Option: "CONFIG_VIRTIO_BLK", Requested: "y", Actual: "m", Status: statusDowngraded, Source: "addendum:8"},
+		{Option: "CONFIG_SOFT_WATCHDOG", Requested: "y", Actual: "n", Status: statusDropped, Source: "addendum:9"},
+		{Option: "CONFIG_NF_NAT_IPV4", Requested: "y", Status: statusUnknown, Source: "addendum:10", Candidates: []string{"CONFIG_NF_NAT"}},
+		{Option: "CONFIG_SYSFS_DEPRECATED_V2", Requested: "y", Status: statusUnknown, Source: "addendum:11"},
+	}
+	if !reflect.DeepEqual(report.Problems, want) {
+		t.Errorf("checkConfig() problems:\n%+v\nwant:\n%+v", report.Problems, want)
+	}
+}
+
+func TestConfigReportErr(t *testing.T) {
+	report := checkTestConfig(t)
+	if err := report.err(false); err != nil {
+		t.Errorf("err(false) = %v, want nil", err)
+	}
+	const want = "-strict: 5 requested config options did not take effect"
+	if err := report.err(true); err == nil || err.Error() != want {
+		t.Errorf("err(true) = %v, want %q", err, want)
+	}
+	ok := &configReport{Requested: 8, Problems: []configProblem{}}
+	if err := ok.err(true); err != nil {
+		t.Errorf("err(true) without problems = %v, want nil", err)
+	}
+}
+
+func TestConfigReportWriteJSON(t *testing.T) {
+	report := checkTestConfig(t)
+	path := filepath.Join(t.TempDir(), "config-report.json")
+	if err := report.writeJSON(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("testdata/olddefconfig/config-report.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("writeJSON() wrote:\n%s\nwant:\n%s", got, want)
+	}
+
+	// A report without problems has an empty list, not null.
+	empty := &configReport{Requested: 1, Problems: []configProblem{}}
+	if err := empty.writeJSON(path); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(path); err != nil || !strings.Contains(string(got), `"problems": []`) {
+		t.Errorf("writeJSON() without problems wrote %s (%v), want an empty problems list", got, err)
+	}
+}
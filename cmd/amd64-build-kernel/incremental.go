@@ -0,0 +1,255 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/kversion"
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/patch"
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/source"
+)
+
+// Incremental upgrades create the source tree of a stable release by applying
+// the kernel.org incremental patch (e.g. 6.8.1→6.8.2) to the cached source
+// tree of the previous release, saving the download of the full tarball.
+//
+// The upgraded tree is trusted because of a chain of checks: the first tree
+// of the chain was unpacked from a tarball verified against the signed
+// kernel.org checksums, every cached tree is checked against the tree digest
+// recorded when it was stored, the incremental patch is verified using its
+// detached kernel.org signature, and every hunk must apply exactly (without
+// fuzz or offset). To keep the chain short, an upgraded tree is also compared
+// with the tree unpacked from the verified upstream tarball whenever its base
+// tree was not (see checkUpgrade), i.e. at least every other release, and on
+// every upgrade with -verify-incremental. If the trees differ, the tarball is
+// used.
+var (
+	incremental = flag.Bool("incremental",
+		true,
+		"With -cache-dir, create the kernel source by applying the kernel.org incremental patch to the cached source tree of the previous release, falling back to the full tarball")
+
+	verifyIncremental = flag.Bool("verify-incremental",
+		false,
+		"Compare every incrementally upgraded source tree with the full tarball, not only those whose base tree was not compared itself")
+)
+
+// previousVersion returns the release which the incremental patch to v
+// applies to, e.g. 6.8.1 for 6.8.2 and 6.8 for 6.8.1.
+func previousVersion(v kversion.Version) (string, bool) {
+	if len(v.Numbers) != 3 || v.RC != 0 || v.Numbers[2] == 0 {
+		return "", false
+	}
+	if v.Numbers[2] == 1 {
+		return v.Series(), true
+	}
+	return fmt.Sprintf("%s.%d", v.Series(), v.Numbers[2]-1), true
+}
+
+// checkSourceVersion guards against a url.go whose version does not match
+// the source in srcdir.
+func checkSourceVersion(srcdir string) error {
+	want, err := latest.ParsedVersion()
+	if err != nil {
+		return err
+	}
+	got, err := source.KernelVersion(srcdir)
+	if err != nil {
+		return err
+	}
+	if got.Compare(want) != 0 {
+		return fmt.Errorf("kernel source is Linux %s, but url.go declares %s", got, want)
+	}
+	return nil
+}
+
+// upgradeTree creates the source tree of latest in the current directory from
+// the cached tree of the previous release.
+func upgradeTree(cache *source.Cache) (string, *source.TreeInfo, error) {
+	v, err := latest.ParsedVersion()
+	if err != nil {
+		return "", nil, err
+	}
+	base, ok := previousVersion(v)
+	if !ok || latest.IncrementalURL == "" {
+		return "", nil, fmt.Errorf("no incremental patch to Linux %s", v)
+	}
+	baseDir, baseInfo, err := cache.LookupTree(base)
+	if err != nil {
+		return "", nil, err
+	}
+
+	log.Printf("downloading incremental patch: %s", latest.IncrementalURL)
+	patchPath, patchSum, err := source.Download(latest.IncrementalURL, ".", cache)
+	if err != nil {
+		return "", nil, err
+	}
+	if *skipVerify {
+		log.Printf("WARNING: not verifying incremental patch (sha256 %s)", patchSum)
+	} else if err := source.VerifyPatch(latest.IncrementalURL, patchPath, *keyringPath); err != nil {
+		discardDownload(latest.IncrementalURL)
+		return "", nil, err
+	}
+	f, err := os.Open(patchPath)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	r, wait, err := source.Decompress(latest.IncrementalURL, f)
+	if err != nil {
+		return "", nil, err
+	}
+	files, err := patch.Parse(r, 1)
+	if werr := wait(); err == nil {
+		err = werr
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %v", latest.IncrementalURL, err)
+	}
+
+	srcdir := "linux-" + latest.Version
+	if err := os.RemoveAll(srcdir); err != nil {
+		return "", nil, err
+	}
+	log.Printf("upgrading cached Linux %s source to %s", base, v)
+	if err := source.CopyTree(srcdir, baseDir); err != nil {
+		// prepareSource removes the partially copied tree.
+		return srcdir, nil, err
+	}
+	results, err := patch.Apply(patch.DirTree(srcdir), files, patch.Options{MaxFuzz: 0})
+	if err != nil {
+		return srcdir, nil, fmt.Errorf("incremental patch does not apply:\n%v", err)
+	}
+	for _, r := range results {
+		if r.Offset != 0 {
+			return srcdir, nil, fmt.Errorf("incremental patch does not apply exactly: %s", r)
+		}
+	}
+	if err := checkSourceVersion(srcdir); err != nil {
+		return srcdir, nil, err
+	}
+	digest, err := source.TreeDigest(srcdir)
+	if err != nil {
+		return srcdir, nil, err
+	}
+	info := &source.TreeInfo{
+		Version:        latest.Version,
+		TreeSHA256:     digest,
+		BaseVersion:    base,
+		BaseTreeSHA256: baseInfo.TreeSHA256,
+		PatchURL:       latest.IncrementalURL,
+		PatchSHA256:    patchSum,
+	}
+	if *verifyIncremental || baseInfo.SourceSHA256 == "" {
+		if info, err = checkUpgrade(srcdir, info); err != nil {
+			return srcdir, nil, err
+		}
+	}
+	return srcdir, info, nil
+}
+
+// unpackTarball downloads, verifies and unpacks the full source tarball of
+// latest into dir (see source.Unpack).
+func unpackTarball(dir string) (string, *source.TreeInfo, error) {
+	var cache *source.Cache
+	if *cacheDir != "" {
+		cache = &source.Cache{Dir: *cacheDir}
+	}
+	log.Printf("downloading and unpacking Linux %s source: %s", latest.Version, latest.SourceURL)
+	srcdir, sum, err := source.Unpack(dir, latest.SourceURL, cache, source.VerifyOptions{
+		KeyringPath: *keyringPath,
+		SHA256:      latest.SHA256,
+		Insecure:    *skipVerify,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	if cache != nil {
+		// The tarball just used is kept even if it alone exceeds
+		// -cache-max-size.
+		if err := cache.Evict(*cacheMaxAge, *cacheMaxSize, sum); err != nil {
+			log.Printf("cache eviction failed: %v", err)
+		}
+	}
+	if err := checkSourceVersion(srcdir); err != nil {
+		return "", nil, err
+	}
+	digest, err := source.TreeDigest(srcdir)
+	if err != nil {
+		return "", nil, err
+	}
+	return srcdir, &source.TreeInfo{
+		Version:      latest.Version,
+		TreeSHA256:   digest,
+		SourceURL:    latest.SourceURL,
+		SourceSHA256: sum,
+	}, nil
+}
+
+// checkUpgrade compares the incrementally upgraded tree srcdir described by
+// info with the tree unpacked from the verified full tarball. If they differ,
+// srcdir is replaced by the tree of the tarball. It returns the description
+// of srcdir, which includes the tarball either way.
+func checkUpgrade(srcdir string, info *source.TreeInfo) (*source.TreeInfo, error) {
+	// The temporary directory is next to srcdir, so that the tree of the
+	// tarball can be moved into its place.
+	tmp, err := os.MkdirTemp(filepath.Dir(srcdir), "verify-incremental")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	fulldir, full, err := unpackTarball(tmp)
+	if err != nil {
+		return nil, err
+	}
+	if full.TreeSHA256 != info.TreeSHA256 {
+		log.Printf("WARNING: incrementally upgraded source tree (digest %s) differs from %s (digest %s), using the tarball", info.TreeSHA256, latest.SourceURL, full.TreeSHA256)
+		if err := os.RemoveAll(srcdir); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(fulldir, srcdir); err != nil {
+			return nil, err
+		}
+		return full, nil
+	}
+	log.Printf("incrementally upgraded source tree matches %s", latest.SourceURL)
+	info.SourceURL, info.SourceSHA256 = full.SourceURL, full.SourceSHA256
+	return info, nil
+}
+
+// prepareSource provides the verified, unpatched kernel source of latest in
+// the current directory and returns its directory and how it was obtained.
+func prepareSource() (string, *source.TreeInfo, error) {
+	var cache *source.Cache
+	if *cacheDir != "" {
+		cache = &source.Cache{Dir: *cacheDir}
+	}
+	if cache != nil && *incremental && latest.IncrementalURL != "" {
+		srcdir, info, err := upgradeTree(cache)
+		if err == nil {
+			if err := cache.StoreTree(srcdir, info); err != nil {
+				log.Printf("caching source tree failed: %v", err)
+			}
+			return srcdir, info, nil
+		}
+		log.Printf("incremental upgrade not possible, using the full tarball: %v", err)
+		if srcdir != "" {
+			if err := os.RemoveAll(srcdir); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+
+	srcdir, info, err := unpackTarball(".")
+	if err != nil {
+		return "", nil, err
+	}
+	if cache != nil {
+		if err := cache.StoreTree(srcdir, info); err != nil {
+			log.Printf("caching source tree failed: %v", err)
+		}
+	}
+	return srcdir, info, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"path/filepath"
+
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/kconfig"
+)
+
+var allowModules = flag.String("allow-modules",
+	"",
+	"Comma-separated list of boot-critical options (e.g. CONFIG_SP5100_TCO for machines without that watchdog) which need not be built in")
+
+// builtinPolicy lists the options which must be built in (=y). gokrazy boots
+// without an initramfs and has no module autoloading, so a driver which the
+// machine needs before (or without) userspace loading modules must not end
+// up as a module, neither through olddefconfig nor through an edit of a
+// config fragment.
+var builtinPolicy = []kconfig.PolicyClass{
+	{
+		Name:   "root storage",
+		Reason: "the kernel mounts the root file system (root= in cmdline.txt) from the boot disk before any module can be loaded",
+		Options: []string{
+			"CONFIG_EFI_PARTITION",
+			"CONFIG_ATA",
+			"CONFIG_SATA_AHCI",
+			"CONFIG_BLK_DEV_SD",
+			"CONFIG_BLK_DEV_NVME",
+			"CONFIG_VIRTIO_PCI",
+			"CONFIG_VIRTIO_BLK",
+			"CONFIG_USB_XHCI_HCD",
+			"CONFIG_USB_EHCI_HCD",
+			"CONFIG_USB_STORAGE",
+		},
+	},
+	{
+		Name:   "root file system",
+		Reason: "the root file system is squashfs, /dev and /tmp are mounted by gokrazy's init and /perm is ext4",
+		Options: []string{
+			"CONFIG_SQUASHFS",
+			"CONFIG_SQUASHFS_ZLIB",
+			"CONFIG_DEVTMPFS",
+			"CONFIG_TMPFS",
+			"CONFIG_EXT4_FS",
+		},
+	},
+	{
+		Name:   "console",
+		Reason: "boot messages and panics must reach the serial console and the framebuffer",
+		Options: []string{
+			"CONFIG_SERIAL_8250",
+			"CONFIG_SERIAL_8250_CONSOLE",
+			"CONFIG_VT",
+			"CONFIG_VT_CONSOLE",
+			"CONFIG_FB",
+			"CONFIG_FB_EFI",
+		},
+	},
+	{
+		Name:   "watchdog",
+		Reason: "gokrazy's init opens /dev/watchdog at boot, without loading modules",
+		Options: []string{
+			"CONFIG_WATCHDOG",
+			"CONFIG_SP5100_TCO",
+			"CONFIG_I6300ESB_WDT",
+		},
+	},
+}
+
+// checkPolicy checks the final .config in srcdir against builtinPolicy.
+func checkPolicy(srcdir string, addendum []kconfig.Option) error {
+	config, err := kconfig.ParseFile(filepath.Join(srcdir, ".config"))
+	if err != nil {
+		return err
+	}
+	allowed := kconfig.SplitList(*allowModules)
+	var violations []kconfig.Violation
+	for _, v := range kconfig.CheckPolicy(builtinPolicy, config, addendum) {
+		if allowed[v.Option] {
+			log.Printf("built-in policy: allowing %s=%s (-allow-modules)", v.Option, v.Value)
+			continue
+		}
+		violations = append(violations, v)
+	}
+	if len(violations) == 0 {
+		log.Printf("built-in policy: all boot-critical options are built in")
+	}
+	return kconfig.PolicyError(violations)
+}
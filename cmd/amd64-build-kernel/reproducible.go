@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// buildTimestamp returns the timestamp to embed into the kernel, so that
+// builds of the same release are identical. In order of preference, it is
+// taken from $SOURCE_DATE_EPOCH, the release date from releases.json, or the
+// modification time of the top-level Makefile of the kernel source in the
+// current directory (the commit date for kernel.org tarballs).
+func buildTimestamp() (time.Time, error) {
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		sec, err := strconv.ParseInt(epoch, 0, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH: %v", err)
+		}
+		return time.Unix(sec, 0).UTC(), nil
+	}
+	if latest.Released != 0 {
+		return time.Unix(latest.Released, 0).UTC(), nil
+	}
+	st, err := os.Stat("Makefile")
+	if err != nil {
+		return time.Time{}, err
+	}
+	return st.ModTime().UTC(), nil
+}
+
+// normalizeModules sets the permissions and modification times of all files
+// installed below dir (in lexical order), so that the module tree does not
+// depend on the order in which the parallel modules_install created them.
+func normalizeModules(dir string, ts time.Time) error {
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+	// Change directories last, as changing their contents changes their
+	// modification time.
+	for i := len(paths) - 1; i >= 0; i-- {
+		path := paths[i]
+		st, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		switch {
+		case st.Mode()&os.ModeSymlink != 0:
+			continue
+		case st.IsDir():
+			err = os.Chmod(path, 0755)
+		default:
+			err = os.Chmod(path, 0644)
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.Chtimes(path, ts, ts); err != nil {
+			return err
+		}
+	}
+	log.Printf("normalized %d files below %s", len(paths), dir)
+	return nil
+}
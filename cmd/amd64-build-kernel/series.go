@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/patch"
+)
+
+// maxFuzz is the number of context lines which may be ignored when applying
+// a hunk, matching the GNU patch default.
+const maxFuzz = 2
+
+// applySeries applies entries to t, logging hunks which applied with offset
+// or fuzz.
+func applySeries(t patch.Tree, entries []patch.SeriesEntry) error {
+	for _, e := range entries {
+		files, err := patch.ParseFile(e.Path, e.Strip)
+		if err != nil {
+			return fmt.Errorf("%s: %v", e.Line, err)
+		}
+		results, err := patch.Apply(t, files, patch.Options{MaxFuzz: maxFuzz})
+		for _, r := range results {
+			if r.Offset != 0 || r.Fuzz != 0 {
+				log.Printf("%s: %s", e.Patch, r)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s: patch %s does not apply:\n%v", e.Line, e.Patch, err)
+		}
+	}
+	return nil
+}
+
+// applyPatches applies the patches of the series in the current directory to
+// srcdir and returns the file names of the applied patches. All patches are
+// checked before any of them is applied.
+func applyPatches(srcdir string) ([]string, error) {
+	entries, err := patch.ReadSeries(".")
+	if err != nil {
+		return nil, err
+	}
+	v, err := latest.ParsedVersion()
+	if err != nil {
+		return nil, err
+	}
+	var applicable []patch.SeriesEntry
+	for _, e := range entries {
+		if !e.Applies(v) {
+			log.Printf("skipping patch %q: not applicable to Linux %s (%v)", e.Patch, v, e.Constraints)
+			continue
+		}
+		applicable = append(applicable, e)
+	}
+
+	// Dry run: apply the whole series in memory, so that later patches are
+	// checked on top of earlier ones without modifying srcdir.
+	log.Printf("checking that %d patches apply to Linux %s", len(applicable), v)
+	if err := applySeries(&patch.Overlay{Base: patch.DirTree(srcdir)}, applicable); err != nil {
+		return nil, fmt.Errorf("Linux %s: %v", v, err)
+	}
+
+	log.Printf("applying %d patches", len(applicable))
+	if err := applySeries(patch.DirTree(srcdir), applicable); err != nil {
+		return nil, err
+	}
+	var patches []string
+	for _, e := range applicable {
+		patches = append(patches, e.Patch)
+	}
+	return patches, nil
+}
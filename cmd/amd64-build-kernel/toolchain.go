@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os/exec"
+)
+
+var (
+	toolchain = flag.String("toolchain",
+		"gcc",
+		"Compiler toolchain to build the kernel with: gcc or clang (LLVM=1)")
+
+	crossCompile = flag.String("cross-compile",
+		"",
+		"CROSS_COMPILE prefix for building the gcc toolchain on a non-amd64 host, e.g. x86_64-linux-gnu-")
+)
+
+// makeVars returns the make variables selecting the toolchain. They must be
+// passed to every make invocation, as the kernel configuration depends on the
+// compiler.
+func makeVars() ([]string, error) {
+	switch *toolchain {
+	case "gcc":
+		if *crossCompile != "" {
+			return []string{"ARCH=x86_64", "CROSS_COMPILE=" + *crossCompile}, nil
+		}
+		return nil, nil
+	case "clang":
+		return []string{"ARCH=x86_64", "LLVM=1"}, nil
+	default:
+		return nil, fmt.Errorf("unknown -toolchain %q, expected gcc or clang", *toolchain)
+	}
+}
+
+// makeCommand returns a make command for the selected toolchain.
+func makeCommand(args ...string) *exec.Cmd {
+	vars, err := makeVars()
+	if err != nil {
+		// -toolchain is validated in main.
+		panic(err)
+	}
+	return exec.Command("make", append(vars, args...)...)
+}
+
+// toolchainTools returns the names of the tools whose versions are recorded
+// in the build info.
+func toolchainTools() []string {
+	if *toolchain == "clang" {
+		return []string{"clang", "ld.lld", "make"}
+	}
+	return []string{*crossCompile + "gcc", *crossCompile + "ld", "make"}
+}
@@ -1,5 +1,13 @@
-
 package main
 
-// see https://www.kernel.org/releases.json
-var latest = "https://cdn.kernel.org/pub/linux/kernel/v6.x/linux-6.8.2.tar.xz"
+import "development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/release"
+
+// latest is the kernel release to build, see https://www.kernel.org/releases.json
+var latest = release.Descriptor{
+	Version:        "6.8.2",
+	Moniker:        "stable",
+	SourceURL:      "https://cdn.kernel.org/pub/linux/kernel/v6.x/linux-6.8.2.tar.xz",
+	SHA256:         "",
+	Released:       1711624393,
+	IncrementalURL: "https://cdn.kernel.org/pub/linux/kernel/v6.x/incr/patch-6.8.1-2.xz",
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/release"
+)
+
+// TestURLFile checks that url.go is the output of release.URLFile, i.e. that
+// it was written by amd64-update-kernel and not edited by hand.
+func TestURLFile(t *testing.T) {
+	b, err := os.ReadFile("url.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := release.URLFile(latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, want) {
+		t.Errorf("url.go differs from release.URLFile(latest):\n%s\nwant:\n%s", b, want)
+	}
+}
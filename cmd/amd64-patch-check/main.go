@@ -0,0 +1,226 @@
+// amd64-patch-check applies the kernel patches to a kernel release (by
+// default the release of the -channel) and reports the hunks which no longer
+// apply, along with the most similar location in the new source, so that
+// patches can be refreshed before a build is triggered.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/kversion"
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/patch"
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/release"
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/source"
+)
+
+// maxFuzz matches the fuzz factor amd64-build-kernel applies patches with.
+const maxFuzz = 2
+
+var (
+	channel = flag.String("channel", "stable", release.ChannelUsage)
+
+	version = flag.String("version",
+		"",
+		"Kernel version to check the patches against, even if releases.json no longer lists it. Empty means the release -channel points to")
+
+	patchDir = flag.String("patches",
+		"patches",
+		"Directory containing the patches and optionally a series file")
+
+	keyringPath = flag.String("keyring",
+		"",
+		"Path to an OpenPGP keyring containing the kernel.org signing keys. If empty, the kernel.org keyring pinned in internal/source/keyring is used")
+
+	skipVerify = flag.Bool("insecure-skip-verify", false, "Skip checksum and signature verification of the kernel source")
+
+	cacheDir = flag.String("cache-dir",
+		"",
+		"Directory in which downloaded kernel sources are cached across runs. Empty disables caching")
+
+	keep = flag.Bool("keep",
+		false,
+		"Keep the unpacked kernel source (without the patches applied) for refreshing the patches")
+)
+
+// releaseURL returns the version and source tarball URL of version v or, if
+// v is empty, of the release channel ch points to (see release.Select).
+// Versions which releases.json no longer lists are assumed to be stable
+// releases on the kernel.org CDN.
+func releaseURL(ch, v string) (string, string, error) {
+	resp, err := release.Fetch(release.ReleasesURL)
+	if err != nil {
+		return "", "", err
+	}
+	if v == "" {
+		r, err := resp.Select(ch)
+		if err != nil {
+			return "", "", err
+		}
+		return r.Version, r.Source, nil
+	}
+	if r := resp.Find(v); r != nil {
+		return v, r.Source, nil
+	}
+	parsed, err := kversion.Parse(v)
+	if err != nil {
+		return "", "", err
+	}
+	if parsed.RC != 0 {
+		return "", "", fmt.Errorf("release candidate %s is not listed in releases.json", v)
+	}
+	return v, fmt.Sprintf("https://cdn.kernel.org/pub/linux/kernel/v%d.x/linux-%s.tar.xz", parsed.Numbers[0], v), nil
+}
+
+// fetchSource downloads, verifies and unpacks the kernel source of url into
+// dir and returns the path of the source tree.
+func fetchSource(url, dir string) (string, error) {
+	var cache *source.Cache
+	if *cacheDir != "" {
+		cache = &source.Cache{Dir: *cacheDir}
+	}
+	log.Printf("downloading and unpacking kernel source: %s", url)
+	srcdir, _, err := source.Unpack(dir, url, cache, source.VerifyOptions{
+		KeyringPath: *keyringPath,
+		Insecure:    *skipVerify,
+	})
+	return srcdir, err
+}
+
+// indent prefixes each line of s with two spaces.
+func indent(s string) string {
+	s = strings.TrimSuffix(s, "\n")
+	return "  " + strings.ReplaceAll(s, "\n", "\n  ") + "\n"
+}
+
+// reportFailure prints a failed hunk and the location in t which resembles
+// it the most.
+func reportFailure(t patch.Tree, files []*patch.File, r patch.HunkResult) {
+	fmt.Printf("  %s\n", r)
+	for _, f := range files {
+		if f.Name() != r.File {
+			continue
+		}
+		h := f.Hunks[r.Hunk-1]
+		fmt.Print(indent(h.String()))
+		content, _, err := t.ReadFile(f.OldName)
+		if err != nil {
+			fmt.Printf("  %v\n", err)
+			return
+		}
+		m := patch.NearestMatch(content, h, r.Line)
+		if m.Line == 0 {
+			fmt.Printf("  no similar lines found in %s\n", f.OldName)
+			return
+		}
+		fmt.Printf("  nearest match at line %d (%.0f%% of the expected lines):\n", m.Line, 100*m.Similarity)
+		for i, l := range m.Lines {
+			fmt.Printf("  %6d  %s", m.Line+i, l)
+			if !strings.HasSuffix(l, "\n") {
+				fmt.Println()
+			}
+		}
+		fmt.Println()
+		return
+	}
+}
+
+// check applies the applicable patches of the series in memory, each on top
+// of the previous ones, and reports the results. It returns the number of
+// patches which do not apply.
+func check(srcdir string, entries []patch.SeriesEntry) (int, error) {
+	v, err := source.KernelVersion(srcdir)
+	if err != nil {
+		return 0, err
+	}
+	fmt.Printf("Linux %s:\n", v)
+	t := &patch.Overlay{Base: patch.DirTree(srcdir)}
+	failed := 0
+	for _, e := range entries {
+		if !e.Applies(v) {
+			fmt.Printf("%s: skipped (not applicable to %s)\n", e.Patch, v)
+			continue
+		}
+		files, err := patch.ParseFile(e.Path, e.Strip)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %v", e.Line, err)
+		}
+		results, err := patch.Apply(t, files, patch.Options{MaxFuzz: maxFuzz})
+		if err == nil {
+			fmt.Printf("%s: ok\n", e.Patch)
+			for _, r := range results {
+				if r.Offset != 0 || r.Fuzz != 0 {
+					fmt.Printf("  %s (consider refreshing)\n", r)
+				}
+			}
+			continue
+		}
+		failed++
+		fmt.Printf("%s: FAILED\n", e.Patch)
+		applyErr, ok := err.(*patch.ApplyError)
+		if !ok {
+			return 0, err
+		}
+		for _, err := range applyErr.Errs {
+			fmt.Printf("  %v\n", err)
+		}
+		// Files with failed hunks are left unmodified, so the nearest
+		// matches are searched in the content the patch was made for.
+		for _, r := range applyErr.Failed {
+			reportFailure(t, files, r)
+		}
+	}
+	return failed, nil
+}
+
+// run checks the patches and returns an error if they could not be checked or
+// do not all apply. Unless -keep is set, the unpacked kernel source is removed
+// before run returns.
+func run() error {
+	entries, err := patch.ReadSeries(*patchDir)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("no patches found in %s", *patchDir)
+	}
+
+	v, url, err := releaseURL(*channel, *version)
+	if err != nil {
+		return err
+	}
+	log.Printf("checking %d patches against Linux %s", len(entries), v)
+
+	dir, err := os.MkdirTemp("", "amd64-patch-check")
+	if err != nil {
+		return err
+	}
+	if !*keep {
+		defer os.RemoveAll(dir)
+	}
+	srcdir, err := fetchSource(url, dir)
+	if err != nil {
+		return err
+	}
+	failed, err := check(srcdir, entries)
+	if err != nil {
+		return err
+	}
+	if *keep {
+		log.Printf("kept unpacked kernel source in %s", srcdir)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d patches do not apply to Linux %s", failed, len(entries), v)
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/boottest"
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/kconfig"
+)
+
+var (
+	bootTest = flag.Bool("boot-test",
+		true,
+		"Boot the built kernel under QEMU (see amd64-boot-test) before pushing the build and the url.go update, and refuse to push if it does not boot. "+
+			"As this is the default, -enable-build requires QEMU (see -qemu and README.md) unless -boot-test=false is given")
+
+	qemu = flag.String("qemu", "qemu-system-x86_64", "QEMU binary for -boot-test")
+
+	bootTimeout = flag.Duration("boot-timeout",
+		10*time.Minute,
+		"Maximum time each -boot-test boot may take. Software emulation is slow")
+
+	diskControllers = flag.String("disk-controllers",
+		"",
+		"Comma-separated list of disk controllers ("+strings.Join(controllerNames(), ", ")+") to additionally boot a gokrazy disk image from with -boot-test, one boot each. cmdline.txt is used unchanged, so its root= must resolve on each of them. Empty means only the initramfs is booted")
+)
+
+func controllerNames() []string {
+	var names []string
+	for _, c := range boottest.Controllers {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+// checkBootTest fails early, before the lengthy build, if -boot-test cannot
+// run.
+func checkBootTest() error {
+	if _, err := exec.LookPath(*qemu); err != nil {
+		return fmt.Errorf("-boot-test requires QEMU: %v (install it, or use -boot-test=false to skip the boot test)", err)
+	}
+	for _, name := range strings.Split(*diskControllers, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if _, err := boottest.LookupController(name); err != nil {
+			return fmt.Errorf("-disk-controllers: %v", err)
+		}
+	}
+	return nil
+}
+
+// runBootTest boots the kernel at kernelPath from the initramfs and from a
+// disk image on each of -disk-controllers, checking /proc/config.gz against
+// the config fragments and the build results in resultDir.
+func runBootTest(kernelPath, resultDir string) error {
+	cmdlinePath, err := find("cmdline.txt")
+	if err != nil {
+		return err
+	}
+	cmdline, err := os.ReadFile(cmdlinePath)
+	if err != nil {
+		return err
+	}
+	configDir, err := find(path.Join(*buildPath, "config"))
+	if err != nil {
+		return err
+	}
+	expected, err := kconfig.LoadAddendum(os.DirFS(configDir), kconfig.SplitList(*fragments), kconfig.SplitList(*disableFragments))
+	if err != nil {
+		return err
+	}
+	if expected, err = boottest.SkipReported(expected, filepath.Join(resultDir, "config-report.json")); err != nil {
+		return err
+	}
+	b, err := os.ReadFile(filepath.Join(resultDir, "build-info.json"))
+	if err != nil {
+		return err
+	}
+	var info struct {
+		ConfigSHA256 string `json:"config_sha256"`
+	}
+	if err := json.Unmarshal(b, &info); err != nil {
+		return err
+	}
+
+	c := &boottest.Config{
+		Kernel:       kernelPath,
+		Cmdline:      strings.TrimSpace(string(cmdline)),
+		Expected:     expected,
+		ConfigSHA256: info.ConfigSHA256,
+		QEMU:         *qemu,
+		Memory:       512,
+		Timeout:      *bootTimeout,
+	}
+	if err := bootOnce(c, "initramfs"); err != nil {
+		return err
+	}
+	// The disk image boots check that the drivers needed to find the root
+	// file system of a gokrazy installation are built in.
+	var failed []string
+	for _, name := range strings.Split(*diskControllers, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		ctrl, err := boottest.LookupController(name)
+		if err != nil {
+			return err
+		}
+		dc := *c
+		dc.Controller = ctrl
+		if err := bootOnce(&dc, "disk image on "+ctrl.Name); err != nil {
+			log.Print(err)
+			failed = append(failed, ctrl.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("boot test failed on %s (use -disk-controllers to select others, or -boot-test=false to skip)", strings.Join(failed, ", "))
+	}
+	log.Printf("boot test passed")
+	return nil
+}
+
+// bootOnce boots the kernel as configured by c, describing the boot medium
+// as medium in the log.
+func bootOnce(c *boottest.Config, medium string) error {
+	var console bytes.Buffer
+	c.Console = &console
+	log.Printf("boot testing %s from the %s with %s", c.Kernel, medium, *qemu)
+	result, err := boottest.Run(c)
+	if err != nil {
+		return fmt.Errorf("boot test (%s): %v (use -boot-test=false to skip)", medium, err)
+	}
+	if err := result.Err(c); err != nil {
+		os.Stdout.Write(console.Bytes())
+		result.WriteProbes(os.Stdout)
+		return fmt.Errorf("boot test (%s): %v", medium, err)
+	}
+	result.WriteProbes(os.Stdout)
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/kconfig"
+)
+
+// configPath returns the path (relative to the directory containing
+// vmlinuz) at which the final .config of version is committed to its build
+// branch.
+func configPath(version string) string {
+	return filepath.Join("configs", version+".config")
+}
+
+// loadConfig returns the final .config of version, which is either a path to
+// a .config file or a version whose build branch contains it.
+func loadConfig(version string) (*kconfig.Config, error) {
+	if _, err := os.Stat(version); err == nil {
+		return kconfig.ParseFile(version)
+	}
+	kernelPath, err := find("vmlinuz")
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Join(filepath.Dir(kernelPath), configPath(version))
+	if filepath.IsAbs(name) {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		if name, err = filepath.Rel(wd, name); err != nil {
+			return nil, err
+		}
+	}
+	b, err := publisher.ReadBuildFile(version, name)
+	if err != nil {
+		return nil, fmt.Errorf("no config of Linux %s: %v", version, err)
+	}
+	return kconfig.Parse(bytes.NewReader(b))
+}
+
+// diffConfigs compares the final configs of two versions (see loadConfig),
+// highlighting new options relevant to the hardware the config fragments
+// enable.
+func diffConfigs(oldVersion, newVersion string, newConfig *kconfig.Config) (*kconfig.ConfigDiff, error) {
+	oldConfig, err := loadConfig(oldVersion)
+	if err != nil {
+		return nil, err
+	}
+	if newConfig == nil {
+		if newConfig, err = loadConfig(newVersion); err != nil {
+			return nil, err
+		}
+	}
+	configDir, err := find(path.Join(*buildPath, "config"))
+	if err != nil {
+		return nil, err
+	}
+	addendum, err := kconfig.LoadAddendum(os.DirFS(configDir), kconfig.SplitList(*fragments), kconfig.SplitList(*disableFragments))
+	if err != nil {
+		return nil, err
+	}
+	return kconfig.DiffConfigs(oldVersion, oldConfig, newVersion, newConfig, addendum), nil
+}
+
+// configDiffNotes returns the config diff from the build of previousVersion
+// to the .config at path as markdown for the build commit message, or ""
+// if there is no config of previousVersion to compare with.
+func configDiffNotes(previousVersion, path string) string {
+	newConfig, err := kconfig.ParseFile(path)
+	if err != nil {
+		log.Printf("not diffing the config: %v", err)
+		return ""
+	}
+	d, err := diffConfigs(previousVersion, latestVersion, newConfig)
+	if err != nil {
+		log.Printf("not diffing the config: %v", err)
+		return ""
+	}
+	var notes strings.Builder
+	if err := d.WriteMarkdown(&notes); err != nil {
+		log.Printf("not diffing the config: %v", err)
+		return ""
+	}
+	log.Printf("config changes from %s to %s: %d options, %d new relevant to the hardware", previousVersion, latestVersion, len(d.Changes), len(d.Highlighted))
+	return notes.String()
+}
+
+// configDiff implements the config-diff subcommand: it prints the diff of
+// the final configs of two versions as markdown.
+func configDiff(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: amd64-rebuild-kernel config-diff <old version or .config> <new version or .config>")
+	}
+	d, err := diffConfigs(args[0], args[1], nil)
+	if err != nil {
+		return err
+	}
+	return d.WriteMarkdown(os.Stdout)
+}
@@ -1,12 +1,11 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"os"
 	"os/exec"
 	"os/user"
@@ -14,6 +13,8 @@ import (
 	"path/filepath"
 	"strings"
 	"text/template"
+
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/release"
 )
 
 var (
@@ -24,34 +25,80 @@ var (
 
 	dobuild = flag.Bool("enable-build", false, "Enables building the kernel as well")
 
-	buildPath   = flag.String("build-path", "cmd/amd64-build-kernel", "Build Package path")
-	urlTemplate = `
-package main
+	buildPath = flag.String("build-path", "cmd/amd64-build-kernel", "Build Package path")
 
-// see https://www.kernel.org/releases.json
-var latest = "{{ . }}"
-`
+	cacheDir = flag.String("cache-dir",
+		"",
+		"Host directory to mount into the build container for caching kernel source downloads across builds")
+
+	fragments = flag.String("fragments",
+		"",
+		"Comma-separated list of kernel config fragments to use, passed on to amd64-build-kernel. Empty means all")
+
+	disableFragments = flag.String("disable-fragments",
+		"",
+		"Comma-separated list of kernel config fragments to skip, passed on to amd64-build-kernel")
+
+	strict = flag.Bool("strict",
+		false,
+		"Fail the build if any kernel config option did not take effect, passed on to amd64-build-kernel")
+
+	allowModules = flag.String("allow-modules",
+		"",
+		"Comma-separated list of boot-critical config options which need not be built in, passed on to amd64-build-kernel")
+
+	reproducible = flag.Bool("reproducible",
+		false,
+		"Require a reproducible build: the toolchain image must be pinned using -image-digest and -apt-snapshot")
+
+	imageDigest = flag.String("image-digest",
+		"",
+		"Digest (e.g. sha256:…) of the -base-image to pin")
+
+	channel = flag.String("channel", "stable", release.ChannelUsage)
+
+	keyringPath = flag.String("keyring",
+		"",
+		"Path to an OpenPGP keyring containing the kernel.org signing keys, used to verify the checksum recorded in url.go. If empty, the kernel.org keyring pinned in internal/source/keyring is used")
 )
 
 const (
-	releasesURL = "https://www.kernel.org/releases.json"
+	// containerCacheDir is where -cache-dir is mounted inside the container.
+	containerCacheDir = "/var/cache/amd64-build-kernel"
 )
 const dockerFileContents = `
-FROM debian:buster
-
-RUN apt-get update && apt-get install -y crossbuild-essential-arm64 bc libssl-dev bison flex kmod libelf-dev ncurses-dev
+FROM {{ .Image }}
+{{- if .AptSnapshot }}
+
+RUN for f in /etc/apt/sources.list /etc/apt/sources.list.d/debian.sources; do \
+      if [ -f "$f" ]; then \
+        sed -i \
+          -e 's,http://deb.debian.org/debian-security,http://snapshot.debian.org/archive/debian-security/{{ .AptSnapshot }},' \
+          -e 's,http://deb.debian.org/debian,http://snapshot.debian.org/archive/debian/{{ .AptSnapshot }},' \
+          "$f"; \
+      fi; \
+    done
+
+RUN apt-get -o Acquire::Check-Valid-Until=false update && apt-get install -y {{ join .Packages " " }}
+{{- else }}
+
+RUN apt-get update && apt-get install -y {{ join .Packages " " }}
+{{- end }}
 
 COPY amd64-build-kernel /usr/bin/amd64-build-kernel
 {{- range $idx, $path := .Patches }}
 COPY {{ $path }} /usr/src/{{ $path }}
 {{- end }}
+{{- if .Series }}
+COPY series /usr/src/series
+{{- end }}
 
 RUN echo 'builduser:x:{{ .Uid }}:{{ .Gid }}:nobody:/:/bin/sh' >> /etc/passwd && \
     chown -R {{ .Uid }}:{{ .Gid }} /usr/src
 
 USER builduser
 WORKDIR /usr/src
-ENTRYPOINT /usr/bin/amd64-build-kernel
+ENTRYPOINT ["/usr/bin/amd64-build-kernel"]
 `
 
 var dockerFileTmpl = template.Must(template.New("dockerfile").
@@ -59,11 +106,10 @@ var dockerFileTmpl = template.Must(template.New("dockerfile").
 		"basename": func(path string) string {
 			return filepath.Base(path)
 		},
+		"join": strings.Join,
 	}).
 	Parse(dockerFileContents))
 
-var patchFiles = []string{}
-
 func copyFile(dest, src string) error {
 	out, err := os.Create(dest)
 	if err != nil {
@@ -132,293 +178,425 @@ func getContainerExecutable() (string, error) {
 	return "", fmt.Errorf("none of %v found in $PATH", choices)
 }
 
-func main() {
-	flag.Parse()
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	err := updateVersion()
-	if err != nil && err.Error() != "no change" {
-		log.Fatal(err)
-	}
-
-	if err != nil && err.Error() == "no change" {
-		log.Println("No changes found, skipping the build")
-		return
-	}
-
+// buildImage builds the toolchain container image in which
+// amd64-build-kernel runs, using dir as build context. It returns the path to
+// the container executable.
+func buildImage(dir string, tc *toolchain) (string, error) {
 	executable, err := getContainerExecutable()
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 	if *overwriteContainerExecutable != "" {
 		executable = *overwriteContainerExecutable
 	}
 	execName := filepath.Base(executable)
-	// We explicitly use /tmp, because Docker only allows volume mounts under
-	// certain paths on certain platforms, see
-	// e.g. https://docs.docker.com/docker-for-mac/osxfs/#namespaces for macOS.
-	tmp, err := os.MkdirTemp("/tmp", "amd64-rebuild-kernel")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer os.RemoveAll(tmp)
 
 	cmd := exec.Command("go", "install", "development.thatwebsite.xyz/gokrazy/kernel-amd64/cmd/amd64-build-kernel")
-	cmd.Env = append(os.Environ(), "GOOS=linux", "GOBIN="+tmp, "CGO_ENABLED=0")
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOBIN="+dir, "CGO_ENABLED=0")
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
-		log.Fatalf("%v: %v", cmd.Args, err)
+		return "", fmt.Errorf("%v: %v", cmd.Args, err)
 	}
 
-	buildPath := filepath.Join(tmp, "amd64-build-kernel")
+	buildPath := filepath.Join(dir, "amd64-build-kernel")
 
-	var patchPaths []string
-	for _, filename := range patchFiles {
-		path, err := find(filename)
-		if err != nil {
-			log.Fatal(err)
-		}
-		patchPaths = append(patchPaths, path)
-	}
-
-	kernelPath, err := find("vmlinuz")
+	patches, series, err := discoverPatches()
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
-
-	libPath, err := find("lib")
-	if err != nil {
-		log.Fatal(err)
+	if err := validatePatches(patches); err != nil {
+		return "", err
 	}
 
 	// Copy all files into the temporary directory so that docker
-	// includes them in the build context.
-	for _, path := range patchPaths {
-		if err := copyFile(filepath.Join(tmp, filepath.Base(path)), path); err != nil {
-			log.Fatal(err)
+	// includes them in the build context. The patches keep their path
+	// relative to the series file, which lists them by that path.
+	var patchFiles []string
+	for _, e := range patches {
+		log.Printf("including patch %s", e.Path)
+		dest := filepath.Join(dir, filepath.FromSlash(e.Patch))
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return "", err
+		}
+		if err := copyFile(dest, e.Path); err != nil {
+			return "", err
+		}
+		patchFiles = append(patchFiles, e.Patch)
+	}
+	if series != "" {
+		if err := copyFile(filepath.Join(dir, "series"), series); err != nil {
+			return "", err
 		}
 	}
 
 	u, err := user.Current()
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
-	dockerFile, err := os.Create(filepath.Join(tmp, "Dockerfile"))
+	dockerFile, err := os.Create(filepath.Join(dir, "Dockerfile"))
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 
 	if err := dockerFileTmpl.Execute(dockerFile, struct {
-		Uid       string
-		Gid       string
-		BuildPath string
-		Patches   []string
+		Uid         string
+		Gid         string
+		BuildPath   string
+		Patches     []string
+		Series      bool
+		Image       string
+		Packages    []string
+		AptSnapshot string
 	}{
-		Uid:       u.Uid,
-		Gid:       u.Gid,
-		BuildPath: buildPath,
-		Patches:   patchFiles,
+		Uid:         u.Uid,
+		Gid:         u.Gid,
+		BuildPath:   buildPath,
+		Patches:     patchFiles,
+		Series:      series != "",
+		Image:       baseImage(),
+		Packages:    tc.Packages,
+		AptSnapshot: tc.AptSnapshot,
 	}); err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 
 	if err := dockerFile.Close(); err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 
 	log.Printf("building %s container for kernel compilation", execName)
 
-	dockerBuild := exec.Command(execName,
-		"build",
-		"--rm=true",
-		"--tag=amd64-rebuild-kernel",
-		".")
-	dockerBuild.Dir = tmp
+	dockerBuild := exec.Command(execName, imageBuildArgs(tc)...)
+	dockerBuild.Dir = dir
 	dockerBuild.Stdout = os.Stdout
 	dockerBuild.Stderr = os.Stderr
 	if err := dockerBuild.Run(); err != nil {
-		log.Fatalf("%s build: %v (cmd: %v)", execName, err, dockerBuild.Args)
+		return "", fmt.Errorf("%s build: %v (cmd: %v)", execName, err, dockerBuild.Args)
 	}
+	return executable, nil
+}
 
-	log.Printf("compiling kernel")
+// imageBuildArgs returns the container executable arguments building the
+// toolchain image in the build context directory.
+func imageBuildArgs(tc *toolchain) []string {
+	return []string{
+		"build",
+		"--rm=true",
+		"--tag=" + tc.imageTag(),
+		".",
+	}
+}
 
-	var dockerRun *exec.Cmd
+// containerRunArgs returns the container executable arguments running
+// amd64-build-kernel, and the host directory to mount as cache (if any).
+func containerRunArgs(execName string, tc *toolchain, resultDir string) ([]string, string, error) {
+	runArgs := []string{
+		"run",
+		"--rm",
+		"--volume", resultDir + ":/tmp/buildresult:Z",
+	}
 	if execName == "podman" {
-		dockerRun = exec.Command(executable,
-			"run",
-			"--userns=keep-id",
-			"--rm",
-			"--volume", tmp+":/tmp/buildresult:Z",
-			"amd64-rebuild-kernel")
-	} else {
-		dockerRun = exec.Command(executable,
-			"run",
-			"--rm",
-			"--volume", tmp+":/tmp/buildresult:Z",
-			"amd64-rebuild-kernel")
-	}
-	dockerRun.Dir = tmp
+		runArgs = append(runArgs, "--userns=keep-id")
+	}
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		runArgs = append(runArgs, "--env", "SOURCE_DATE_EPOCH="+epoch)
+	}
+	buildArgs := tc.buildArgs()
+	var cache string
+	if *cacheDir != "" {
+		abs, err := filepath.Abs(*cacheDir)
+		if err != nil {
+			return nil, "", err
+		}
+		cache = abs
+		runArgs = append(runArgs, "--volume", abs+":"+containerCacheDir+":Z")
+		buildArgs = append(buildArgs, "-cache-dir="+containerCacheDir)
+	}
+	if *fragments != "" {
+		buildArgs = append(buildArgs, "-fragments="+*fragments)
+	}
+	if *disableFragments != "" {
+		buildArgs = append(buildArgs, "-disable-fragments="+*disableFragments)
+	}
+	if *strict {
+		buildArgs = append(buildArgs, "-strict")
+	}
+	if *allowModules != "" {
+		buildArgs = append(buildArgs, "-allow-modules="+*allowModules)
+	}
+	runArgs = append(runArgs, tc.imageTag())
+	return append(runArgs, buildArgs...), cache, nil
+}
+
+// runBuild compiles the kernel in the container image built by buildImage.
+// The build results are placed in resultDir.
+func runBuild(executable string, tc *toolchain, resultDir string) error {
+	execName := filepath.Base(executable)
+	runArgs, cache, err := containerRunArgs(execName, tc, resultDir)
+	if err != nil {
+		return err
+	}
+	if cache != "" {
+		if err := os.MkdirAll(cache, 0755); err != nil {
+			return err
+		}
+	}
+	dockerRun := exec.Command(executable, runArgs...)
+	dockerRun.Dir = resultDir
 	dockerRun.Stdout = os.Stdout
 	dockerRun.Stderr = os.Stderr
 	if err := dockerRun.Run(); err != nil {
-		log.Fatalf("%s run: %v (cmd: %v)", execName, err, dockerRun.Args)
+		return fmt.Errorf("%s run: %v (cmd: %v)", execName, err, dockerRun.Args)
 	}
+	return nil
+}
 
-	if err := copyFile(kernelPath, filepath.Join(tmp, "vmlinuz")); err != nil {
-		log.Fatal(err)
+func main() {
+	flag.Parse()
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	if *reproducible && (*imageDigest == "" || *aptSnapshot == "") {
+		log.Fatal("-reproducible requires -image-digest and -apt-snapshot to pin the toolchain image and its packages")
 	}
 
-	// remove symlinks that only work when source/build directory are present
-	for _, subdir := range []string{"build", "source"} {
-		matches, err := filepath.Glob(filepath.Join(tmp, "lib/modules", "*", subdir))
-		if err != nil {
+	if flag.Arg(0) == "verify-reproducible" {
+		if err := verifyReproducible(); err != nil {
 			log.Fatal(err)
 		}
-		for _, match := range matches {
-			if err := os.Remove(match); err != nil {
-				log.Fatal(err)
-			}
-		}
+		return
 	}
 
-	// replace kernel modules directory
-	rm := exec.Command("rm", "-rf", filepath.Join(libPath, "modules"))
-	rm.Stdout = os.Stdout
-	rm.Stderr = os.Stderr
-	if err := rm.Run(); err != nil {
-		log.Fatalf("%v: %v", rm.Args, err)
+	config, err := publishFlags.Config()
+	if err != nil {
+		log.Fatal(err)
 	}
-	cp := exec.Command("cp", "-r", filepath.Join(tmp, "lib/modules"), libPath)
-	cp.Stdout = os.Stdout
-	cp.Stderr = os.Stderr
-	if err := cp.Run(); err != nil {
-		log.Fatalf("%v: %v", cp.Args, err)
+	publisher = &release.Publisher{PublishConfig: config}
+
+	if *printPlanOnly {
+		if err := printPlan(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "config-diff" {
+		if err := configDiff(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	if err := pushBuild(); err != nil {
+	if err := rebuild(); err == errNoChange {
+		log.Println("No changes found, skipping the build")
+	} else if err != nil {
 		log.Fatal(err)
 	}
 }
 
-func pushBuild() error {
-	if err := exec.Command("git", "add", ".").Run(); err != nil {
+// rebuild updates url.go and, with -enable-build, builds the kernel and
+// publishes the update together with the build once it passed the boot test
+// (without -enable-build, the update is published right away). All changes
+// are made in a temporary worktree of the base branch (-branch), so that the
+// working copy is left untouched.
+func rebuild() error {
+	// Flags naming host paths are resolved before changing into the
+	// worktree.
+	for _, p := range []*string{cacheDir, keyringPath} {
+		if *p == "" {
+			continue
+		}
+		abs, err := filepath.Abs(*p)
+		if err != nil {
+			return err
+		}
+		*p = abs
+	}
+	wd, err := os.Getwd()
+	if err != nil {
 		return err
 	}
-
-	if o, err := exec.Command("git", "commit", "-m", fmt.Sprintf("Built to version %s", latestVersion)).CombinedOutput(); err != nil {
-		log.Println(string(o[:]))
-		// log.Fatal(err)
-		log.Println("ignoring exit code 1")
+	publisher.Dir = wd
+	if err := publisher.CheckClean(); err != nil {
+		return err
 	}
-
-	if o, err := exec.Command("git", "push", "origin", fmt.Sprintf("build-%s", latestVersion)).CombinedOutput(); err != nil {
-		log.Println(string(o[:]))
+	worktree, err := publisher.Open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.Chdir(wd); err != nil {
+			log.Print(err)
+		}
+		if err := publisher.Close(); err != nil {
+			log.Printf("removing worktree: %v", err)
+		}
+	}()
+	if err := os.Chdir(worktree); err != nil {
 		return err
 	}
-	return nil
-}
 
-func updateVersion() error {
-	r, err := http.Get(releasesURL)
+	// The build is compared with the config of the previous build.
+	previous, err := os.ReadFile(path.Join(*buildPath, "url.go"))
 	if err != nil {
 		return err
 	}
-	var resp ReleasesResponse
-	defer r.Body.Close()
-	if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
+	previousVersion, err := release.URLFileVersion(previous)
+	if err != nil {
 		return err
 	}
 
-	downloadURL := ""
-	for _, release := range resp.Releases {
-		if release.Version == resp.LatestStable.Version {
-			downloadURL = release.Source
-			break
+	if err := updateVersion(); err != nil {
+		return err
+	}
+	if !*dobuild {
+		return nil
+	}
+	if *bootTest {
+		if err := checkBootTest(); err != nil {
+			return err
 		}
 	}
 
-	latestVersion = resp.LatestStable.Version
+	kernelPath, err := find("vmlinuz")
+	if err != nil {
+		return err
+	}
 
-	// update gokr-build-kernel in development branch
-	d, err := os.Create(path.Join(*buildPath, "url.go"))
+	libPath, err := find("lib")
 	if err != nil {
 		return err
 	}
-	defer d.Close()
 
-	t, err := template.New("url.go").Parse(urlTemplate)
+	// We explicitly use /tmp, because Docker only allows volume mounts under
+	// certain paths on certain platforms, see
+	// e.g. https://docs.docker.com/docker-for-mac/osxfs/#namespaces for macOS.
+	tmp, err := os.MkdirTemp("/tmp", "amd64-rebuild-kernel")
 	if err != nil {
 		return err
 	}
+	defer os.RemoveAll(tmp)
 
-	if err := t.ExecuteTemplate(d, "url.go", downloadURL); err != nil {
+	tc, err := newToolchain()
+	if err != nil {
 		return err
 	}
 
-	if o, err := exec.Command("git", "status", "--short", "cmd/amd64-build-kernel/url.go").CombinedOutput(); err != nil {
-		log.Println(string(o[:]))
+	executable, err := buildImage(tmp, tc)
+	if err != nil {
 		return err
-	} else {
-		if !*dobuild && strings.TrimSpace(string(o[:])) == "" {
-			return fmt.Errorf("no change")
+	}
+
+	log.Printf("compiling kernel")
+	if err := runBuild(executable, tc, tmp); err != nil {
+		return err
+	}
+
+	if b, err := os.ReadFile(filepath.Join(tmp, "config-report.json")); err == nil {
+		log.Printf("config report:\n%s", b)
+	}
+
+	if err := copyFile(kernelPath, filepath.Join(tmp, "vmlinuz")); err != nil {
+		return err
+	}
+
+	// remove symlinks that only work when source/build directory are present
+	for _, subdir := range []string{"build", "source"} {
+		matches, err := filepath.Glob(filepath.Join(tmp, "lib/modules", "*", subdir))
+		if err != nil {
+			return err
+		}
+		for _, match := range matches {
+			if err := os.Remove(match); err != nil {
+				return err
+			}
 		}
 	}
 
-	// commit it
-	if err := exec.Command("git", "checkout", "development").Run(); err != nil {
+	// replace kernel modules directory
+	modulesPath := filepath.Join(libPath, "modules")
+	if err := os.RemoveAll(modulesPath); err != nil {
 		return err
 	}
+	cp := exec.Command("cp", "-r", filepath.Join(tmp, "lib/modules"), libPath)
+	cp.Stdout = os.Stdout
+	cp.Stderr = os.Stderr
+	if err := cp.Run(); err != nil {
+		return fmt.Errorf("%v: %v", cp.Args, err)
+	}
 
-	if err := exec.Command("git", "add", path.Join(*buildPath, "url.go")).Run(); err != nil {
+	if err := writeManifest(filepath.Dir(kernelPath), tmp, executable, tc); err != nil {
 		return err
 	}
 
-	if o, err := exec.Command("git", "commit", "-m", fmt.Sprintf("Upgrade to version %s", latestVersion)).CombinedOutput(); err != nil {
-		log.Println(string(o[:]))
-		// log.Fatal(err)
-		log.Println("ignoring exit code 1")
+	if *bootTest {
+		if err := runBootTest(kernelPath, tmp); err != nil {
+			return err
+		}
 	}
 
-	if o, err := exec.Command("git", "push", "origin", "development").CombinedOutput(); err != nil {
-		log.Println(string(o[:]))
+	config := filepath.Join(filepath.Dir(kernelPath), configPath(latestVersion))
+	if err := os.MkdirAll(filepath.Dir(config), 0755); err != nil {
+		return err
+	}
+	if err := copyFile(config, filepath.Join(tmp, "config")); err != nil {
 		return err
 	}
+	notes := configDiffNotes(previousVersion, config)
+
+	return pushBuild(notes, kernelPath, modulesPath, config, filepath.Join(filepath.Dir(kernelPath), "build-manifest.json"))
+}
+
+var publishFlags = release.AddPublishFlags(flag.CommandLine)
+
+// publisher commits and pushes updates and builds, configured in main.
+var publisher *release.Publisher
+
+// errNoChange is returned by updateVersion if the selected release is
+// already the current one.
+var errNoChange = errors.New("no change")
+
+// pushBuild commits the build artifacts to the build branch and pushes it
+// together with the url.go update, with notes appended to the commit
+// message.
+func pushBuild(notes string, artifacts ...string) error {
+	err := publisher.PushBuild(latestVersion, notes, artifacts...)
+	if err == release.ErrUnchanged {
+		log.Printf("build of Linux %s is identical to the committed one, not pushing it", latestVersion)
+		return nil
+	}
+	return err
+}
 
-	if o, err := exec.Command("git", "checkout", "-B", fmt.Sprintf("build-%s", latestVersion)).CombinedOutput(); err != nil {
-		log.Println(string(o[:]))
+func updateVersion() error {
+	r, err := release.Resolve(release.ReleasesURL, *channel)
+	if err != nil {
+		return err
+	}
+	log.Printf("channel %s: Linux %s", *channel, r.Version)
+	latestVersion = r.Version
+
+	// update gokr-build-kernel in the base branch
+	d, err := release.NewDescriptor(r, *keyringPath)
+	if err != nil {
+		return err
+	}
+	// With -enable-build, the update is pushed together with the build,
+	// once it passed the boot test.
+	err = publisher.UpdateURLFile(path.Join(*buildPath, "url.go"), d, !*dobuild)
+	if err == release.ErrUnchanged {
+		if !*dobuild {
+			return errNoChange
+		}
+	} else if err != nil {
 		return err
 	}
 
 	if !*dobuild {
 		fmt.Println("*********************************")
 		fmt.Println()
-		fmt.Printf("Execute `go run %s` to build the kernel\n", path.Join(path.Dir(*buildPath), "amd64-rebuild-kernel", "kernel.go"))
+		fmt.Printf("Execute `go run ./%s -enable-build` to build the kernel\n", path.Join(path.Dir(*buildPath), "amd64-rebuild-kernel"))
 		fmt.Println()
 		fmt.Println("*********************************")
-		return nil
 	}
 	return nil
 }
-
-type ReleasesResponse struct {
-	LatestStable struct {
-		Version string `json:"version"`
-	} `json:"latest_stable"`
-	Releases []struct {
-		Iseol    bool        `json:"iseol"`
-		Version  string      `json:"version"`
-		Moniker  string      `json:"moniker"`
-		Source   string      `json:"source"`
-		Pgp      interface{} `json:"pgp"`
-		Released struct {
-			Timestamp int    `json:"timestamp"`
-			Isodate   string `json:"isodate"`
-		} `json:"released"`
-		Gitweb    string      `json:"gitweb"`
-		Changelog interface{} `json:"changelog"`
-		Diffview  string      `json:"diffview"`
-		Patch     struct {
-			Full        string `json:"full"`
-			Incremental string `json:"incremental"`
-		} `json:"patch"`
-	} `json:"releases"`
-}
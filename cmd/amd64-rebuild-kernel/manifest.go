@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/source"
+)
+
+// buildManifest records how vmlinuz and lib/modules were produced. The build
+// fields are filled from the build-info.json file written by
+// amd64-build-kernel.
+type buildManifest struct {
+	KernelVersion  string            `json:"kernel_version"`
+	SourceURL      string            `json:"source_url"`
+	SourceSHA256   string            `json:"source_sha256"`
+	SourceTree     *source.TreeInfo  `json:"source_tree"`
+	Patches        []fileHash        `json:"patches"`
+	ConfigSHA256   string            `json:"config_sha256"`
+	Toolchain      map[string]string `json:"toolchain"`
+	BuildTimestamp time.Time         `json:"build_timestamp"`
+
+	ContainerImage containerImage `json:"container_image"`
+	// Outputs maps the path of every output file (relative to the
+	// directory containing vmlinuz) to its sha256 checksum.
+	Outputs map[string]string `json:"outputs"`
+}
+
+type fileHash struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+}
+
+type containerImage struct {
+	Base string `json:"base"`
+	// AptSnapshot is the snapshot.debian.org timestamp the packages were
+	// installed from, empty if the packages were current.
+	AptSnapshot string `json:"apt_snapshot,omitempty"`
+	Tag         string `json:"tag"`
+	ID          string `json:"id"`
+}
+
+// imageID returns the ID (the sha256 digest of the image configuration) of
+// the build container image.
+func imageID(executable, tag string) (string, error) {
+	out, err := exec.Command(executable, "image", "inspect", "--format", "{{.Id}}", tag).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// writeManifest writes build-manifest.json next to vmlinuz in dir, based on
+// the build info in resultDir.
+func writeManifest(dir, resultDir, executable string, tc *toolchain) error {
+	var manifest buildManifest
+	b, err := os.ReadFile(filepath.Join(resultDir, "build-info.json"))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return err
+	}
+	manifest.ContainerImage.Base = baseImage()
+	manifest.ContainerImage.AptSnapshot = tc.AptSnapshot
+	manifest.ContainerImage.Tag = tc.imageTag()
+	if manifest.ContainerImage.ID, err = imageID(executable, tc.imageTag()); err != nil {
+		return err
+	}
+	if manifest.Outputs, err = hashFiles(dir, []string{"vmlinuz", filepath.Join("lib", "modules")}, nil); err != nil {
+		return err
+	}
+	b, err = json.MarshalIndent(&manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "build-manifest.json"), append(b, '\n'), 0644)
+}
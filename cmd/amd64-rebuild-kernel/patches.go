@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/patch"
+)
+
+// patchDir is the directory (relative to the repository) containing the
+// kernel patches and optionally a quilt-style series file.
+const patchDir = "patches"
+
+// discoverPatches returns the series entries of the patches to apply, in
+// order, and the path of the series file ("" if there is none). Without a
+// series file, all *.patch files of patchDir are applied in lexical order.
+func discoverPatches() (entries []patch.SeriesEntry, series string, _ error) {
+	dir, err := find(patchDir)
+	if err != nil {
+		return nil, "", err
+	}
+	entries, err = patch.ReadSeries(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	series = filepath.Join(dir, "series")
+	if _, err := os.Stat(series); os.IsNotExist(err) {
+		return entries, "", nil
+	}
+	return entries, series, nil
+}
+
+// validatePatches parses all patches on the host, so that a malformed patch
+// is reported before the container is built.
+func validatePatches(entries []patch.SeriesEntry) error {
+	for _, e := range entries {
+		files, err := patch.ParseFile(e.Path, e.Strip)
+		if err != nil {
+			return err
+		}
+		log.Printf("patch %s modifies %d files", e.Patch, len(files))
+	}
+	return nil
+}
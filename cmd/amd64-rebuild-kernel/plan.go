@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/kconfig"
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/kversion"
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/patch"
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/release"
+)
+
+var (
+	printPlanOnly = flag.Bool("plan",
+		false,
+		"Instead of updating and building, print what would be done, without modifying the working tree or the remote")
+
+	planFormat = flag.String("plan-format",
+		"text",
+		"Format of the -plan output: text or json")
+)
+
+// plan describes what a run of amd64-rebuild-kernel would do.
+type plan struct {
+	Channel string             `json:"channel"`
+	Release release.Descriptor `json:"release"`
+	// URLFileDiff is the unified diff of url.go, empty if unchanged.
+	URLFileDiff      string         `json:"url_file_diff"`
+	Patches          []plannedPatch `json:"patches"`
+	Fragments        []string       `json:"fragments"`
+	SkippedFragments []string       `json:"skipped_fragments"`
+	Image            string         `json:"image"`
+	AptSnapshot      string         `json:"apt_snapshot,omitempty"`
+	ImageTag         string         `json:"image_tag"`
+	// Operations lists the commands which would be run, in order.
+	Operations []string `json:"operations"`
+}
+
+type plannedPatch struct {
+	Name    string `json:"name"`
+	Applies bool   `json:"applies"`
+	// Constraints are the version constraints from the series file.
+	Constraints string `json:"constraints,omitempty"`
+}
+
+// quoteArgs formats a command line for display.
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'$") {
+			arg = fmt.Sprintf("%q", arg)
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
+// plannedFragments returns the config fragments in dir which
+// amd64-build-kernel would use and skip, given -fragments and
+// -disable-fragments.
+func plannedFragments(dir string) (used, skipped []string, _ error) {
+	usedFiles, skippedFiles, err := kconfig.SelectFragments(os.DirFS(dir), kconfig.SplitList(*fragments), kconfig.SplitList(*disableFragments))
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, name := range usedFiles {
+		used = append(used, kconfig.FragmentName(name))
+	}
+	for _, name := range skippedFiles {
+		skipped = append(skipped, kconfig.FragmentName(name))
+	}
+	return used, skipped, nil
+}
+
+// plannedPatches returns the patches of the series in dir and whether they
+// apply to version v.
+func plannedPatches(dir string, v kversion.Version) ([]plannedPatch, error) {
+	entries, err := patch.ReadSeries(dir)
+	if err != nil {
+		return nil, err
+	}
+	patches := []plannedPatch{}
+	for _, e := range entries {
+		var constraints []string
+		for _, c := range e.Constraints {
+			constraints = append(constraints, c.String())
+		}
+		patches = append(patches, plannedPatch{
+			Name:        e.Patch,
+			Applies:     e.Applies(v),
+			Constraints: strings.Join(constraints, " "),
+		})
+	}
+	return patches, nil
+}
+
+// makePlan resolves the release and computes what updateVersion and the
+// build would do, without modifying anything.
+func makePlan() (*plan, error) {
+	r, err := release.Resolve(release.ReleasesURL, *channel)
+	if err != nil {
+		return nil, err
+	}
+	d, err := release.NewDescriptor(r, *keyringPath)
+	if err != nil {
+		return nil, err
+	}
+	v, err := d.ParsedVersion()
+	if err != nil {
+		return nil, err
+	}
+	p := &plan{
+		Channel: *channel,
+		Release: d,
+	}
+
+	dryRun := &release.Publisher{
+		PublishConfig: publisher.PublishConfig,
+		DryRun:        true,
+	}
+	urlFile := path.Join(*buildPath, "url.go")
+	// url.go is updated on the base branch, not in the working copy.
+	current, err := dryRun.ReadFile(urlFile)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := release.URLFile(d)
+	if err != nil {
+		return nil, err
+	}
+	p.URLFileDiff = patch.Diff("a/"+urlFile, "b/"+urlFile, current, updated)
+
+	// Like url.go, the patches and config fragments are read from the base
+	// branch, which the build uses.
+	base, err := os.MkdirTemp("", "amd64-rebuild-kernel-plan")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(base)
+	patches, config := filepath.Join(base, "patches"), filepath.Join(base, "config")
+	if err := dryRun.ReadTree(patchDir, patches); err != nil {
+		return nil, err
+	}
+	if err := dryRun.ReadTree(path.Join(*buildPath, "config"), config); err != nil {
+		return nil, err
+	}
+	if p.Patches, err = plannedPatches(patches, v); err != nil {
+		return nil, err
+	}
+	if p.Fragments, p.SkippedFragments, err = plannedFragments(config); err != nil {
+		return nil, err
+	}
+	tc, err := newToolchain()
+	if err != nil {
+		return nil, err
+	}
+	p.Image, p.AptSnapshot, p.ImageTag = baseImage(), tc.AptSnapshot, tc.imageTag()
+
+	if err := dryRun.CheckClean(); err != nil {
+		p.Operations = append(p.Operations, "stop: "+err.Error())
+		return p, nil
+	}
+	var ops []string
+	flush := func() {
+		for _, cmd := range dryRun.Commands {
+			ops = append(ops, quoteArgs(cmd))
+		}
+		dryRun.Commands = nil
+	}
+	worktree, err := dryRun.Open()
+	if err != nil {
+		return nil, err
+	}
+	flush()
+	ops = append(ops, "cd "+worktree, "write "+urlFile)
+	if p.URLFileDiff != "" {
+		if err := dryRun.CommitUpdate(d.Version, urlFile); err != nil {
+			return nil, err
+		}
+		flush()
+	}
+	if !*dobuild {
+		if p.URLFileDiff == "" {
+			ops = append(ops, "stop: url.go is unchanged, nothing to build")
+		}
+		if err := dryRun.PushUpdate(); err != nil {
+			return nil, err
+		}
+		dryRun.Close()
+		flush()
+		p.Operations = ops
+		return p, nil
+	}
+
+	execName := "<container executable>"
+	if *overwriteContainerExecutable != "" {
+		execName = filepath.Base(*overwriteContainerExecutable)
+	} else if executable, err := getContainerExecutable(); err == nil {
+		execName = filepath.Base(executable)
+	}
+	const tmp = "/tmp/amd64-rebuild-kernelXXXX"
+	runArgs, _, err := containerRunArgs(execName, tc, tmp)
+	if err != nil {
+		return nil, err
+	}
+	ops = append(ops,
+		"GOOS=linux GOBIN="+tmp+" CGO_ENABLED=0 go install development.thatwebsite.xyz/gokrazy/kernel-amd64/cmd/amd64-build-kernel",
+		fmt.Sprintf("write %s/Dockerfile (FROM %s) and copy the patches into %s", tmp, p.Image, tmp),
+		"(in "+tmp+") "+quoteArgs(append([]string{execName}, imageBuildArgs(tc)...)),
+		quoteArgs(append([]string{execName}, runArgs...)),
+		"replace vmlinuz and lib/modules with the build results",
+		"write build-manifest.json",
+	)
+	if *bootTest {
+		op := fmt.Sprintf("boot vmlinuz with %s (TCG) from the initramfs", *qemu)
+		if *diskControllers != "" {
+			op += " and from a disk image on " + *diskControllers
+		}
+		ops = append(ops, op+" and check /proc/config.gz, stop without pushing if it fails")
+	}
+	ops = append(ops, fmt.Sprintf("write %s and diff it with the config of the previous build for the commit message", configPath(d.Version)))
+	if err := dryRun.PushBuild(d.Version, "", "vmlinuz", "lib/modules", configPath(d.Version), "build-manifest.json"); err != nil {
+		return nil, err
+	}
+	dryRun.Close()
+	flush()
+	p.Operations = ops
+	return p, nil
+}
+
+func (p *plan) writeText() {
+	fmt.Printf("channel %s: Linux %s (%s), released %d\n", p.Channel, p.Release.Version, p.Release.Moniker, p.Release.Released)
+	fmt.Printf("  source:  %s\n", p.Release.SourceURL)
+	fmt.Printf("  sha256:  %s\n", p.Release.SHA256)
+	fmt.Println()
+	if p.URLFileDiff == "" {
+		fmt.Println("url.go: unchanged")
+	} else {
+		fmt.Printf("url.go:\n%s", p.URLFileDiff)
+	}
+	fmt.Println()
+	fmt.Println("patches:")
+	for _, pp := range p.Patches {
+		status := "apply"
+		if !pp.Applies {
+			status = "skip"
+		}
+		fmt.Printf("  %-5s %s %s\n", status, pp.Name, pp.Constraints)
+	}
+	fmt.Printf("config fragments: %s\n", strings.Join(p.Fragments, ", "))
+	if len(p.SkippedFragments) > 0 {
+		fmt.Printf("skipped fragments: %s\n", strings.Join(p.SkippedFragments, ", "))
+	}
+	fmt.Printf("toolchain image: %s (tag %s)\n", p.Image, p.ImageTag)
+	if p.AptSnapshot != "" {
+		fmt.Printf("toolchain packages: snapshot.debian.org %s\n", p.AptSnapshot)
+	} else {
+		fmt.Println("toolchain packages: current (not pinned, see -apt-snapshot)")
+	}
+	fmt.Println()
+	fmt.Println("operations:")
+	for i, op := range p.Operations {
+		fmt.Printf("  %2d. %s\n", i+1, op)
+	}
+}
+
+// printPlan prints the plan in the -plan format.
+func printPlan() error {
+	if *planFormat != "text" && *planFormat != "json" {
+		return fmt.Errorf("invalid -plan-format %q: want text or json", *planFormat)
+	}
+	p, err := makePlan()
+	if err != nil {
+		return err
+	}
+	if *planFormat == "json" {
+		b, err := json.MarshalIndent(p, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(append(b, '\n'))
+		return err
+	}
+	p.writeText()
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// hashFiles returns the sha256 checksums of the regular files in and below
+// paths (relative to dir) for which include returns true, keyed by relative
+// path. A nil include function includes all files.
+func hashFiles(dir string, paths []string, include func(rel string) bool) (map[string]string, error) {
+	var files []string
+	for _, path := range paths {
+		err := filepath.Walk(filepath.Join(dir, path), func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			if include == nil || include(rel) {
+				files = append(files, rel)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sums := make(map[string]string)
+	for _, rel := range files {
+		f, err := os.Open(filepath.Join(dir, rel))
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		sums[rel] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums, nil
+}
+
+// buildOutputs returns the sha256 checksums of the bzImage and all kernel
+// modules in the build result directory dir, keyed by relative path.
+func buildOutputs(dir string) (map[string]string, error) {
+	return hashFiles(dir, []string{"vmlinuz", filepath.Join("lib", "modules")}, func(rel string) bool {
+		return rel == "vmlinuz" || strings.HasSuffix(rel, ".ko")
+	})
+}
+
+// verifyReproducible builds the kernel twice from the same container image
+// and compares the bzImage and every kernel module.
+func verifyReproducible() error {
+	if *imageDigest == "" || *aptSnapshot == "" {
+		log.Printf("WARNING: toolchain image not pinned, use -image-digest and -apt-snapshot")
+	}
+	tmp, err := os.MkdirTemp("/tmp", "amd64-rebuild-kernel")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	tc, err := newToolchain()
+	if err != nil {
+		return err
+	}
+	executable, err := buildImage(tmp, tc)
+	if err != nil {
+		return err
+	}
+
+	var outputs [2]map[string]string
+	for i := range outputs {
+		resultDir := filepath.Join(tmp, fmt.Sprintf("build%d", i+1))
+		if err := os.Mkdir(resultDir, 0755); err != nil {
+			return err
+		}
+		log.Printf("compiling kernel (build %d of %d)", i+1, len(outputs))
+		if err := runBuild(executable, tc, resultDir); err != nil {
+			return err
+		}
+		if outputs[i], err = buildOutputs(resultDir); err != nil {
+			return err
+		}
+	}
+
+	files := make(map[string]bool)
+	for _, sums := range outputs {
+		for rel := range sums {
+			files[rel] = true
+		}
+	}
+	var diffs []string
+	for rel := range files {
+		if a, b := outputs[0][rel], outputs[1][rel]; a != b {
+			diffs = append(diffs, fmt.Sprintf("%s: %q != %q", rel, a, b))
+		}
+	}
+	sort.Strings(diffs)
+	if len(diffs) > 0 {
+		return fmt.Errorf("build is not reproducible, %d of %d files differ:\n%s", len(diffs), len(files), strings.Join(diffs, "\n"))
+	}
+	log.Printf("build is reproducible: all %d files are identical", len(files))
+	return nil
+}
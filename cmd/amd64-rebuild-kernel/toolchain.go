@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"runtime"
+)
+
+// toolchainVersion must be incremented whenever the toolchain image
+// definition (base image, packages) changes. It is part of the image tag.
+const toolchainVersion = 3
+
+// targetArch is the Debian architecture the kernel is built for.
+const targetArch = "amd64"
+
+var (
+	baseImageName = flag.String("base-image",
+		"debian:bookworm",
+		"Base image of the toolchain container")
+
+	toolchainName = flag.String("toolchain",
+		"gcc",
+		"Compiler toolchain to build the kernel with: gcc or clang (LLVM=1)")
+
+	aptSnapshot = flag.String("apt-snapshot",
+		"",
+		"snapshot.debian.org timestamp (e.g. 20240328T000000Z) to install the toolchain packages from, pinning their versions like -image-digest pins the base image. Empty means the current packages")
+)
+
+// aptSnapshotRe matches the timestamps of snapshot.debian.org.
+var aptSnapshotRe = regexp.MustCompile(`^[0-9]{8}T[0-9]{6}Z$`)
+
+// commonPackages are needed to build the kernel regardless of the toolchain.
+var commonPackages = []string{
+	"bc",
+	"bison",
+	"ca-certificates",
+	"cpio",
+	"dirmngr",
+	"flex",
+	"gnupg",
+	"kmod",
+	"libelf-dev",
+	"libncurses-dev",
+	"libssl-dev",
+	"make",
+	"xz-utils",
+	"zstd",
+}
+
+// toolchainPackages returns the packages providing a compiler for targetArch
+// on the host architecture (which is also the architecture of the container).
+// The second return value is the CROSS_COMPILE prefix, if any.
+func toolchainPackages(toolchain, hostArch string) ([]string, string, error) {
+	switch toolchain {
+	case "gcc":
+		if hostArch == targetArch {
+			return []string{"gcc", "binutils"}, "", nil
+		}
+		// Debian cross toolchains are named after the GNU triplet.
+		return []string{"crossbuild-essential-" + targetArch}, "x86_64-linux-gnu-", nil
+	case "clang":
+		// clang is a cross compiler for all supported targets.
+		return []string{"clang", "lld", "llvm"}, "", nil
+	default:
+		return nil, "", fmt.Errorf("unknown -toolchain %q, expected gcc or clang", toolchain)
+	}
+}
+
+// toolchain describes the container image in which the kernel is built.
+type toolchain struct {
+	Name         string
+	Packages     []string
+	CrossCompile string
+	// AptSnapshot is the snapshot.debian.org timestamp the packages are
+	// installed from, if any.
+	AptSnapshot string
+}
+
+func newToolchain() (*toolchain, error) {
+	packages, crossCompile, err := toolchainPackages(*toolchainName, runtime.GOARCH)
+	if err != nil {
+		return nil, err
+	}
+	if *aptSnapshot != "" && !aptSnapshotRe.MatchString(*aptSnapshot) {
+		return nil, fmt.Errorf("invalid -apt-snapshot %q, expected a snapshot.debian.org timestamp like 20240328T000000Z", *aptSnapshot)
+	}
+	return &toolchain{
+		Name:         *toolchainName,
+		Packages:     append(append([]string{}, commonPackages...), packages...),
+		CrossCompile: crossCompile,
+		AptSnapshot:  *aptSnapshot,
+	}, nil
+}
+
+// imageTag returns the tag of the toolchain image, which changes with the
+// toolchain, its definition and the package snapshot.
+func (t *toolchain) imageTag() string {
+	tag := fmt.Sprintf("amd64-rebuild-kernel:%s-v%d", t.Name, toolchainVersion)
+	if t.AptSnapshot != "" {
+		tag += "-" + t.AptSnapshot
+	}
+	return tag
+}
+
+// buildArgs returns the amd64-build-kernel flags selecting this toolchain.
+func (t *toolchain) buildArgs() []string {
+	args := []string{"-toolchain=" + t.Name}
+	if t.CrossCompile != "" {
+		args = append(args, "-cross-compile="+t.CrossCompile)
+	}
+	return args
+}
+
+// baseImage returns the toolchain base image reference for the Dockerfile,
+// pinned by digest if -image-digest is set.
+func baseImage() string {
+	if *imageDigest != "" {
+		return *baseImageName + "@" + *imageDigest
+	}
+	return *baseImageName
+}
@@ -1,120 +1,77 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
-	"net/http"
-	"os"
-	"os/exec"
 	"path"
-	"text/template"
-)
 
-const (
-	releasesURL = "https://www.kernel.org/releases.json"
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/release"
 )
 
 var (
-	buildPath   = flag.String("build-path", "cmd/amd64-build-kernel", "Build Package path")
-	urlTemplate = `
-package main
+	buildPath = flag.String("build-path", "cmd/amd64-build-kernel", "Build Package path")
+
+	channel = flag.String("channel", "stable", release.ChannelUsage)
+
+	keyringPath = flag.String("keyring",
+		"",
+		"Path to an OpenPGP keyring containing the kernel.org signing keys, used to verify the checksum recorded in url.go. If empty, the kernel.org keyring pinned in internal/source/keyring is used")
 
-// see https://www.kernel.org/releases.json
-var latest = "{{ . }}"
-`
+	publishFlags = release.AddPublishFlags(flag.CommandLine)
 )
 
 func main() {
 	flag.Parse()
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	// get latest stable release
-	r, err := http.Get(releasesURL)
-	if err != nil {
-		log.Fatal(err)
-	}
-	var resp ReleasesResponse
-	defer r.Body.Close()
-	if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
-		log.Fatal(err)
-	}
-
-	downloadURL := ""
-	for _, release := range resp.Releases {
-		if release.Version == resp.LatestStable.Version {
-			downloadURL = release.Source
-			break
-		}
-	}
-	// update gokr-build-kernel in development branch
 
-	d, err := os.Create(path.Join(*buildPath, "url.go"))
+	config, err := publishFlags.Config()
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer d.Close()
+	publisher := &release.Publisher{PublishConfig: config}
 
-	t, err := template.New("url.go").Parse(urlTemplate)
+	r, err := release.Resolve(release.ReleasesURL, *channel)
 	if err != nil {
 		log.Fatal(err)
 	}
+	log.Printf("channel %s: Linux %s", *channel, r.Version)
 
-	if err := t.ExecuteTemplate(d, "url.go", downloadURL); err != nil {
-		log.Fatal(err)
-	}
-
-	// commit it
-	if err := exec.Command("git", "checkout", "development").Run(); err != nil {
-		log.Fatal(err)
-	}
-
-	if err := exec.Command("git", "add", path.Join(*buildPath, "url.go")).Run(); err != nil {
-		log.Fatal(err)
-	}
-
-	if o, err := exec.Command("git", "commit", "-m", fmt.Sprintf("Upgrade to version %s", resp.LatestStable.Version)).CombinedOutput(); err != nil {
-		log.Println(string(o[:]))
+	d, err := release.NewDescriptor(r, *keyringPath)
+	if err != nil {
 		log.Fatal(err)
 	}
 
-	if o, err := exec.Command("git", "push").CombinedOutput(); err != nil {
-		log.Println(string(o[:]))
+	// update gokr-build-kernel in the base branch
+	if err := publish(publisher, d); err != nil {
 		log.Fatal(err)
 	}
 
-	if o, err := exec.Command("git", "checkout", "-B", fmt.Sprintf("build-%s", resp.LatestStable.Version)).CombinedOutput(); err != nil {
-		log.Println(string(o[:]))
-		log.Fatal(err)
-	}
 	fmt.Println("*********************************")
 	fmt.Println()
-	fmt.Printf("Execute `go run %s` to build the kernel\n", path.Join(path.Dir(*buildPath), "amd64-rebuild-kernel", "kernel.go"))
+	fmt.Printf("Execute `go run ./%s -enable-build` to build the kernel\n", path.Join(path.Dir(*buildPath), "amd64-rebuild-kernel"))
 	fmt.Println()
 	fmt.Println("*********************************")
-
 }
 
-type ReleasesResponse struct {
-	LatestStable struct {
-		Version string `json:"version"`
-	} `json:"latest_stable"`
-	Releases []struct {
-		Iseol    bool        `json:"iseol"`
-		Version  string      `json:"version"`
-		Moniker  string      `json:"moniker"`
-		Source   string      `json:"source"`
-		Pgp      interface{} `json:"pgp"`
-		Released struct {
-			Timestamp int    `json:"timestamp"`
-			Isodate   string `json:"isodate"`
-		} `json:"released"`
-		Gitweb    string      `json:"gitweb"`
-		Changelog interface{} `json:"changelog"`
-		Diffview  string      `json:"diffview"`
-		Patch     struct {
-			Full        string `json:"full"`
-			Incremental string `json:"incremental"`
-		} `json:"patch"`
-	} `json:"releases"`
+// publish writes url.go for d in a worktree of the base branch and
+// commits and pushes it.
+func publish(publisher *release.Publisher, d release.Descriptor) error {
+	if err := publisher.CheckClean(); err != nil {
+		return err
+	}
+	if _, err := publisher.Open(); err != nil {
+		return err
+	}
+	defer func() {
+		if err := publisher.Close(); err != nil {
+			log.Printf("removing worktree: %v", err)
+		}
+	}()
+	err := publisher.UpdateURLFile(path.Join(*buildPath, "url.go"), d, true)
+	if err == release.ErrUnchanged {
+		log.Printf("url.go already describes Linux %s", d.Version)
+		return nil
+	}
+	return err
 }
@@ -0,0 +1,22 @@
+// amd64-update-keyring updates the pinned keyring of the kernel.org release
+// signing keys, which the kernel sources are verified with.
+package main
+
+import (
+	"flag"
+	"log"
+
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/source"
+)
+
+var output = flag.String("output",
+	source.PinnedKeyringPath,
+	"Path to write the ASCII armored keyring to")
+
+func main() {
+	flag.Parse()
+	if err := source.UpdateKeyring(*output); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote %s, review and commit it", *output)
+}
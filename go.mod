@@ -1,3 +1,8 @@
 module development.thatwebsite.xyz/gokrazy/kernel-amd64
 
-go 1.21
+go 1.22
+
+require (
+	github.com/klauspost/compress v1.18.0
+	github.com/ulikunitz/xz v0.5.15
+)
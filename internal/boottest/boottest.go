@@ -0,0 +1,375 @@
+package boottest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/initramfs"
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/kconfig"
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/squashfs"
+)
+
+// InitPackage is the import path of the init program of the initramfs.
+const InitPackage = "development.thatwebsite.xyz/gokrazy/kernel-amd64/cmd/amd64-boot-init"
+
+// Config describes a boot test.
+type Config struct {
+	// Kernel is the path to the bzImage to boot.
+	Kernel string
+	// Cmdline is the kernel command line (e.g. the content of cmdline.txt).
+	// The serial console and, unless booting from a disk, the initramfs init
+	// are appended.
+	Cmdline string
+	// Controller, if not nil, makes the kernel boot from a gokrazy disk
+	// image (see WriteDiskImage) attached to Controller instead of from an
+	// initramfs, resolving the root= and init= of Cmdline.
+	Controller *Controller
+	// Expected lists the options which /proc/config.gz must contain.
+	Expected []kconfig.Option
+	// ConfigSHA256, if not empty, is the checksum of the .config the kernel
+	// was built with, which /proc/config.gz must match.
+	ConfigSHA256 string
+
+	// QEMU is the qemu-system-x86_64 binary, which runs with software
+	// emulation (TCG), so that no KVM is required.
+	QEMU    string
+	Memory  int // MiB
+	Timeout time.Duration
+	// Console receives the serial console output, if not nil.
+	Console io.Writer
+}
+
+// Result is what the init program reported.
+type Result struct {
+	Userspace bool
+	// Root is the block device of the root file system, e.g. sda2, when
+	// booting from a disk.
+	Root         string
+	ConfigSHA256 string
+	Mismatches   []Mismatch
+	Probes       []Probe
+	Errors       []string
+	Done         bool
+	// Panic is the kernel panic message, if any.
+	Panic string
+	// Partitions are the block devices the kernel listed because it could
+	// not mount the root file system.
+	Partitions []string
+}
+
+// Err returns an error describing why the boot test failed, or nil.
+func (r *Result) Err(c *Config) error {
+	var problems []string
+	if r.Panic != "" {
+		problems = append(problems, "kernel panic: "+r.Panic)
+	}
+	if !r.Userspace {
+		problems = append(problems, "the kernel did not reach userspace")
+	} else if !r.Done {
+		problems = append(problems, "init did not finish")
+	}
+	if ctrl := c.Controller; ctrl != nil {
+		if !r.Userspace {
+			if hint := rootHint(c, r); hint != "" {
+				problems = append(problems, hint)
+			}
+		} else if want := ctrl.Partition(RootPartition); r.Root != want {
+			problems = append(problems, fmt.Sprintf("the root file system is /dev/%s, want /dev/%s on %s", r.Root, want, ctrl.Name))
+		}
+	}
+	problems = append(problems, r.Errors...)
+	for _, m := range r.Mismatches {
+		problems = append(problems, "config option "+m.String())
+	}
+	for _, p := range r.Probes {
+		if p.Status == ProbeFail {
+			problems = append(problems, fmt.Sprintf("probe %s failed: %s", p.Name, p.Detail))
+		}
+	}
+	if c.ConfigSHA256 != "" && r.Userspace && r.ConfigSHA256 != c.ConfigSHA256 {
+		problems = append(problems, fmt.Sprintf("/proc/config.gz (sha256 %q) is not the .config of the build (sha256 %s)", r.ConfigSHA256, c.ConfigSHA256))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("boot test failed:\n  %s", strings.Join(problems, "\n  "))
+}
+
+// WriteProbes writes the matrix of probe outcomes.
+func (r *Result) WriteProbes(w io.Writer) {
+	if len(r.Probes) == 0 {
+		fmt.Fprintln(w, "no runtime probes reported")
+		return
+	}
+	fmt.Fprintf(w, "%-10s %-6s %-24s %s\n", "PROBE", "STATUS", "OPTION", "DETAIL")
+	for _, p := range r.Probes {
+		fmt.Fprintf(w, "%-10s %-6s %-24s %s\n", p.Name, p.Status, p.Option, p.Detail)
+	}
+}
+
+// BuildInit compiles the init program for the initramfs. It must be called
+// from within the module.
+func BuildInit(dir string) (string, error) {
+	out := filepath.Join(dir, "amd64-boot-init")
+	cmd := exec.Command("go", "build", "-o", out, InitPackage)
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH=amd64", "CGO_ENABLED=0")
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%v: %v", cmd.Args, err)
+	}
+	return out, nil
+}
+
+// tree is a file system being written, i.e. an initramfs.Writer or a
+// squashfs.Writer.
+type tree interface {
+	Dir(name string, perm fs.FileMode) error
+	File(name string, perm fs.FileMode, data []byte) error
+	CharDev(name string, perm fs.FileMode, major, minor uint32) error
+}
+
+// writeTree adds the init program (at initPath), the expected config and the
+// squashfs image of the probes to t.
+func writeTree(t tree, initPath string, init []byte, expected []kconfig.Option) error {
+	var config bytes.Buffer
+	for _, o := range expected {
+		if o.Value == "n" {
+			fmt.Fprintf(&config, "# %s is not set\n", o.Name)
+		} else {
+			fmt.Fprintln(&config, o)
+		}
+	}
+	initPath = strings.TrimPrefix(path.Clean("/"+initPath), "/")
+	dirs := []string{"dev", "proc", "sys", "tmp", "boot-test"}
+	// The parents of init (e.g. gokrazy for gokrazy/init), outermost first.
+	var parents []string
+	for dir := path.Dir(initPath); dir != "."; dir = path.Dir(dir) {
+		parents = append([]string{dir}, parents...)
+	}
+	dirs = append(dirs, parents...)
+	for _, dir := range dirs {
+		if err := t.Dir(dir, 0755); err != nil {
+			return err
+		}
+	}
+	// The kernel opens /dev/console for init before devtmpfs is mounted.
+	if err := t.CharDev("dev/console", 0600, 5, 1); err != nil {
+		return err
+	}
+	if err := t.File(initPath, 0755, init); err != nil {
+		return err
+	}
+	if err := t.File(ExpectedConfigPath, 0644, config.Bytes()); err != nil {
+		return err
+	}
+	var image bytes.Buffer
+	sw := squashfs.NewWriter(&image)
+	if err := sw.File(ProbeImageFile, 0644, []byte(ProbeImageContent)); err != nil {
+		return err
+	}
+	if err := sw.Close(); err != nil {
+		return err
+	}
+	return t.File(ProbeImagePath, 0644, image.Bytes())
+}
+
+// WriteInitramfs writes an initramfs containing the init program, the
+// expected config and the squashfs image of the probes.
+func WriteInitramfs(w io.Writer, init []byte, expected []kconfig.Option) error {
+	cw := initramfs.NewWriter(w)
+	if err := writeTree(cw, InitPath, init, expected); err != nil {
+		return err
+	}
+	return cw.Close()
+}
+
+// SkipReported removes the options which the config report of the build
+// (config-report.json of amd64-build-kernel) already lists as not having
+// taken effect: they were reported (and rejected with -strict) at build time.
+func SkipReported(expected []kconfig.Option, reportPath string) ([]kconfig.Option, error) {
+	b, err := os.ReadFile(reportPath)
+	if err != nil {
+		return nil, err
+	}
+	var report struct {
+		Problems []struct {
+			Option string `json:"option"`
+		} `json:"problems"`
+	}
+	if err := json.Unmarshal(b, &report); err != nil {
+		return nil, fmt.Errorf("%s: %v", reportPath, err)
+	}
+	reported := make(map[string]bool)
+	for _, p := range report.Problems {
+		reported[p.Option] = true
+	}
+	var remaining []kconfig.Option
+	for _, o := range expected {
+		if !reported[o.Name] {
+			remaining = append(remaining, o)
+		}
+	}
+	return remaining, nil
+}
+
+// Run boots the kernel and returns what init reported. An error is only
+// returned if the boot test could not be run; check Result.Err.
+func Run(c *Config) (*Result, error) {
+	tmp, err := os.MkdirTemp("", "amd64-boot-test")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	initPath, err := BuildInit(tmp)
+	if err != nil {
+		return nil, err
+	}
+	init, err := os.ReadFile(initPath)
+	if err != nil {
+		return nil, err
+	}
+	name, write := "initramfs.cpio", func(w io.Writer) error {
+		return WriteInitramfs(w, init, c.Expected)
+	}
+	if c.Controller != nil {
+		name, write = "disk.img", func(w io.Writer) error {
+			return WriteDiskImage(w, c.Cmdline, init, c.Expected)
+		}
+	}
+	image, err := os.Create(filepath.Join(tmp, name))
+	if err != nil {
+		return nil, err
+	}
+	defer image.Close()
+	if err := write(image); err != nil {
+		return nil, err
+	}
+	if err := image.Close(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, c.QEMU, qemuArgs(c, image.Name())...)
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = os.Stderr
+	// Do not wait for processes which inherited stdout after a timeout.
+	cmd.WaitDelay = 5 * time.Second
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	done := make(chan *Result)
+	go func() { done <- parseConsole(pr, c.Console) }()
+	err = cmd.Wait()
+	pw.Close()
+	result := <-done
+	if ctx.Err() == context.DeadlineExceeded {
+		result.Errors = append(result.Errors, fmt.Sprintf("timeout after %v", c.Timeout))
+		return result, nil
+	}
+	if err != nil && result.Panic == "" {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return nil, err
+		}
+		result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", c.QEMU, err))
+	}
+	return result, nil
+}
+
+// qemuArgs returns the arguments of qemu-system-x86_64 booting the kernel
+// with the initramfs or disk image, with the serial console on stdout.
+// -no-reboot makes QEMU exit on the reboot after a panic (see panic= in
+// cmdline.txt).
+func qemuArgs(c *Config, image string) []string {
+	args := []string{
+		"-machine", "q35",
+		"-accel", "tcg",
+		"-cpu", "max",
+		"-m", fmt.Sprint(c.Memory),
+		"-nodefaults",
+		"-display", "none",
+		"-serial", "stdio",
+		"-no-reboot",
+		"-kernel", c.Kernel,
+	}
+	if c.Controller == nil {
+		return append(args,
+			"-initrd", image,
+			"-append", strings.TrimSpace(c.Cmdline+" console=ttyS0 rdinit=/"+InitPath))
+	}
+	args = append(args, "-drive", "file="+image+",if=none,id=disk,format=raw")
+	args = append(args, c.Controller.Device...)
+	return append(args, "-append", strings.TrimSpace(c.Cmdline+" console=ttyS0"))
+}
+
+// parseConsole reads the serial console output until EOF.
+func parseConsole(r io.Reader, console io.Writer) *Result {
+	result := &Result{}
+	listingPartitions := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if console != nil {
+			fmt.Fprintln(console, line)
+		}
+		if idx := strings.Index(line, "Kernel panic - not syncing:"); idx != -1 && result.Panic == "" {
+			result.Panic = strings.TrimSpace(line[idx+len("Kernel panic - not syncing:"):])
+			listingPartitions = false
+		}
+		if strings.Contains(line, "here are the available partitions:") {
+			listingPartitions = true
+		} else if m := partitionRe.FindStringSubmatch(line); listingPartitions && m != nil {
+			result.Partitions = append(result.Partitions, m[1])
+		}
+		kind, msg, ok := parseReport(line)
+		if !ok {
+			continue
+		}
+		switch kind {
+		case KindUserspace:
+			result.Userspace = true
+		case KindRoot:
+			result.Root = msg
+		case KindConfig:
+			result.ConfigSHA256 = msg
+		case KindMismatch:
+			var m Mismatch
+			if err := json.Unmarshal([]byte(msg), &m); err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("garbled report %q: %v", line, err))
+				continue
+			}
+			result.Mismatches = append(result.Mismatches, m)
+		case KindProbe:
+			var p Probe
+			if err := json.Unmarshal([]byte(msg), &p); err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("garbled report %q: %v", line, err))
+				continue
+			}
+			result.Probes = append(result.Probes, p)
+		case KindError:
+			result.Errors = append(result.Errors, msg)
+		case KindDone:
+			result.Done = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("reading console: %v", err))
+	}
+	// Keep draining the console, so that QEMU does not block writing to it.
+	io.Copy(io.Discard, r)
+	return result
+}
@@ -0,0 +1,155 @@
+package boottest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/gpt"
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/kconfig"
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/squashfs"
+)
+
+// Controller is a disk controller which QEMU attaches the disk image to.
+type Controller struct {
+	Name string
+	// Disk is the name the kernel gives the disk, e.g. sda.
+	Disk string
+	// Options are the config options of the drivers which the kernel needs
+	// to find the disk. They must be built in: gokrazy loads no modules
+	// before mounting the root file system.
+	Options []string
+	// Device are the QEMU arguments attaching the drive with id "disk".
+	Device []string
+}
+
+// Partition returns the name the kernel gives partition n of the disk, e.g.
+// sda2 or nvme0n1p2.
+func (c *Controller) Partition(n int) string {
+	if last := c.Disk[len(c.Disk)-1]; last >= '0' && last <= '9' {
+		return fmt.Sprintf("%sp%d", c.Disk, n)
+	}
+	return fmt.Sprintf("%s%d", c.Disk, n)
+}
+
+// Controllers lists the disk controllers a gokrazy disk image can be booted
+// from. sata uses the AHCI controller built into the q35 machine.
+var Controllers = []*Controller{
+	{
+		Name:    "sata",
+		Disk:    "sda",
+		Options: []string{"CONFIG_ATA", "CONFIG_SATA_AHCI", "CONFIG_BLK_DEV_SD"},
+		Device:  []string{"-device", "ide-hd,drive=disk,bus=ide.0"},
+	},
+	{
+		Name:    "virtio-blk",
+		Disk:    "vda",
+		Options: []string{"CONFIG_VIRTIO_PCI", "CONFIG_VIRTIO_BLK"},
+		Device:  []string{"-device", "virtio-blk-pci,drive=disk"},
+	},
+	{
+		Name:    "nvme",
+		Disk:    "nvme0n1",
+		Options: []string{"CONFIG_BLK_DEV_NVME"},
+		Device:  []string{"-device", "nvme,drive=disk,serial=boottest"},
+	},
+}
+
+// LookupController returns the controller with the specified name.
+func LookupController(name string) (*Controller, error) {
+	var names []string
+	for _, c := range Controllers {
+		if c.Name == name {
+			return c, nil
+		}
+		names = append(names, c.Name)
+	}
+	return nil, fmt.Errorf("unknown disk controller %q (known: %s)", name, strings.Join(names, ", "))
+}
+
+// RootPartition is the partition number of the root file system in gokrazy
+// disk images.
+const RootPartition = 2
+
+// GUIDs of the disk image. They are fixed, so that the image is
+// reproducible, unless cmdline.txt refers to the root partition by
+// PARTUUID.
+var (
+	diskGUID = gpt.MustParseGUID("2d7ab3a6-8a1e-4c6b-9f1e-0b0075e5eb00")
+	bootGUID = gpt.MustParseGUID("2d7ab3a6-8a1e-4c6b-9f1e-0b0075e5eb01")
+	rootGUID = gpt.MustParseGUID("2d7ab3a6-8a1e-4c6b-9f1e-0b0075e5eb02")
+)
+
+// cmdlineParam returns the value of the parameter key (e.g. root) of the
+// kernel command line.
+func cmdlineParam(cmdline, key string) (string, bool) {
+	for _, f := range strings.Fields(cmdline) {
+		if k, v, ok := strings.Cut(f, "="); ok && k == key {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// WriteDiskImage writes a GPT disk image laid out like a gokrazy one: a boot
+// partition (left empty, QEMU loads the kernel) and a squashfs root file
+// system on partition 2 containing the init program at the init= path of
+// cmdline, the expected config and the squashfs image of the probes.
+func WriteDiskImage(w io.Writer, cmdline string, init []byte, expected []kconfig.Option) error {
+	initPath, ok := cmdlineParam(cmdline, "init")
+	if !ok {
+		return fmt.Errorf("kernel command line %q has no init=", cmdline)
+	}
+	partUUID := rootGUID
+	if root, _ := cmdlineParam(cmdline, "root"); strings.HasPrefix(root, "PARTUUID=") {
+		var err error
+		if partUUID, err = gpt.ParseGUID(strings.TrimPrefix(root, "PARTUUID=")); err != nil {
+			return err
+		}
+	}
+
+	var root bytes.Buffer
+	sw := squashfs.NewWriter(&root)
+	if err := writeTree(sw, initPath, init, expected); err != nil {
+		return err
+	}
+	if err := sw.Close(); err != nil {
+		return err
+	}
+	return gpt.Write(w, diskGUID, []gpt.Partition{
+		{Name: "boot", Type: gpt.TypeEFISystem, GUID: bootGUID, Size: 1 << 20},
+		{Name: "root", Type: gpt.TypeLinuxFilesystem, GUID: partUUID, Data: root.Bytes()},
+	})
+}
+
+// rootHint explains why the kernel did not find the root file system on the
+// disk, or returns "" if the console output does not tell.
+func rootHint(c *Config, r *Result) string {
+	ctrl := c.Controller
+	want := "/dev/" + ctrl.Partition(RootPartition)
+	if root, _ := cmdlineParam(c.Cmdline, "root"); strings.HasPrefix(root, "/dev/") && root != want {
+		return fmt.Sprintf("root=%s does not resolve on %s, where the root partition is %s", root, ctrl.Name, want)
+	}
+	if r.Panic == "" {
+		return ""
+	}
+	for _, p := range r.Partitions {
+		if p == ctrl.Disk {
+			return ""
+		}
+	}
+	found := "none"
+	if len(r.Partitions) > 0 {
+		found = strings.Join(r.Partitions, ", ")
+	}
+	return fmt.Sprintf("the kernel found no %s disk /dev/%s (block devices: %s); are %s built in (=y)?",
+		ctrl.Name, ctrl.Disk, found, strings.Join(ctrl.Options, ", "))
+}
+
+// partitionRe matches the lines of the partition list which the kernel
+// prints before panicking when it cannot mount the root file system, e.g.
+// "0802           10240 sda2 2d7ab3a6-..." or (for majors above 255, e.g.
+// NVMe) "103:00002      10240 nvme0n1p2 ...".
+var partitionRe = regexp.MustCompile(`^\s*(?:\[[^\]]*\]\s*)?(?:[0-9a-f]{4}|[0-9a-f]{3}:[0-9a-f]{5})\s+\d+\s+(\S+)`)
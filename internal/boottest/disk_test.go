@@ -0,0 +1,65 @@
+package boottest
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResultErrRoot(t *testing.T) {
+	const cmdline = "root=/dev/sda2 ro init=/gokrazy/init panic=10 oops=panic"
+	for _, tt := range []struct {
+		controller string
+		cmdline    string
+		result     Result
+		want       string
+	}{
+		{
+			controller: "sata",
+			cmdline:    cmdline,
+			result:     Result{Userspace: true, Done: true, Root: "sda2"},
+		},
+		{
+			controller: "nvme",
+			cmdline:    cmdline,
+			result: Result{
+				Panic:      "VFS: Unable to mount root fs on unknown-block(0,0)",
+				Partitions: []string{"nvme0n1", "nvme0n1p1", "nvme0n1p2"},
+			},
+			want: "root=/dev/sda2 does not resolve on nvme, where the root partition is /dev/nvme0n1p2",
+		},
+		{
+			controller: "virtio-blk",
+			cmdline:    "root=PARTUUID=2d7ab3a6-8a1e-4c6b-9f1e-0b0075e5eb02 init=/gokrazy/init",
+			result: Result{
+				Panic: "VFS: Unable to mount root fs on unknown-block(0,0)",
+			},
+			want: "the kernel found no virtio-blk disk /dev/vda (block devices: none); are CONFIG_VIRTIO_PCI, CONFIG_VIRTIO_BLK built in (=y)?",
+		},
+		{
+			controller: "nvme",
+			cmdline:    "root=PARTUUID=2d7ab3a6-8a1e-4c6b-9f1e-0b0075e5eb02 init=/gokrazy/init",
+			result:     Result{Userspace: true, Done: true, Root: "nvme0n1p2"},
+		},
+		{
+			controller: "virtio-blk",
+			cmdline:    "root=/dev/vda2 init=/gokrazy/init",
+			result:     Result{Userspace: true, Done: true, Root: "vda1"},
+			want:       "the root file system is /dev/vda1, want /dev/vda2 on virtio-blk",
+		},
+	} {
+		ctrl, err := LookupController(tt.controller)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tt.result.Err(&Config{Cmdline: tt.cmdline, Controller: ctrl})
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%s: Err() = %v, want nil", tt.controller, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Err() = %v, want error containing %q", tt.controller, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Package boottest boots a kernel under QEMU with a tiny initramfs and checks
+// what the init program (cmd/amd64-boot-init) reports over the serial
+// console.
+package boottest
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Prefix starts every line the init program reports. All other console
+// output (e.g. kernel messages) is ignored.
+const Prefix = "BOOTTEST "
+
+// Report kinds, the first word after Prefix.
+const (
+	// KindUserspace is reported first: the kernel started init.
+	KindUserspace = "userspace"
+	// KindRoot reports the block device of the root file system (e.g.
+	// sda2), unless it is the initramfs.
+	KindRoot = "root"
+	// KindConfig reports the sha256 of the decompressed /proc/config.gz.
+	KindConfig = "config-sha256"
+	// KindMismatch reports an option of the expected config which has a
+	// different value in /proc/config.gz, as a JSON encoded Mismatch.
+	KindMismatch = "config-mismatch"
+	// KindProbe reports the outcome of a runtime feature probe, as a JSON
+	// encoded Probe.
+	KindProbe = "probe"
+	// KindError reports a check which could not be performed.
+	KindError = "error"
+	// KindDone is reported last, before init powers off the machine.
+	KindDone = "done"
+)
+
+// Paths of the files in the initramfs.
+const (
+	InitPath = "init"
+	// ExpectedConfigPath contains the options (in .config syntax) which
+	// /proc/config.gz must contain.
+	ExpectedConfigPath = "boot-test/expected.config"
+	// ProbeImagePath is a squashfs image which the squashfs probe mounts.
+	// It contains ProbeImageFile with the content ProbeImageContent.
+	ProbeImagePath    = "boot-test/probe.squashfs"
+	ProbeImageFile    = "probe.txt"
+	ProbeImageContent = "squashfs probe\n"
+)
+
+// Mismatch is an option whose value in /proc/config.gz differs from the
+// expected config.
+type Mismatch struct {
+	Option string `json:"option"`
+	Want   string `json:"want"`
+	Got    string `json:"got"`
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%s: want %s, got %s", m.Option, m.Want, m.Got)
+}
+
+// Possible values of Probe.Status.
+const (
+	ProbePass = "pass"
+	ProbeFail = "fail"
+	// ProbeSkip means that the feature is not enabled by the expected
+	// config (e.g. its fragment was disabled).
+	ProbeSkip = "skip"
+)
+
+// Probe is the outcome of exercising a feature group (e.g. creating a
+// WireGuard link) in the running kernel.
+type Probe struct {
+	Name string `json:"name"`
+	// Option is the config option enabling the feature.
+	Option string `json:"option"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Report writes a report line of kind with the (single line) message msg
+// to w (the console).
+func Report(w io.Writer, kind, msg string) {
+	fmt.Fprintln(w, strings.TrimSpace(Prefix+kind+" "+msg))
+}
+
+// parseReport splits a console line into the kind and message of a report,
+// if it is one. The kernel may interleave its messages, so the prefix is
+// searched anywhere in the line.
+func parseReport(line string) (kind, msg string, ok bool) {
+	idx := strings.Index(line, Prefix)
+	if idx == -1 {
+		return "", "", false
+	}
+	kind, msg, _ = strings.Cut(strings.TrimSpace(line[idx+len(Prefix):]), " ")
+	return kind, msg, kind != ""
+}
@@ -0,0 +1,169 @@
+// Package gpt writes disk images partitioned with a GUID partition table,
+// like the images gokrazy writes to the boot medium.
+package gpt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	sectorSize = 512
+	// Partitions are aligned to 1 MiB, like fdisk and gokrazy do.
+	alignment = 1 << 20 / sectorSize
+
+	numEntries = 128
+	entrySize  = 128
+	// entrySectors is the size of the partition entry array.
+	entrySectors = numEntries * entrySize / sectorSize
+)
+
+// GUID is a GUID in its on-disk encoding, in which the first three fields
+// are little endian.
+type GUID [16]byte
+
+// ParseGUID parses the textual form of a GUID, e.g.
+// 0fc63daf-8483-4772-8e79-3d69d8477de4.
+func ParseGUID(s string) (GUID, error) {
+	var g GUID
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(b) != 16 || len(s) != 36 {
+		return g, fmt.Errorf("gpt: invalid GUID %q", s)
+	}
+	binary.LittleEndian.PutUint32(g[0:], binary.BigEndian.Uint32(b[0:]))
+	binary.LittleEndian.PutUint16(g[4:], binary.BigEndian.Uint16(b[4:]))
+	binary.LittleEndian.PutUint16(g[6:], binary.BigEndian.Uint16(b[6:]))
+	copy(g[8:], b[8:])
+	return g, nil
+}
+
+// MustParseGUID is like ParseGUID but panics if s is invalid. It is meant
+// for GUID constants.
+func MustParseGUID(s string) GUID {
+	g, err := ParseGUID(s)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+func (g GUID) String() string {
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(g[0:]),
+		binary.LittleEndian.Uint16(g[4:]),
+		binary.LittleEndian.Uint16(g[6:]),
+		g[8:10],
+		g[10:])
+}
+
+// Partition types.
+var (
+	TypeEFISystem       = MustParseGUID("c12a7328-f81f-11d2-ba4b-00a0c93ec93b")
+	TypeLinuxFilesystem = MustParseGUID("0fc63daf-8483-4772-8e79-3d69d8477de4")
+)
+
+// Partition is a partition of the image.
+type Partition struct {
+	Name string
+	Type GUID
+	// GUID is the unique partition GUID, which root=PARTUUID= refers to.
+	GUID GUID
+	// Size is the minimum size in bytes. The partition is at least as
+	// large as Data and rounded up to 1 MiB.
+	Size int64
+	// Data is the content of the partition, the rest is zero.
+	Data []byte
+}
+
+func (p *Partition) sectors() uint64 {
+	size := p.Size
+	if int64(len(p.Data)) > size {
+		size = int64(len(p.Data))
+	}
+	sectors := uint64(size+sectorSize-1) / sectorSize
+	return (sectors + alignment - 1) / alignment * alignment
+}
+
+// Write writes a disk image with the partitions (numbered from 1) to w.
+func Write(w io.Writer, disk GUID, partitions []Partition) error {
+	if len(partitions) > numEntries {
+		return fmt.Errorf("gpt: too many partitions")
+	}
+	// The primary header and entries precede the first partition, the
+	// backup entries and header follow the last one.
+	first := make([]uint64, len(partitions))
+	lba := uint64(alignment)
+	for i := range partitions {
+		first[i] = lba
+		lba += partitions[i].sectors()
+	}
+	lastUsable := lba - 1
+	total := lba + entrySectors + 1
+
+	var entries bytes.Buffer
+	for i, p := range partitions {
+		name := utf16.Encode([]rune(p.Name))
+		if len(name) > 36 {
+			return fmt.Errorf("gpt: partition name %q too long", p.Name)
+		}
+		entries.Write(p.Type[:])
+		entries.Write(p.GUID[:])
+		binary.Write(&entries, binary.LittleEndian, []uint64{first[i], first[i] + p.sectors() - 1, 0})
+		binary.Write(&entries, binary.LittleEndian, append(name, make([]uint16, 36-len(name))...))
+	}
+	entries.Write(make([]byte, numEntries*entrySize-entries.Len()))
+	entriesCRC := crc32.ChecksumIEEE(entries.Bytes())
+
+	header := func(current, backup, entriesLBA uint64) []byte {
+		var h bytes.Buffer
+		h.WriteString("EFI PART")
+		binary.Write(&h, binary.LittleEndian, []uint32{0x00010000, 92, 0, 0})
+		binary.Write(&h, binary.LittleEndian, []uint64{current, backup, 2 + entrySectors, lastUsable})
+		h.Write(disk[:])
+		binary.Write(&h, binary.LittleEndian, entriesLBA)
+		binary.Write(&h, binary.LittleEndian, []uint32{numEntries, entrySize, entriesCRC})
+		b := h.Bytes()
+		binary.LittleEndian.PutUint32(b[16:], crc32.ChecksumIEEE(b))
+		return append(b, make([]byte, sectorSize-len(b))...)
+	}
+
+	// The protective MBR covers the whole disk with a single partition of
+	// type 0xee, so that MBR tools leave the disk alone.
+	mbr := make([]byte, sectorSize)
+	sectors := total - 1
+	if sectors > 0xffffffff {
+		sectors = 0xffffffff
+	}
+	copy(mbr[446:], []byte{0, 0x00, 0x02, 0x00, 0xee, 0xff, 0xff, 0xff})
+	binary.LittleEndian.PutUint32(mbr[454:], 1)
+	binary.LittleEndian.PutUint32(mbr[458:], uint32(sectors))
+	mbr[510], mbr[511] = 0x55, 0xaa
+
+	out := mbr
+	out = append(out, header(1, total-1, 2)...)
+	out = append(out, entries.Bytes()...)
+	out = append(out, make([]byte, (alignment-2-entrySectors)*sectorSize)...)
+	if _, err := w.Write(out); err != nil {
+		return err
+	}
+	for _, p := range partitions {
+		padding := int(p.sectors()*sectorSize) - len(p.Data)
+		if _, err := w.Write(p.Data); err != nil {
+			return err
+		}
+		if _, err := w.Write(make([]byte, padding)); err != nil {
+			return err
+		}
+	}
+	if _, err := w.Write(entries.Bytes()); err != nil {
+		return err
+	}
+	_, err := w.Write(header(total-1, 1, lastUsable+1))
+	return err
+}
@@ -0,0 +1,191 @@
+package gpt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestGUID(t *testing.T) {
+	const s = "c12a7328-f81f-11d2-ba4b-00a0c93ec93b"
+	g, err := ParseGUID(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first three fields are stored little endian.
+	want := []byte{0x28, 0x73, 0x2a, 0xc1, 0x1f, 0xf8, 0xd2, 0x11, 0xba, 0x4b, 0x00, 0xa0, 0xc9, 0x3e, 0xc9, 0x3b}
+	if !bytes.Equal(g[:], want) {
+		t.Errorf("ParseGUID(%s) = % x, want % x", s, g[:], want)
+	}
+	if got := g.String(); got != s {
+		t.Errorf("String() = %s, want %s", got, s)
+	}
+	for _, invalid := range []string{
+		"",
+		"c12a7328f81f11d2ba4b00a0c93ec93b",
+		"c12a7328-f81f-11d2-ba4b-00a0c93ec93",
+		"x12a7328-f81f-11d2-ba4b-00a0c93ec93b",
+	} {
+		if _, err := ParseGUID(invalid); err == nil {
+			t.Errorf("ParseGUID(%q) succeeded", invalid)
+		}
+	}
+}
+
+// header is a decoded GPT header.
+type header struct {
+	Signature               [8]byte
+	Revision, Size, CRC     uint32
+	Reserved                uint32
+	Current, Backup         uint64
+	FirstUsable, LastUsable uint64
+	Disk                    GUID
+	EntriesLBA              uint64
+	NumEntries, EntrySize   uint32
+	EntriesCRC              uint32
+}
+
+// decodeHeader decodes the header in sector and checks its signature, size
+// and CRC32.
+func decodeHeader(t *testing.T, sector []byte) header {
+	t.Helper()
+	var h header
+	if err := binary.Read(bytes.NewReader(sector), binary.LittleEndian, &h); err != nil {
+		t.Fatal(err)
+	}
+	if string(h.Signature[:]) != "EFI PART" || h.Revision != 0x00010000 || h.Size != 92 {
+		t.Fatalf("header signature %q, revision %#x, size %d", h.Signature, h.Revision, h.Size)
+	}
+	b := append([]byte{}, sector[:h.Size]...)
+	binary.LittleEndian.PutUint32(b[16:], 0)
+	if got := crc32.ChecksumIEEE(b); got != h.CRC {
+		t.Errorf("header CRC32 = %#x, computed %#x", h.CRC, got)
+	}
+	for i, b := range sector[h.Size:] {
+		if b != 0 {
+			t.Fatalf("header byte %d after the header is %#x, want 0", int(h.Size)+i, b)
+		}
+	}
+	return h
+}
+
+func TestWrite(t *testing.T) {
+	disk := MustParseGUID("2d7ab3a6-8a1e-4c6b-9f1e-0b0075e5eb00")
+	partitions := []Partition{
+		{Name: "boot", Type: TypeEFISystem, GUID: MustParseGUID("2d7ab3a6-8a1e-4c6b-9f1e-0b0075e5eb01"), Size: 1 << 20},
+		// 1.5 MiB of data is rounded up to 2 MiB.
+		{Name: "root", Type: TypeLinuxFilesystem, GUID: MustParseGUID("2d7ab3a6-8a1e-4c6b-9f1e-0b0075e5eb02"), Data: bytes.Repeat([]byte("squashfs"), 3<<16)},
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, disk, partitions); err != nil {
+		t.Fatal(err)
+	}
+	img := buf.Bytes()
+	sector := func(lba uint64) []byte { return img[lba*sectorSize : (lba+1)*sectorSize] }
+
+	// 1 MiB before the first partition, 1 + 2 MiB of partitions, the
+	// backup entries and header.
+	const sectors = 2048 + 2048 + 4096 + entrySectors + 1
+	if len(img) != sectors*sectorSize {
+		t.Fatalf("image size = %d, want %d", len(img), sectors*sectorSize)
+	}
+
+	// Protective MBR
+	mbr := sector(0)
+	if mbr[510] != 0x55 || mbr[511] != 0xaa {
+		t.Errorf("MBR signature = %#x %#x, want 0x55 0xaa", mbr[510], mbr[511])
+	}
+	if typ := mbr[446+4]; typ != 0xee {
+		t.Errorf("MBR partition type = %#x, want 0xee", typ)
+	}
+	if start, size := binary.LittleEndian.Uint32(mbr[454:]), binary.LittleEndian.Uint32(mbr[458:]); start != 1 || size != sectors-1 {
+		t.Errorf("MBR partition covers %d+%d sectors, want 1+%d", start, size, sectors-1)
+	}
+	for i := 1; i < 4; i++ {
+		if entry := mbr[446+16*i : 446+16*(i+1)]; !bytes.Equal(entry, make([]byte, 16)) {
+			t.Errorf("MBR partition %d = % x, want empty", i+1, entry)
+		}
+	}
+
+	const lastUsable = sectors - entrySectors - 2
+	for _, tt := range []struct {
+		name                         string
+		current, other, entriesStart uint64
+	}{
+		{"primary", 1, sectors - 1, 2},
+		{"backup", sectors - 1, 1, lastUsable + 1},
+	} {
+		h := decodeHeader(t, sector(tt.current))
+		if h.Current != tt.current || h.Backup != tt.other || h.EntriesLBA != tt.entriesStart {
+			t.Errorf("%s header: current %d, backup %d, entries %d; want %d, %d, %d",
+				tt.name, h.Current, h.Backup, h.EntriesLBA, tt.current, tt.other, tt.entriesStart)
+		}
+		if h.FirstUsable != 2+entrySectors || h.LastUsable != lastUsable {
+			t.Errorf("%s header: usable LBAs %d-%d, want %d-%d", tt.name, h.FirstUsable, h.LastUsable, 2+entrySectors, lastUsable)
+		}
+		if h.Disk != disk {
+			t.Errorf("%s header: disk GUID %s, want %s", tt.name, h.Disk, disk)
+		}
+		if h.NumEntries != numEntries || h.EntrySize != entrySize {
+			t.Errorf("%s header: %d entries of %d bytes, want %d of %d", tt.name, h.NumEntries, h.EntrySize, numEntries, entrySize)
+		}
+		entries := img[h.EntriesLBA*sectorSize : (h.EntriesLBA+entrySectors)*sectorSize]
+		if got := crc32.ChecksumIEEE(entries); got != h.EntriesCRC {
+			t.Errorf("%s header: entries CRC32 = %#x, computed %#x", tt.name, h.EntriesCRC, got)
+		}
+	}
+	primaryEntries := img[2*sectorSize : (2+entrySectors)*sectorSize]
+	if backupEntries := img[(lastUsable+1)*sectorSize : (lastUsable+1+entrySectors)*sectorSize]; !bytes.Equal(primaryEntries, backupEntries) {
+		t.Errorf("backup partition entries differ from the primary ones")
+	}
+
+	wantLBAs := [][2]uint64{{2048, 4095}, {4096, 8191}}
+	for i := 0; i < numEntries; i++ {
+		var e struct {
+			Type, GUID  GUID
+			First, Last uint64
+			Attributes  uint64
+			Name        [36]uint16
+		}
+		if err := binary.Read(bytes.NewReader(primaryEntries[i*entrySize:]), binary.LittleEndian, &e); err != nil {
+			t.Fatal(err)
+		}
+		if i >= len(partitions) {
+			if e.Type != (GUID{}) {
+				t.Errorf("entry %d: type %s, want unused", i+1, e.Type)
+			}
+			continue
+		}
+		p := partitions[i]
+		name := strings.TrimRight(string(utf16.Decode(e.Name[:])), "\x00")
+		if e.Type != p.Type || e.GUID != p.GUID || name != p.Name || e.Attributes != 0 {
+			t.Errorf("entry %d: type %s, GUID %s, name %q, attributes %#x; want %s, %s, %q, 0",
+				i+1, e.Type, e.GUID, name, e.Attributes, p.Type, p.GUID, p.Name)
+		}
+		if got := [2]uint64{e.First, e.Last}; got != wantLBAs[i] {
+			t.Errorf("entry %d: LBAs %d-%d, want %d-%d", i+1, e.First, e.Last, wantLBAs[i][0], wantLBAs[i][1])
+		}
+		data := img[e.First*sectorSize : (e.Last+1)*sectorSize]
+		if !bytes.HasPrefix(data, p.Data) || !bytes.Equal(data[len(p.Data):], make([]byte, len(data)-len(p.Data))) {
+			t.Errorf("entry %d: partition content is not the data followed by zeros", i+1)
+		}
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		partitions []Partition
+		wantErr    string
+	}{
+		{"name too long", []Partition{{Name: strings.Repeat("x", 37)}}, "too long"},
+		{"too many partitions", make([]Partition, numEntries+1), "too many partitions"},
+	} {
+		if err := Write(&bytes.Buffer{}, GUID{}, tt.partitions); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: Write() = %v, want error containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
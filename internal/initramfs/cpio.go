@@ -0,0 +1,106 @@
+// Package initramfs writes initramfs archives in the cpio "newc" format
+// which the kernel unpacks into its rootfs at boot.
+package initramfs
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Mode bits of the cpio format (which match those of stat(2)).
+const (
+	modeDir     = 0040000
+	modeRegular = 0100000
+	modeSymlink = 0120000
+	modeCharDev = 0020000
+)
+
+// Writer writes a cpio archive. Entries must be added parents first; the
+// kernel does not create missing parent directories.
+type Writer struct {
+	w   io.Writer
+	ino uint32
+	err error
+	// dirs records the directories added so far.
+	dirs map[string]bool
+}
+
+// NewWriter returns a Writer writing to w. Call Close to write the trailer.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, dirs: map[string]bool{".": true}}
+}
+
+// pad returns the number of bytes needed to align n to 4 bytes.
+func pad(n int) int {
+	return (4 - n%4) % 4
+}
+
+func (w *Writer) entry(name string, mode uint32, rdevMajor, rdevMinor uint32, data []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		return fmt.Errorf("initramfs: invalid name")
+	}
+	if dir := path.Dir(name); name != "TRAILER!!!" && !w.dirs[dir] {
+		return fmt.Errorf("initramfs: %s: parent directory %s not added", name, dir)
+	}
+	w.ino++
+	nlink := 1
+	if mode&0170000 == modeDir {
+		nlink = 2
+		w.dirs[name] = true
+	}
+	hdr := fmt.Sprintf("070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+		w.ino,
+		mode,
+		0, // uid
+		0, // gid
+		nlink,
+		0, // mtime, for reproducible archives
+		len(data),
+		0, 0, // device the file resides on
+		rdevMajor, rdevMinor,
+		len(name)+1,
+		0, // checksum, unused in newc
+	)
+	buf := make([]byte, 0, len(hdr)+len(name)+1+3+len(data)+3)
+	buf = append(buf, hdr...)
+	buf = append(buf, name...)
+	buf = append(buf, 0)
+	buf = append(buf, make([]byte, pad(len(hdr)+len(name)+1))...)
+	buf = append(buf, data...)
+	buf = append(buf, make([]byte, pad(len(data)))...)
+	_, w.err = w.w.Write(buf)
+	return w.err
+}
+
+// Dir adds a directory.
+func (w *Writer) Dir(name string, perm fs.FileMode) error {
+	return w.entry(name, modeDir|uint32(perm.Perm()), 0, 0, nil)
+}
+
+// File adds a regular file with the specified content.
+func (w *Writer) File(name string, perm fs.FileMode, data []byte) error {
+	return w.entry(name, modeRegular|uint32(perm.Perm()), 0, 0, data)
+}
+
+// Symlink adds a symbolic link to target.
+func (w *Writer) Symlink(name, target string) error {
+	return w.entry(name, modeSymlink|0777, 0, 0, []byte(target))
+}
+
+// CharDev adds a character device node, e.g. /dev/console (5, 1), which the
+// kernel opens for init before any devtmpfs is mounted.
+func (w *Writer) CharDev(name string, perm fs.FileMode, major, minor uint32) error {
+	return w.entry(name, modeCharDev|uint32(perm.Perm()), major, minor, nil)
+}
+
+// Close writes the trailer. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	return w.entry("TRAILER!!!", 0, 0, 0, nil)
+}
@@ -0,0 +1,136 @@
+package initramfs
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// entry is a decoded newc entry.
+type entry struct {
+	ino, mode, uid, gid, nlink, mtime uint32
+	rdevMajor, rdevMinor              uint32
+	name                              string
+	data                              string
+}
+
+// decode decodes a newc archive, checking the alignment of names and data and
+// that nothing follows the trailer.
+func decode(t *testing.T, b []byte) []entry {
+	t.Helper()
+	var entries []entry
+	off := 0
+	for {
+		if len(b)-off < 110 {
+			t.Fatalf("offset %d: truncated header", off)
+		}
+		hdr := string(b[off : off+110])
+		if magic := hdr[:6]; magic != "070701" {
+			t.Fatalf("offset %d: magic %q, want 070701", off, magic)
+		}
+		field := func(i int) uint32 {
+			v, err := strconv.ParseUint(hdr[6+8*i:6+8*(i+1)], 16, 32)
+			if err != nil {
+				t.Fatalf("offset %d: field %d: %v", off, i, err)
+			}
+			return uint32(v)
+		}
+		e := entry{
+			ino:       field(0),
+			mode:      field(1),
+			uid:       field(2),
+			gid:       field(3),
+			nlink:     field(4),
+			mtime:     field(5),
+			rdevMajor: field(9),
+			rdevMinor: field(10),
+		}
+		size, nameSize := int(field(6)), int(field(11))
+		if check := field(12); check != 0 {
+			t.Errorf("offset %d: checksum %#x, want 0", off, check)
+		}
+		name := b[off+110 : off+110+nameSize]
+		if name[nameSize-1] != 0 {
+			t.Fatalf("offset %d: name %q is not NUL-terminated", off, name)
+		}
+		e.name = string(name[:nameSize-1])
+		off += 110 + nameSize
+		off = checkPadding(t, b, off, e.name+" name")
+		e.data = string(b[off : off+size])
+		off += size
+		off = checkPadding(t, b, off, e.name+" data")
+		entries = append(entries, e)
+		if e.name == "TRAILER!!!" {
+			break
+		}
+	}
+	if off != len(b) {
+		t.Errorf("%d bytes after the trailer", len(b)-off)
+	}
+	return entries
+}
+
+// checkPadding checks that off is padded with zeros to a multiple of 4 and
+// returns the aligned offset.
+func checkPadding(t *testing.T, b []byte, off int, what string) int {
+	t.Helper()
+	n := pad(off)
+	if !bytes.Equal(b[off:off+n], make([]byte, n)) {
+		t.Errorf("%s: padding % x is not zero", what, b[off:off+n])
+	}
+	return off + n
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, err := range []error{
+		w.Dir("dev", 0755),
+		w.CharDev("/dev/console", 0600, 5, 1),
+		w.Dir("gokrazy", 0755),
+		w.File("gokrazy/init", 0755, []byte("#!init")),
+		w.File("gokrazy/expected.config", 0644, []byte("CONFIG_A=y\n")),
+		w.File("empty", 0644, nil),
+		w.Symlink("init", "gokrazy/init"),
+		w.Close(),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if buf.Len()%4 != 0 {
+		t.Errorf("archive size %d is not a multiple of 4", buf.Len())
+	}
+
+	want := []entry{
+		{mode: 0040755, nlink: 2, name: "dev"},
+		{mode: 0020600, nlink: 1, name: "dev/console", rdevMajor: 5, rdevMinor: 1},
+		{mode: 0040755, nlink: 2, name: "gokrazy"},
+		{mode: 0100755, nlink: 1, name: "gokrazy/init", data: "#!init"},
+		{mode: 0100644, nlink: 1, name: "gokrazy/expected.config", data: "CONFIG_A=y\n"},
+		{mode: 0100644, nlink: 1, name: "empty"},
+		{mode: 0120777, nlink: 1, name: "init", data: "gokrazy/init"},
+		{mode: 0, nlink: 1, name: "TRAILER!!!"},
+	}
+	got := decode(t, buf.Bytes())
+	if len(got) != len(want) {
+		t.Fatalf("%d entries, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		w.ino = uint32(i + 1)
+		if got[i] != w {
+			t.Errorf("entry %d = %+v, want %+v", i, got[i], w)
+		}
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	w := NewWriter(&bytes.Buffer{})
+	if err := w.File("gokrazy/init", 0755, nil); err == nil || !strings.Contains(err.Error(), "parent directory gokrazy not added") {
+		t.Errorf("File() without parent = %v, want a parent directory error", err)
+	}
+	if err := w.Dir("/", 0755); err == nil {
+		t.Errorf("Dir(/) succeeded")
+	}
+}
@@ -0,0 +1,113 @@
+package kconfig
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Change is an option whose value differs between two configs.
+type Change struct {
+	Option string
+	// Menu is the menu path of the option, in the new config unless the
+	// option was removed.
+	Menu string
+	// Old and New are the values, empty if the option does not exist in
+	// that config.
+	Old, New string
+}
+
+// ConfigDiff lists the differences between the final configs of two
+// kernel versions.
+type ConfigDiff struct {
+	OldVersion, NewVersion string
+	// Changes are sorted by menu and option.
+	Changes []Change
+	// Highlighted are the added options relevant to our hardware: those in
+	// a menu below "Device Drivers" in which the addendum sets an option.
+	Highlighted []Change
+}
+
+// DiffConfigs compares the configs of two versions. addendum is the config
+// addendum the new version was built with.
+func DiffConfigs(oldVersion string, oldConfig *Config, newVersion string, newConfig *Config, addendum []Option) *ConfigDiff {
+	d := &ConfigDiff{OldVersion: oldVersion, NewVersion: newVersion}
+	for name, value := range newConfig.Values {
+		if prev, ok := oldConfig.Values[name]; !ok || prev != value {
+			d.Changes = append(d.Changes, Change{Option: name, Menu: newConfig.Menus[name], Old: prev, New: value})
+		}
+	}
+	for name, value := range oldConfig.Values {
+		if _, ok := newConfig.Values[name]; !ok {
+			d.Changes = append(d.Changes, Change{Option: name, Menu: oldConfig.Menus[name], Old: value})
+		}
+	}
+	sort.Slice(d.Changes, func(i, j int) bool {
+		a, b := d.Changes[i], d.Changes[j]
+		if a.Menu != b.Menu {
+			return a.Menu < b.Menu
+		}
+		return a.Option < b.Option
+	})
+
+	hardware := make(map[string]bool)
+	for _, o := range addendum {
+		if menu, ok := newConfig.Menus[o.Name]; ok && strings.HasPrefix(menu, "Device Drivers > ") {
+			hardware[menu] = true
+		}
+	}
+	for _, c := range d.Changes {
+		if c.Old == "" && hardware[c.Menu] {
+			d.Highlighted = append(d.Highlighted, c)
+		}
+	}
+	return d
+}
+
+// WriteMarkdown writes the diff as markdown, e.g. for a commit message.
+func (d *ConfigDiff) WriteMarkdown(w io.Writer) error {
+	var added, removed int
+	for _, c := range d.Changes {
+		switch {
+		case c.Old == "":
+			added++
+		case c.New == "":
+			removed++
+		}
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "## Kernel config changes from %s to %s\n\n", d.OldVersion, d.NewVersion)
+	if len(d.Changes) == 0 {
+		fmt.Fprintf(bw, "The final .config is unchanged.\n")
+		return bw.Flush()
+	}
+	fmt.Fprintf(bw, "%d options changed: %d added, %d removed, %d with a new value.\n",
+		len(d.Changes), added, removed, len(d.Changes)-added-removed)
+
+	if len(d.Highlighted) > 0 {
+		fmt.Fprintf(bw, "\n### New options relevant to our hardware\n\n")
+		for _, c := range d.Highlighted {
+			fmt.Fprintf(bw, "- `%s=%s` (%s)\n", c.Option, c.New, c.Menu)
+		}
+	}
+
+	value := func(v string) string {
+		if v == "" {
+			return "—"
+		}
+		return "`" + v + "`"
+	}
+	for i, c := range d.Changes {
+		if i == 0 || c.Menu != d.Changes[i-1].Menu {
+			menu := c.Menu
+			if menu == "" {
+				menu = "(top level)"
+			}
+			fmt.Fprintf(bw, "\n### %s\n\n| Option | %s | %s |\n|---|---|---|\n", menu, d.OldVersion, d.NewVersion)
+		}
+		fmt.Fprintf(bw, "| %s | %s | %s |\n", c.Option, value(c.Old), value(c.New))
+	}
+	return bw.Flush()
+}
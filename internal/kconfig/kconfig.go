@@ -0,0 +1,228 @@
+// Package kconfig reads kernel config fragments and .config files.
+package kconfig
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	lineRe  = regexp.MustCompile(`^(CONFIG_[A-Za-z0-9_]+)=(.*)$`)
+	unsetRe = regexp.MustCompile(`^# (CONFIG_[A-Za-z0-9_]+) is not set$`)
+)
+
+// Option is a single option set by a config fragment.
+type Option struct {
+	Name  string
+	Value string
+	// Source is the file name and line number which set the option.
+	Source string
+}
+
+func (o Option) String() string {
+	return o.Name + "=" + o.Value
+}
+
+// ParseFragment parses a fragment in .config syntax, returning its options
+// in the order in which they appear. Options which are explicitly not set are
+// returned with value "n"; all other comments are ignored.
+func ParseFragment(fsys fs.FS, name string) ([]Option, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var options []Option
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		source := fmt.Sprintf("%s:%d", name, lineno)
+		if m := unsetRe.FindStringSubmatch(line); m != nil {
+			options = append(options, Option{Name: m[1], Value: "n", Source: source})
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m := lineRe.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("%s: syntax error: %q", source, line)
+		}
+		options = append(options, Option{Name: m[1], Value: m[2], Source: source})
+	}
+	return options, scanner.Err()
+}
+
+// Config is a .config file along with the Kconfig menu each option appears
+// in.
+type Config struct {
+	// Values maps option names to their values. Options which are not set
+	// have value "n"; options which do not exist are missing.
+	Values map[string]string
+	// Menus maps option names to their menu path, e.g.
+	// "Device Drivers > Watchdog Timer Support".
+	Menus map[string]string
+}
+
+// Value returns the value of option, which is "n" if the option does not
+// exist.
+func (c *Config) Value(option string) string {
+	if value, ok := c.Values[option]; ok {
+		return value
+	}
+	return "n"
+}
+
+// Parse reads a .config file written by make *config (e.g. the content of
+// /proc/config.gz). The menus are recovered from the comment blocks
+// ("#\n# Title\n#") and "# end of Title" lines the kernel writes around each
+// menu. Kconfig comments are written like menus without an end; they are
+// treated as a menu up to the end of their enclosing menu.
+func Parse(r io.Reader) (*Config, error) {
+	c := &Config{
+		Values: make(map[string]string),
+		Menus:  make(map[string]string),
+	}
+	var (
+		menus []string
+		// previous lines, to recognize the three line title blocks.
+		prev1, prev2 string
+	)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "#" && strings.HasPrefix(prev1, "# ") && prev2 == "#":
+			menus = append(menus, strings.TrimPrefix(prev1, "# "))
+		case strings.HasPrefix(line, "# end of "):
+			title := strings.TrimPrefix(line, "# end of ")
+			for i := len(menus) - 1; i >= 0; i-- {
+				if menus[i] == title {
+					menus = menus[:i]
+					break
+				}
+			}
+		default:
+			name, value := "", ""
+			if m := unsetRe.FindStringSubmatch(line); m != nil {
+				name, value = m[1], "n"
+			} else if m := lineRe.FindStringSubmatch(line); m != nil {
+				name, value = m[1], m[2]
+			}
+			if name != "" {
+				c.Values[name] = value
+				c.Menus[name] = strings.Join(menus, " > ")
+			}
+		}
+		prev2, prev1 = prev1, line
+	}
+	return c, scanner.Err()
+}
+
+// ParseFile reads the .config file at path, see Parse.
+func ParseFile(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// SplitList splits a comma-separated list, e.g. of fragment names as accepted
+// by the -fragments and -disable-fragments flags.
+func SplitList(s string) map[string]bool {
+	m := make(map[string]bool)
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			m[e] = true
+		}
+	}
+	return m
+}
+
+// SelectFragments returns the file names of the *.config fragments in fsys
+// which are used and skipped given the enabled (empty means all) and
+// disabled fragment names, each sorted by name.
+func SelectFragments(fsys fs.FS, enabled, disabled map[string]bool) (used, skipped []string, _ error) {
+	names, err := fs.Glob(fsys, "*.config")
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(names)
+	known := make(map[string]bool)
+	for _, name := range names {
+		known[FragmentName(name)] = true
+	}
+	for _, list := range []map[string]bool{enabled, disabled} {
+		for name := range list {
+			if !known[name] {
+				return nil, nil, fmt.Errorf("unknown config fragment %q", name)
+			}
+		}
+	}
+	for _, name := range names {
+		fragment := FragmentName(name)
+		if disabled[fragment] || (len(enabled) > 0 && !enabled[fragment]) {
+			skipped = append(skipped, name)
+		} else {
+			used = append(used, name)
+		}
+	}
+	return used, skipped, nil
+}
+
+// FragmentName returns the name of the fragment in file name, e.g. base for
+// base.config.
+func FragmentName(name string) string {
+	return strings.TrimSuffix(path.Base(name), ".config")
+}
+
+// LoadAddendum returns the options of all enabled fragments in fsys, ordered
+// by fragment file name and then by line. An option which is set to two
+// different values is an error; repeating an option with the same value is
+// tolerated and only the first occurrence is kept.
+func LoadAddendum(fsys fs.FS, enabled, disabled map[string]bool) ([]Option, error) {
+	used, skipped, err := SelectFragments(fsys, enabled, disabled)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range skipped {
+		log.Printf("skipping config fragment %s", FragmentName(name))
+	}
+
+	var addendum []Option
+	seen := make(map[string]Option)
+	var conflicts []string
+	for _, name := range used {
+		options, err := ParseFragment(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("using config fragment %s (%d options)", FragmentName(name), len(options))
+		for _, o := range options {
+			if prev, ok := seen[o.Name]; ok {
+				if prev.Value != o.Value {
+					conflicts = append(conflicts, fmt.Sprintf("%s: %s conflicts with %s: %s", o.Source, o, prev.Source, prev))
+				} else {
+					log.Printf("%s: %s already set in %s", o.Source, o, prev.Source)
+				}
+				continue
+			}
+			seen[o.Name] = o
+			addendum = append(addendum, o)
+		}
+	}
+	if len(conflicts) > 0 {
+		return nil, fmt.Errorf("conflicting config options:\n%s", strings.Join(conflicts, "\n"))
+	}
+	return addendum, nil
+}
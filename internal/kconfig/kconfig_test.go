@@ -0,0 +1,219 @@
+package kconfig
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+const dotConfig = `#
+# Automatically generated file; DO NOT EDIT.
+# Linux/x86 6.8.2 Kernel Configuration
+#
+CONFIG_64BIT=y
+
+#
+# Device Drivers
+#
+CONFIG_BLK_DEV_NVME=y
+
+#
+# Watchdog Timer Support
+#
+# CONFIG_SP5100_TCO is not set
+CONFIG_I6300ESB_WDT=m
+# end of Watchdog Timer Support
+CONFIG_DEVTMPFS=y
+# end of Device Drivers
+
+CONFIG_LOCALVERSION=""
+`
+
+func TestParse(t *testing.T) {
+	c, err := Parse(strings.NewReader(dotConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantValues := map[string]string{
+		"CONFIG_64BIT":        "y",
+		"CONFIG_BLK_DEV_NVME": "y",
+		"CONFIG_SP5100_TCO":   "n",
+		"CONFIG_I6300ESB_WDT": "m",
+		"CONFIG_DEVTMPFS":     "y",
+		"CONFIG_LOCALVERSION": `""`,
+	}
+	if !reflect.DeepEqual(c.Values, wantValues) {
+		t.Errorf("Values = %v, want %v", c.Values, wantValues)
+	}
+	// The generated header is not a title block.
+	wantMenus := map[string]string{
+		"CONFIG_64BIT":        "",
+		"CONFIG_BLK_DEV_NVME": "Device Drivers",
+		"CONFIG_SP5100_TCO":   "Device Drivers > Watchdog Timer Support",
+		"CONFIG_I6300ESB_WDT": "Device Drivers > Watchdog Timer Support",
+		"CONFIG_DEVTMPFS":     "Device Drivers",
+		"CONFIG_LOCALVERSION": "",
+	}
+	if !reflect.DeepEqual(c.Menus, wantMenus) {
+		t.Errorf("Menus = %v, want %v", c.Menus, wantMenus)
+	}
+	for option, want := range map[string]string{
+		"CONFIG_I6300ESB_WDT": "m",
+		"CONFIG_SP5100_TCO":   "n",
+		"CONFIG_MISSING":      "n",
+	} {
+		if got := c.Value(option); got != want {
+			t.Errorf("Value(%s) = %q, want %q", option, got, want)
+		}
+	}
+}
+
+// fragments are config fragments as in cmd/amd64-build-kernel/config.
+var fragments = fstest.MapFS{
+	"base.config": {Data: []byte("# base\nCONFIG_A=y\nCONFIG_B=m\n\n# CONFIG_C is not set\n")},
+	"wifi.config": {Data: []byte("CONFIG_WLAN=y\nCONFIG_A=y\n")},
+	"bbr.config":  {Data: []byte("CONFIG_TCP_CONG_BBR=y\n")},
+	"README":      {Data: []byte("not a fragment\n")},
+}
+
+func TestSelectFragments(t *testing.T) {
+	for _, tt := range []struct {
+		name              string
+		enabled, disabled string
+		used, skipped     []string
+		wantErr           string
+	}{
+		{
+			name: "all",
+			used: []string{"base.config", "bbr.config", "wifi.config"},
+		},
+		{
+			name:    "enabled",
+			enabled: "wifi,base",
+			used:    []string{"base.config", "wifi.config"},
+			skipped: []string{"bbr.config"},
+		},
+		{
+			name:     "disabled",
+			disabled: "wifi",
+			used:     []string{"base.config", "bbr.config"},
+			skipped:  []string{"wifi.config"},
+		},
+		{
+			name:     "disabled wins",
+			enabled:  "base,wifi",
+			disabled: "wifi",
+			used:     []string{"base.config"},
+			skipped:  []string{"bbr.config", "wifi.config"},
+		},
+		{
+			name:    "unknown enabled",
+			enabled: "base,bluetooth",
+			wantErr: `unknown config fragment "bluetooth"`,
+		},
+		{
+			name:     "unknown disabled",
+			disabled: "README",
+			wantErr:  `unknown config fragment "README"`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			used, skipped, err := SelectFragments(fragments, SplitList(tt.enabled), SplitList(tt.disabled))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("SelectFragments() = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(used, tt.used) || !reflect.DeepEqual(skipped, tt.skipped) {
+				t.Errorf("SelectFragments() = %v, %v, want %v, %v", used, skipped, tt.used, tt.skipped)
+			}
+		})
+	}
+}
+
+func TestLoadAddendum(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		fsys      fstest.MapFS
+		disabled  string
+		want      []string
+		wantErr   string
+		wantLines []string
+	}{
+		{
+			// Ordered by fragment file name, then by line. The repeated
+			// CONFIG_A=y of wifi.config is dropped.
+			name: "order",
+			fsys: fragments,
+			want: []string{
+				"base.config:2: CONFIG_A=y",
+				"base.config:3: CONFIG_B=m",
+				"base.config:5: CONFIG_C=n",
+				"bbr.config:1: CONFIG_TCP_CONG_BBR=y",
+				"wifi.config:1: CONFIG_WLAN=y",
+			},
+		},
+		{
+			name: "conflict",
+			fsys: fstest.MapFS{
+				"base.config": fragments["base.config"],
+				"wifi.config": {Data: []byte("CONFIG_WLAN=y\nCONFIG_B=y\n# CONFIG_A is not set\n")},
+			},
+			wantErr: "conflicting config options",
+			wantLines: []string{
+				"wifi.config:2: CONFIG_B=y conflicts with base.config:3: CONFIG_B=m",
+				"wifi.config:3: CONFIG_A=n conflicts with base.config:2: CONFIG_A=y",
+			},
+		},
+		{
+			name: "conflict in disabled fragment",
+			fsys: fstest.MapFS{
+				"base.config": fragments["base.config"],
+				"wifi.config": {Data: []byte("CONFIG_B=y\n")},
+			},
+			disabled: "wifi",
+			want: []string{
+				"base.config:2: CONFIG_A=y",
+				"base.config:3: CONFIG_B=m",
+				"base.config:5: CONFIG_C=n",
+			},
+		},
+		{
+			name: "syntax error",
+			fsys: fstest.MapFS{
+				"base.config": {Data: []byte("CONFIG_A=y\nA=y\n")},
+			},
+			wantErr: `base.config:2: syntax error: "A=y"`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			addendum, err := LoadAddendum(tt.fsys, nil, SplitList(tt.disabled))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadAddendum() = %v, want error containing %q", err, tt.wantErr)
+				}
+				for _, line := range tt.wantLines {
+					if !strings.Contains(err.Error(), line) {
+						t.Errorf("LoadAddendum() error %q does not contain %q", err, line)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, o := range addendum {
+				got = append(got, o.Source+": "+o.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadAddendum() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package kconfig
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PolicyClass is a class of options (e.g. the drivers of the root storage)
+// which must be built in (=y).
+type PolicyClass struct {
+	Name string
+	// Reason explains why the options must be built in.
+	Reason  string
+	Options []string
+}
+
+// Violation is an option of a PolicyClass which is not built in.
+type Violation struct {
+	Class  *PolicyClass
+	Option string
+	// Value is the value of the option in the final .config.
+	Value string
+	// Requested is the option as set by the addendum, if it sets it.
+	Requested *Option
+}
+
+func (v Violation) String() string {
+	var cause string
+	switch {
+	case v.Requested == nil:
+		cause = fmt.Sprintf("set by the defconfig or make olddefconfig; add %s=y to a config fragment", v.Option)
+	case v.Requested.Value != "y":
+		cause = fmt.Sprintf("requested as %s by %s", v.Requested.Value, v.Requested.Source)
+	default:
+		cause = fmt.Sprintf("requested as y by %s, but make olddefconfig changed it (does it depend on a module?)", v.Requested.Source)
+	}
+	return fmt.Sprintf("%s=%s (%s): %s; it must be built in: %s", v.Option, v.Value, v.Class.Name, cause, v.Class.Reason)
+}
+
+// CheckPolicy returns the options of classes which are not built in by the
+// final .config (see Parse), in the order of classes.
+func CheckPolicy(classes []PolicyClass, config *Config, addendum []Option) []Violation {
+	requested := make(map[string]*Option)
+	for i, o := range addendum {
+		requested[o.Name] = &addendum[i]
+	}
+	var violations []Violation
+	for i := range classes {
+		for _, option := range classes[i].Options {
+			value := config.Value(option)
+			if value == "y" {
+				continue
+			}
+			violations = append(violations, Violation{
+				Class:     &classes[i],
+				Option:    option,
+				Value:     value,
+				Requested: requested[option],
+			})
+		}
+	}
+	return violations
+}
+
+// PolicyError returns an error listing violations, or nil if there are none.
+func PolicyError(violations []Violation) error {
+	if len(violations) == 0 {
+		return nil
+	}
+	lines := make([]string, len(violations))
+	for i, v := range violations {
+		lines[i] = v.String()
+	}
+	return fmt.Errorf("%d boot-critical config options are not built in:\n  %s", len(violations), strings.Join(lines, "\n  "))
+}
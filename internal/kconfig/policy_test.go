@@ -0,0 +1,66 @@
+package kconfig
+
+import (
+	"strings"
+	"testing"
+)
+
+var testPolicy = []PolicyClass{
+	{
+		Name:    "root storage",
+		Reason:  "the root file system is mounted before any module is loaded",
+		Options: []string{"CONFIG_BLK_DEV_NVME", "CONFIG_VIRTIO_BLK", "CONFIG_SQUASHFS"},
+	},
+	{
+		Name:    "watchdog",
+		Reason:  "the watchdog must run before userspace",
+		Options: []string{"CONFIG_I6300ESB_WDT"},
+	},
+}
+
+func TestCheckPolicy(t *testing.T) {
+	config := &Config{Values: map[string]string{
+		"CONFIG_BLK_DEV_NVME": "y",
+		"CONFIG_VIRTIO_BLK":   "m",
+		"CONFIG_I6300ESB_WDT": "m",
+		// CONFIG_SQUASHFS does not exist.
+	}}
+	addendum := []Option{
+		{Name: "CONFIG_VIRTIO_BLK", Value: "m", Source: "virtio.config:3"},
+		{Name: "CONFIG_I6300ESB_WDT", Value: "y", Source: "watchdog.config:1"},
+	}
+	want := []string{
+		"CONFIG_VIRTIO_BLK=m (root storage): requested as m by virtio.config:3; it must be built in: the root file system is mounted before any module is loaded",
+		"CONFIG_SQUASHFS=n (root storage): set by the defconfig or make olddefconfig; add CONFIG_SQUASHFS=y to a config fragment; it must be built in: the root file system is mounted before any module is loaded",
+		"CONFIG_I6300ESB_WDT=m (watchdog): requested as y by watchdog.config:1, but make olddefconfig changed it (does it depend on a module?); it must be built in: the watchdog must run before userspace",
+	}
+	violations := CheckPolicy(testPolicy, config, addendum)
+	var got []string
+	for _, v := range violations {
+		got = append(got, v.String())
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("CheckPolicy():\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	err := PolicyError(violations)
+	if err == nil || !strings.HasPrefix(err.Error(), "3 boot-critical config options are not built in:\n  CONFIG_VIRTIO_BLK=m") {
+		t.Errorf("PolicyError() = %v", err)
+	}
+}
+
+func TestCheckPolicyBuiltIn(t *testing.T) {
+	config := &Config{Values: map[string]string{
+		"CONFIG_BLK_DEV_NVME": "y",
+		"CONFIG_VIRTIO_BLK":   "y",
+		"CONFIG_SQUASHFS":     "y",
+		"CONFIG_I6300ESB_WDT": "y",
+	}}
+	violations := CheckPolicy(testPolicy, config, nil)
+	if len(violations) != 0 {
+		t.Errorf("CheckPolicy() = %v, want none", violations)
+	}
+	if err := PolicyError(violations); err != nil {
+		t.Errorf("PolicyError() = %v, want nil", err)
+	}
+}
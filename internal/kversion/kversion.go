@@ -0,0 +1,140 @@
+// Package kversion parses and compares Linux kernel version numbers such as
+// 6.8.2 or 6.9-rc1.
+package kversion
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a parsed kernel version.
+type Version struct {
+	// Numbers are the dot-separated components, e.g. [6 8 2].
+	Numbers []int
+	// RC is the release candidate number, or 0 for a release.
+	RC int
+}
+
+// Parse parses a kernel version like 6.8, 6.8.2 or 6.9-rc1.
+func Parse(s string) (Version, error) {
+	var v Version
+	rest := s
+	if idx := strings.Index(rest, "-rc"); idx > -1 {
+		rc, err := strconv.Atoi(rest[idx+len("-rc"):])
+		if err != nil || rc < 1 {
+			return Version{}, fmt.Errorf("invalid kernel version %q: bad release candidate", s)
+		}
+		v.RC = rc
+		rest = rest[:idx]
+	}
+	for _, part := range strings.Split(rest, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return Version{}, fmt.Errorf("invalid kernel version %q", s)
+		}
+		v.Numbers = append(v.Numbers, n)
+	}
+	if len(v.Numbers) < 2 {
+		return Version{}, fmt.Errorf("invalid kernel version %q: want at least major.minor", s)
+	}
+	return v, nil
+}
+
+func (v Version) String() string {
+	parts := make([]string, len(v.Numbers))
+	for i, n := range v.Numbers {
+		parts[i] = strconv.Itoa(n)
+	}
+	s := strings.Join(parts, ".")
+	if v.RC > 0 {
+		s += fmt.Sprintf("-rc%d", v.RC)
+	}
+	return s
+}
+
+// Series returns the major.minor version, e.g. 6.8 for 6.8.2.
+func (v Version) Series() string {
+	return fmt.Sprintf("%d.%d", v.Numbers[0], v.Numbers[1])
+}
+
+// Compare returns -1, 0 or +1 depending on whether v is older than, the same
+// as or newer than w. Missing components count as 0, and a release candidate
+// is older than the corresponding release.
+func (v Version) Compare(w Version) int {
+	for i := 0; i < len(v.Numbers) || i < len(w.Numbers); i++ {
+		var a, b int
+		if i < len(v.Numbers) {
+			a = v.Numbers[i]
+		}
+		if i < len(w.Numbers) {
+			b = w.Numbers[i]
+		}
+		if a != b {
+			if a < b {
+				return -1
+			}
+			return +1
+		}
+	}
+	switch {
+	case v.RC == w.RC:
+		return 0
+	case v.RC == 0:
+		return +1
+	case w.RC == 0:
+		return -1
+	case v.RC < w.RC:
+		return -1
+	default:
+		return +1
+	}
+}
+
+// Constraint is a version requirement like >=6.6.
+type Constraint struct {
+	Op      string
+	Version Version
+}
+
+var ops = []string{">=", "<=", "!=", ">", "<", "="}
+
+// ParseConstraint parses a constraint of the form <op><version>, where op is
+// one of >=, <=, >, <, = or !=.
+func ParseConstraint(s string) (Constraint, error) {
+	for _, op := range ops {
+		if !strings.HasPrefix(s, op) {
+			continue
+		}
+		v, err := Parse(s[len(op):])
+		if err != nil {
+			return Constraint{}, err
+		}
+		return Constraint{Op: op, Version: v}, nil
+	}
+	return Constraint{}, fmt.Errorf("invalid version constraint %q: want one of %v followed by a version", s, ops)
+}
+
+func (c Constraint) String() string {
+	return c.Op + c.Version.String()
+}
+
+// Match reports whether v satisfies the constraint.
+func (c Constraint) Match(v Version) bool {
+	cmp := v.Compare(c.Version)
+	switch c.Op {
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package kversion
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		in     string
+		want   Version
+		series string
+	}{
+		{"6.8", Version{Numbers: []int{6, 8}}, "6.8"},
+		{"6.8.2", Version{Numbers: []int{6, 8, 2}}, "6.8"},
+		{"6.10.12", Version{Numbers: []int{6, 10, 12}}, "6.10"},
+		{"6.9-rc1", Version{Numbers: []int{6, 9}, RC: 1}, "6.9"},
+		{"6.10-rc12", Version{Numbers: []int{6, 10}, RC: 12}, "6.10"},
+	} {
+		got, err := Parse(tt.in)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+		if s := got.String(); s != tt.in {
+			t.Errorf("Parse(%q).String() = %q", tt.in, s)
+		}
+		if s := got.Series(); s != tt.series {
+			t.Errorf("Parse(%q).Series() = %q, want %q", tt.in, s, tt.series)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"6",
+		"6.",
+		"v6.8",
+		"6.8.x",
+		"6.-1",
+		"6.9-rc",
+		"6.9-rc0",
+		"6.9-rcx",
+		"6.9-rc1-rc2",
+	} {
+		if v, err := Parse(in); err == nil {
+			t.Errorf("Parse(%q) = %v, want an error", in, v)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	for _, tt := range []struct {
+		v, w string
+		want int
+	}{
+		{"6.10", "6.9", +1},
+		{"6.9.12", "6.10", -1},
+		{"6.10.1", "6.9.12", +1},
+		{"7.0", "6.19.3", +1},
+		{"6.8.2", "6.8.2", 0},
+		{"6.8.2", "6.8.10", -1},
+		// Missing components count as 0.
+		{"6.8", "6.8.0", 0},
+		{"6.8", "6.8.1", -1},
+		// A release candidate is older than the release, but newer than
+		// the previous series.
+		{"6.9-rc1", "6.9", -1},
+		{"6.9-rc1", "6.8.12", +1},
+		{"6.9-rc2", "6.9-rc1", +1},
+		{"6.9-rc10", "6.9-rc9", +1},
+		{"6.9-rc3", "6.9-rc3", 0},
+	} {
+		v, w := mustParse(t, tt.v), mustParse(t, tt.w)
+		if got := v.Compare(w); got != tt.want {
+			t.Errorf("%s.Compare(%s) = %d, want %d", tt.v, tt.w, got, tt.want)
+		}
+		if got := w.Compare(v); got != -tt.want {
+			t.Errorf("%s.Compare(%s) = %d, want %d", tt.w, tt.v, got, -tt.want)
+		}
+	}
+}
+
+func TestConstraint(t *testing.T) {
+	for _, tt := range []struct {
+		constraint string
+		v          string
+		want       bool
+	}{
+		{">=6.6", "6.6", true},
+		{">=6.6", "6.10", true},
+		{">=6.6", "6.5.13", false},
+		{">=6.9", "6.9-rc1", false},
+		{"<6.9", "6.9-rc1", true},
+		{"<6.9", "6.8.12", true},
+		{"<6.9", "6.10", false},
+		{"<=6.8.2", "6.8.2", true},
+		{"<=6.8.2", "6.8.3", false},
+		{">6.8", "6.8.1", true},
+		{">6.8", "6.8", false},
+		{"=6.8", "6.8.0", true},
+		{"=6.8", "6.8.1", false},
+		{"!=6.8.2", "6.8.2", false},
+		{"!=6.8.2", "6.8.3", true},
+	} {
+		c, err := ParseConstraint(tt.constraint)
+		if err != nil {
+			t.Errorf("ParseConstraint(%q): %v", tt.constraint, err)
+			continue
+		}
+		if s := c.String(); s != tt.constraint {
+			t.Errorf("ParseConstraint(%q).String() = %q", tt.constraint, s)
+		}
+		if got := c.Match(mustParse(t, tt.v)); got != tt.want {
+			t.Errorf("%s.Match(%s) = %v, want %v", tt.constraint, tt.v, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "6.6", "~6.6", ">=", ">=6", "=>6.6"} {
+		if c, err := ParseConstraint(in); err == nil {
+			t.Errorf("ParseConstraint(%q) = %v, want an error", in, c)
+		}
+	}
+}
+
+func mustParse(t *testing.T, s string) Version {
+	t.Helper()
+	v, err := Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
@@ -0,0 +1,367 @@
+package patch
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Tree is the file tree a patch is applied to. Names are slash-separated
+// and relative to the root of the tree.
+type Tree interface {
+	ReadFile(name string) ([]byte, os.FileMode, error)
+	WriteFile(name string, data []byte, mode os.FileMode) error
+	Remove(name string) error
+}
+
+// DirTree is a Tree on disk.
+type DirTree string
+
+// path returns the path of name on disk. Names come from patch headers, so
+// absolute names and names leaving the tree (via ..) are rejected.
+func (d DirTree) path(name string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", fmt.Errorf("refusing to access %q: path outside of the tree", name)
+	}
+	return filepath.Join(string(d), filepath.FromSlash(name)), nil
+}
+
+func (d DirTree) ReadFile(name string) ([]byte, os.FileMode, error) {
+	path, err := d.path(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	b, err := os.ReadFile(path)
+	return b, st.Mode().Perm(), err
+}
+
+func (d DirTree) WriteFile(name string, data []byte, mode os.FileMode) error {
+	path, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Write to a temporary file first, so that a failed write does not
+	// leave a truncated file behind.
+	tmp := path + ".patch-tmp"
+	if err := os.WriteFile(tmp, data, mode); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Remove removes the file name and, like GNU patch, its parent directories
+// which became empty.
+func (d DirTree) Remove(name string) error {
+	p, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil {
+		return err
+	}
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if os.Remove(filepath.Join(string(d), filepath.FromSlash(dir))) != nil {
+			break // not empty
+		}
+	}
+	return nil
+}
+
+// Overlay is a Tree which records all changes in memory instead of applying
+// them to the underlying Base tree, e.g. for a dry run.
+type Overlay struct {
+	Base  Tree
+	files map[string]*overlayFile
+}
+
+type overlayFile struct {
+	data    []byte
+	mode    os.FileMode
+	deleted bool
+}
+
+func (o *Overlay) ReadFile(name string) ([]byte, os.FileMode, error) {
+	if f, ok := o.files[name]; ok {
+		if f.deleted {
+			return nil, 0, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		return f.data, f.mode, nil
+	}
+	return o.Base.ReadFile(name)
+}
+
+func (o *Overlay) WriteFile(name string, data []byte, mode os.FileMode) error {
+	if o.files == nil {
+		o.files = make(map[string]*overlayFile)
+	}
+	o.files[name] = &overlayFile{data: data, mode: mode}
+	return nil
+}
+
+func (o *Overlay) Remove(name string) error {
+	if _, _, err := o.ReadFile(name); err != nil {
+		return err
+	}
+	if o.files == nil {
+		o.files = make(map[string]*overlayFile)
+	}
+	o.files[name] = &overlayFile{deleted: true}
+	return nil
+}
+
+// Options control how hunks are located.
+type Options struct {
+	// MaxFuzz is the number of leading and trailing context lines which may
+	// be ignored to apply a hunk (like patch -F). GNU patch defaults to 2.
+	MaxFuzz int
+}
+
+// HunkResult describes where a hunk was applied, or that it failed.
+type HunkResult struct {
+	File string
+	// Hunk is the 1-based index of the hunk within the file.
+	Hunk int
+	// Line is the 1-based line at which the hunk was applied, or at which
+	// it was expected if it failed.
+	Line   int
+	Offset int
+	Fuzz   int
+	Failed bool
+}
+
+func (r HunkResult) String() string {
+	if r.Failed {
+		return fmt.Sprintf("%s: hunk #%d FAILED at %d", r.File, r.Hunk, r.Line)
+	}
+	s := fmt.Sprintf("%s: hunk #%d succeeded at %d", r.File, r.Hunk, r.Line)
+	if r.Offset != 0 {
+		s += fmt.Sprintf(" (offset %d lines)", r.Offset)
+	}
+	if r.Fuzz != 0 {
+		s += fmt.Sprintf(" with fuzz %d", r.Fuzz)
+	}
+	return s
+}
+
+// ApplyError is returned by Apply if any file or hunk could not be applied.
+type ApplyError struct {
+	// Failed contains the hunks which did not apply.
+	Failed []HunkResult
+	// Errs contains file-level errors, e.g. a file to be created already
+	// exists.
+	Errs []error
+}
+
+func (e *ApplyError) Error() string {
+	var lines []string
+	for _, err := range e.Errs {
+		lines = append(lines, err.Error())
+	}
+	for _, r := range e.Failed {
+		lines = append(lines, r.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Apply applies the changes of files to t. Like GNU patch, it continues with
+// the remaining hunks and files after a failure; any failures are returned
+// as an *ApplyError. The returned results include every hunk.
+func Apply(t Tree, files []*File, opts Options) ([]HunkResult, error) {
+	var results []HunkResult
+	applyErr := &ApplyError{}
+	for _, f := range files {
+		res, err := applyFile(t, f, opts)
+		results = append(results, res...)
+		for _, r := range res {
+			if r.Failed {
+				applyErr.Failed = append(applyErr.Failed, r)
+			}
+		}
+		if err != nil {
+			applyErr.Errs = append(applyErr.Errs, fmt.Errorf("%s: %v", f.Name(), err))
+		}
+	}
+	if len(applyErr.Failed) > 0 || len(applyErr.Errs) > 0 {
+		return results, applyErr
+	}
+	return results, nil
+}
+
+// splitLines splits b after each newline. The last line lacks the newline
+// if b does not end in one.
+func splitLines(b []byte) []string {
+	s := string(b)
+	var lines []string
+	for s != "" {
+		idx := strings.IndexByte(s, '\n')
+		if idx == -1 {
+			lines = append(lines, s)
+			break
+		}
+		lines = append(lines, s[:idx+1])
+		s = s[idx+1:]
+	}
+	return lines
+}
+
+func applyFile(t Tree, f *File, opts Options) ([]HunkResult, error) {
+	var content []string
+	mode := os.FileMode(0644)
+	if f.IsNew() {
+		if _, _, err := t.ReadFile(f.NewName); err == nil {
+			return nil, fmt.Errorf("file to be created already exists")
+		}
+	} else {
+		b, m, err := t.ReadFile(f.OldName)
+		if err != nil {
+			return nil, err
+		}
+		content = splitLines(b)
+		mode = m
+	}
+	if f.OldMode != 0 && !f.IsNew() && f.OldMode != mode {
+		return nil, fmt.Errorf("unexpected mode %o, want %o", mode, f.OldMode)
+	}
+	if f.NewMode != 0 {
+		mode = f.NewMode
+	}
+
+	var results []HunkResult
+	failed := false
+	// minPos ensures hunks are applied in order without overlapping.
+	minPos := 0
+	// delta is the net number of lines added by the hunks so far.
+	delta := 0
+	for i, h := range f.Hunks {
+		expected := h.OldStart - 1
+		if h.OldLines == 0 {
+			// -N,0 inserts after line N
+			expected = h.OldStart
+		}
+		expected += delta
+		r := HunkResult{File: f.Name(), Hunk: i + 1}
+		pos, top, fuzz, ok := locate(content, h, expected, minPos, opts.MaxFuzz)
+		if !ok {
+			r.Failed = true
+			r.Line = expected + 1
+			results = append(results, r)
+			failed = true
+			continue
+		}
+		old, new := trimContext(h, top, fuzz)
+		r.Line = pos - top + 1
+		r.Offset = pos - top - expected
+		r.Fuzz = fuzz
+		results = append(results, r)
+
+		replaced := append([]string{}, content[:pos]...)
+		replaced = append(replaced, new...)
+		replaced = append(replaced, content[pos+len(old):]...)
+		content = replaced
+		minPos = pos + len(new)
+		delta += len(new) - len(old)
+	}
+	if failed {
+		return results, nil
+	}
+
+	if f.IsDelete() {
+		if len(content) > 0 {
+			return results, fmt.Errorf("file to be deleted is not empty after applying the patch")
+		}
+		return results, t.Remove(f.OldName)
+	}
+	if err := t.WriteFile(f.NewName, []byte(strings.Join(content, "")), mode); err != nil {
+		return results, err
+	}
+	if f.IsRename() {
+		if err := t.Remove(f.OldName); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// leadingContext returns the number of context lines at the start (or, if
+// fromEnd, the end) of the hunk.
+func leadingContext(h *Hunk, fromEnd bool) int {
+	n := 0
+	for i := range h.Lines {
+		l := h.Lines[i]
+		if fromEnd {
+			l = h.Lines[len(h.Lines)-1-i]
+		}
+		if l.Kind != ' ' {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// trimContext returns the old and new lines of h with up to fuzz context
+// lines removed from both ends. top is the number of lines removed from the
+// start.
+func trimContext(h *Hunk, top, fuzz int) (old, new []string) {
+	old, new = h.Old(), h.New()
+	bottom := fuzz
+	if n := leadingContext(h, true); bottom > n {
+		bottom = n
+	}
+	if bottom > len(old)-top {
+		bottom = len(old) - top
+	}
+	return old[top : len(old)-bottom], new[top : len(new)-bottom]
+}
+
+func matchAt(content, old []string, pos int) bool {
+	if pos < 0 || pos+len(old) > len(content) {
+		return false
+	}
+	for i, l := range old {
+		if content[pos+i] != l {
+			return false
+		}
+	}
+	return true
+}
+
+// locate finds the position at which (the possibly fuzzed) old lines of h
+// occur in content, searching outwards from expected. It returns the position
+// of the first matched line, the number of context lines trimmed from the
+// start of the hunk, and the fuzz factor used.
+func locate(content []string, h *Hunk, expected, minPos, maxFuzz int) (pos, top, fuzz int, ok bool) {
+	for fuzz = 0; fuzz <= maxFuzz; fuzz++ {
+		top = fuzz
+		if n := leadingContext(h, false); top > n {
+			top = n
+		}
+		if fuzz > 0 && top == 0 && leadingContext(h, true) == 0 {
+			break // nothing to trim, more fuzz does not help
+		}
+		old, _ := trimContext(h, top, fuzz)
+		start := expected + top
+		for d := 0; start-d >= minPos || start+d <= len(content); d++ {
+			if p := start + d; p >= minPos && matchAt(content, old, p) {
+				return p, top, fuzz, true
+			}
+			if p := start - d; d > 0 && p >= minPos && matchAt(content, old, p) {
+				return p, top, fuzz, true
+			}
+		}
+	}
+	return 0, 0, 0, false
+}
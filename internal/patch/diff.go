@@ -0,0 +1,131 @@
+package patch
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Diff returns a unified diff (with three lines of context) which turns old
+// into new, or "" if they are equal. It computes a longest common
+// subsequence in quadratic time and space and is meant for small files such
+// as generated sources.
+func Diff(oldName, newName string, old, new []byte) string {
+	a, b := splitLines(old), splitLines(new)
+	lines := diffLines(a, b)
+
+	const context = 3
+	var out strings.Builder
+	for i := 0; i < len(lines); {
+		if lines[i].Kind == ' ' {
+			i++
+			continue
+		}
+		// Extend the hunk while changes are at most 2*context lines apart.
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(lines); j++ {
+			if lines[j].Kind != ' ' {
+				end = j + 1
+			} else if j-end >= 2*context {
+				break
+			}
+		}
+		end += context
+		if end > len(lines) {
+			end = len(lines)
+		}
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+		}
+		h := &Hunk{Lines: lines[start:end]}
+		// Count the lines before the hunk to find its start.
+		for _, l := range lines[:start] {
+			if l.Kind != '+' {
+				h.OldStart++
+			}
+			if l.Kind != '-' {
+				h.NewStart++
+			}
+		}
+		for _, l := range h.Lines {
+			if l.Kind != '+' {
+				h.OldLines++
+			}
+			if l.Kind != '-' {
+				h.NewLines++
+			}
+		}
+		// Like diff -u, empty ranges start at the line before them.
+		if h.OldLines > 0 {
+			h.OldStart++
+		}
+		if h.NewLines > 0 {
+			h.NewStart++
+		}
+		out.WriteString(h.String())
+		i = end
+	}
+	return out.String()
+}
+
+// diffLines returns the lines of a and b as context, removed and added
+// lines.
+func diffLines(a, b []string) []Line {
+	// Strip the common prefix and suffix, which covers most of the input
+	// for typical changes.
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	var lines []Line
+	for _, l := range a[:prefix] {
+		lines = append(lines, Line{Kind: ' ', Text: l})
+	}
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	// lcs[i][j] is the length of the longest common subsequence of ma[i:]
+	// and mb[j:].
+	lcs := make([][]int, len(ma)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(mb)+1)
+	}
+	for i := len(ma) - 1; i >= 0; i-- {
+		for j := len(mb) - 1; j >= 0; j-- {
+			switch {
+			case ma[i] == mb[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(ma) || j < len(mb) {
+		switch {
+		case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+			lines = append(lines, Line{Kind: ' ', Text: ma[i]})
+			i++
+			j++
+		case j == len(mb) || (i < len(ma) && lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, Line{Kind: '-', Text: ma[i]})
+			i++
+		default:
+			lines = append(lines, Line{Kind: '+', Text: mb[j]})
+			j++
+		}
+	}
+
+	for _, l := range a[len(a)-suffix:] {
+		lines = append(lines, Line{Kind: ' ', Text: l})
+	}
+	return lines
+}
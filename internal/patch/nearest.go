@@ -0,0 +1,74 @@
+package patch
+
+import "strings"
+
+// Match is a region of a file which resembles the old lines of a hunk.
+type Match struct {
+	// Line is the 1-based line at which the region starts, or 0 if no line
+	// of the hunk was found at all.
+	Line int
+	// Lines are the lines of the region.
+	Lines []string
+	// Similarity is the fraction of the old lines of the hunk which occur
+	// (in order) in the region, ignoring leading and trailing whitespace.
+	Similarity float64
+}
+
+// NearestMatch returns the region of content which best matches the old lines
+// of h, e.g. to help refreshing a hunk which failed to apply. Of equally good
+// regions, the one closest to expected (a 1-based line) wins.
+func NearestMatch(content []byte, h *Hunk, expected int) Match {
+	lines := splitLines(content)
+	old := h.Old()
+	if len(old) == 0 || len(lines) == 0 {
+		return Match{}
+	}
+	norm := func(lines []string) []string {
+		n := make([]string, len(lines))
+		for i, l := range lines {
+			n[i] = strings.TrimSpace(l)
+		}
+		return n
+	}
+	want, have := norm(old), norm(lines)
+
+	size := len(old)
+	if size > len(lines) {
+		size = len(lines)
+	}
+	var best Match
+	bestScore, bestDist := 0, 0
+	for pos := 0; pos+size <= len(lines); pos++ {
+		score := lcsLength(want, have[pos:pos+size])
+		dist := pos + 1 - expected
+		if dist < 0 {
+			dist = -dist
+		}
+		if score > bestScore || (score == bestScore && score > 0 && dist < bestDist) {
+			bestScore, bestDist = score, dist
+			best = Match{Line: pos + 1, Lines: lines[pos : pos+size]}
+		}
+	}
+	best.Similarity = float64(bestScore) / float64(len(old))
+	return best
+}
+
+// lcsLength returns the length of the longest common subsequence of a and b.
+func lcsLength(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for i := range a {
+		for j := range b {
+			switch {
+			case a[i] == b[j]:
+				cur[j+1] = prev[j] + 1
+			case prev[j+1] > cur[j]:
+				cur[j+1] = prev[j+1]
+			default:
+				cur[j+1] = cur[j]
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,389 @@
+// Package patch parses unified diffs (including the git extensions for file
+// creation, deletion, renames and mode changes) and applies them to a
+// directory tree, without depending on GNU patch.
+package patch
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// File describes the changes to a single file.
+type File struct {
+	// OldName and NewName are the paths before and after the change, with
+	// the leading path components already stripped. OldName is empty for
+	// created files, NewName is empty for deleted files.
+	OldName string
+	NewName string
+
+	// OldMode and NewMode are the permission bits declared in git
+	// extended headers, or 0 if none were declared.
+	OldMode os.FileMode
+	NewMode os.FileMode
+
+	Hunks []*Hunk
+}
+
+// IsNew reports whether the file is created by the patch.
+func (f *File) IsNew() bool { return f.OldName == "" }
+
+// IsDelete reports whether the file is deleted by the patch.
+func (f *File) IsDelete() bool { return f.NewName == "" }
+
+// IsRename reports whether the file is moved by the patch.
+func (f *File) IsRename() bool {
+	return !f.IsNew() && !f.IsDelete() && f.OldName != f.NewName
+}
+
+// Name returns the most meaningful name of the file for messages.
+func (f *File) Name() string {
+	if f.NewName != "" {
+		return f.NewName
+	}
+	return f.OldName
+}
+
+// Hunk is a contiguous block of changes.
+type Hunk struct {
+	// OldStart and NewStart are the 1-based line numbers from the @@ header.
+	OldStart, OldLines int
+	NewStart, NewLines int
+	// Section is the text after the closing @@, e.g. a function name.
+	Section string
+	Lines   []Line
+}
+
+// Line is a line of a hunk.
+type Line struct {
+	// Kind is ' ' for context, '-' for removed and '+' for added lines.
+	Kind byte
+	// Text is the line content including the trailing newline, which is
+	// absent if the line was followed by “\ No newline at end of file”.
+	Text string
+}
+
+// Old returns the lines the hunk expects to find.
+func (h *Hunk) Old() []string {
+	var lines []string
+	for _, l := range h.Lines {
+		if l.Kind != '+' {
+			lines = append(lines, l.Text)
+		}
+	}
+	return lines
+}
+
+// New returns the lines the hunk replaces the old lines with.
+func (h *Hunk) New() []string {
+	var lines []string
+	for _, l := range h.Lines {
+		if l.Kind != '-' {
+			lines = append(lines, l.Text)
+		}
+	}
+	return lines
+}
+
+// String returns the hunk in unified diff format.
+func (h *Hunk) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+	if h.Section != "" {
+		b.WriteString(" " + h.Section)
+	}
+	b.WriteString("\n")
+	for _, l := range h.Lines {
+		b.WriteByte(l.Kind)
+		b.WriteString(l.Text)
+		if !strings.HasSuffix(l.Text, "\n") {
+			b.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+	return b.String()
+}
+
+var hunkHeaderRe = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@ ?(.*)$`)
+
+// ParseFile parses the patch file at path, see Parse.
+func ParseFile(path string, strip int) ([]*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	files, err := Parse(f, strip)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return files, nil
+}
+
+// Parse parses a unified diff, stripping strip leading path components from
+// file names (like patch -p). Text before, between and after the diffs (e.g.
+// mail headers and diffstat) is ignored.
+func Parse(r io.Reader, strip int) ([]*File, error) {
+	p := &parser{scanner: bufio.NewScanner(r), strip: strip}
+	p.scanner.Buffer(nil, 16*1024*1024)
+	return p.parse()
+}
+
+type parser struct {
+	scanner *bufio.Scanner
+	strip   int
+	lineno  int
+	// peeked is a line which was read but not consumed yet.
+	peeked *string
+}
+
+func (p *parser) next() (string, bool) {
+	if p.peeked != nil {
+		line := *p.peeked
+		p.peeked = nil
+		return line, true
+	}
+	if !p.scanner.Scan() {
+		return "", false
+	}
+	p.lineno++
+	return p.scanner.Text(), true
+}
+
+func (p *parser) unread(line string) {
+	p.peeked = &line
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.lineno, fmt.Sprintf(format, args...))
+}
+
+// stripName removes the timestamp git/diff may append after a tab, and the
+// first p.strip path components. /dev/null is returned as "".
+func (p *parser) stripName(name string) string {
+	if idx := strings.IndexByte(name, '\t'); idx > -1 {
+		name = name[:idx]
+	}
+	name = strings.TrimSpace(name)
+	if name == "/dev/null" {
+		return ""
+	}
+	parts := strings.Split(name, "/")
+	if len(parts) > p.strip {
+		parts = parts[p.strip:]
+	}
+	return strings.Join(parts, "/")
+}
+
+// parseMode parses a git file mode. Only regular files are supported;
+// symlinks (120000) and submodules (160000) are rejected, as they would
+// otherwise be written as regular files.
+func parseMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(strings.TrimSpace(s), 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	if mode&0170000 != 0100000 {
+		return 0, fmt.Errorf("unsupported file mode %06o: only regular files are supported", mode)
+	}
+	return os.FileMode(mode) & os.ModePerm, nil
+}
+
+func (p *parser) parse() ([]*File, error) {
+	var files []*File
+	var cur *File
+	// git is true while parsing the extended headers of a diff --git.
+	git := false
+	for {
+		line, ok := p.next()
+		if !ok {
+			break
+		}
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			names := strings.Fields(strings.TrimPrefix(line, "diff --git "))
+			if len(names) != 2 {
+				return nil, p.errorf("cannot parse %q", line)
+			}
+			cur = &File{
+				OldName: p.stripName(names[0]),
+				NewName: p.stripName(names[1]),
+			}
+			files = append(files, cur)
+			git = true
+
+		case git && strings.HasPrefix(line, "new file mode "):
+			mode, err := parseMode(strings.TrimPrefix(line, "new file mode "))
+			if err != nil {
+				return nil, p.errorf("%v", err)
+			}
+			cur.OldName = ""
+			cur.NewMode = mode
+
+		case git && strings.HasPrefix(line, "deleted file mode "):
+			mode, err := parseMode(strings.TrimPrefix(line, "deleted file mode "))
+			if err != nil {
+				return nil, p.errorf("%v", err)
+			}
+			cur.NewName = ""
+			cur.OldMode = mode
+
+		case git && strings.HasPrefix(line, "old mode "):
+			mode, err := parseMode(strings.TrimPrefix(line, "old mode "))
+			if err != nil {
+				return nil, p.errorf("%v", err)
+			}
+			cur.OldMode = mode
+
+		case git && strings.HasPrefix(line, "new mode "):
+			mode, err := parseMode(strings.TrimPrefix(line, "new mode "))
+			if err != nil {
+				return nil, p.errorf("%v", err)
+			}
+			cur.NewMode = mode
+
+		case git && strings.HasPrefix(line, "rename from "):
+			// Rename paths carry no a/ b/ prefixes.
+			cur.OldName = strings.TrimPrefix(line, "rename from ")
+
+		case git && strings.HasPrefix(line, "rename to "):
+			cur.NewName = strings.TrimPrefix(line, "rename to ")
+
+		case git && strings.HasPrefix(line, "copy from "),
+			git && strings.HasPrefix(line, "copy to "):
+			return nil, p.errorf("copies are not supported")
+
+		case git && (strings.HasPrefix(line, "GIT binary patch") ||
+			strings.HasPrefix(line, "Binary files ")):
+			return nil, p.errorf("binary patches are not supported (%s)", cur.Name())
+
+		case strings.HasPrefix(line, "--- "):
+			next, ok := p.next()
+			if !ok || !strings.HasPrefix(next, "+++ ") {
+				// e.g. a line removing “-- ” in a hunk-less context
+				if ok {
+					p.unread(next)
+				}
+				continue
+			}
+			oldName := p.stripName(strings.TrimPrefix(line, "--- "))
+			newName := p.stripName(strings.TrimPrefix(next, "+++ "))
+			if !git {
+				cur = &File{}
+				files = append(files, cur)
+			}
+			// For git diffs, the names from the extended headers
+			// (which also cover renames) take precedence, except
+			// for creation and deletion.
+			if !git || oldName == "" {
+				cur.OldName = oldName
+			}
+			if !git || newName == "" {
+				cur.NewName = newName
+			}
+			git = false
+			if err := p.parseHunks(cur); err != nil {
+				return nil, err
+			}
+
+		case strings.HasPrefix(line, "@@ "):
+			return nil, p.errorf("hunk without file header")
+
+		default:
+			if git && (strings.HasPrefix(line, "index ") ||
+				strings.HasPrefix(line, "similarity index ") ||
+				strings.HasPrefix(line, "dissimilarity index ")) {
+				continue
+			}
+			// Mail headers, commit message, diffstat, signature.
+			git = false
+		}
+	}
+	if err := p.scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no diffs found")
+	}
+	return files, nil
+}
+
+func atoiDefault(s string, def int) int {
+	if s == "" {
+		return def
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+func (p *parser) parseHunks(f *File) error {
+	for {
+		line, ok := p.next()
+		if !ok {
+			return nil
+		}
+		m := hunkHeaderRe.FindStringSubmatch(line)
+		if m == nil {
+			p.unread(line)
+			return nil
+		}
+		h := &Hunk{
+			OldStart: atoiDefault(m[1], 0),
+			OldLines: atoiDefault(m[2], 1),
+			NewStart: atoiDefault(m[3], 0),
+			NewLines: atoiDefault(m[4], 1),
+			Section:  m[5],
+		}
+		oldLeft, newLeft := h.OldLines, h.NewLines
+		for oldLeft > 0 || newLeft > 0 {
+			line, ok := p.next()
+			if !ok {
+				return p.errorf("%s: truncated hunk %s", f.Name(), strings.TrimSpace(m[0]))
+			}
+			if line == "" {
+				// Some tools strip the trailing space of empty
+				// context lines.
+				line = " "
+			}
+			kind := line[0]
+			switch kind {
+			case ' ':
+				oldLeft--
+				newLeft--
+			case '-':
+				oldLeft--
+			case '+':
+				newLeft--
+			case '\\':
+				p.markNoNewline(h)
+				continue
+			default:
+				return p.errorf("%s: unexpected line %q in hunk", f.Name(), line)
+			}
+			if oldLeft < 0 || newLeft < 0 {
+				return p.errorf("%s: hunk longer than declared in its header", f.Name())
+			}
+			h.Lines = append(h.Lines, Line{Kind: kind, Text: line[1:] + "\n"})
+		}
+		// A “\ No newline at end of file” marker may follow the last line.
+		if line, ok := p.next(); ok {
+			if strings.HasPrefix(line, "\\") {
+				p.markNoNewline(h)
+			} else {
+				p.unread(line)
+			}
+		}
+		f.Hunks = append(f.Hunks, h)
+	}
+}
+
+func (p *parser) markNoNewline(h *Hunk) {
+	if len(h.Lines) == 0 {
+		return
+	}
+	last := &h.Lines[len(h.Lines)-1]
+	last.Text = strings.TrimSuffix(last.Text, "\n")
+}
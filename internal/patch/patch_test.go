@@ -0,0 +1,374 @@
+package patch
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// file is the content and permission bits of a file in a test tree.
+type file struct {
+	content string
+	mode    os.FileMode
+}
+
+func writeTree(t *testing.T, dir string, files map[string]file) {
+	t.Helper()
+	for name, f := range files {
+		mode := f.mode
+		if mode == 0 {
+			mode = 0644
+		}
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(f.content), mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTree returns all files below dir.
+func readTree(t *testing.T, dir string) map[string]file {
+	t.Helper()
+	files := make(map[string]file)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = file{content: string(b), mode: info.Mode().Perm()}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func compareTree(t *testing.T, got, want map[string]file) {
+	t.Helper()
+	for name, w := range want {
+		if w.mode == 0 {
+			w.mode = 0644
+		}
+		g, ok := got[name]
+		if !ok {
+			t.Errorf("%s: missing", name)
+			continue
+		}
+		if g.content != w.content {
+			t.Errorf("%s: content = %q, want %q", name, g.content, w.content)
+		}
+		if g.mode != w.mode {
+			t.Errorf("%s: mode = %o, want %o", name, g.mode, w.mode)
+		}
+	}
+	for name := range got {
+		if _, ok := want[name]; !ok {
+			t.Errorf("%s: unexpected file", name)
+		}
+	}
+}
+
+var makefile = "# SPDX-License-Identifier: GPL-2.0\n\n\nVERSION = 6\nPATCHLEVEL = 7\nSUBLEVEL = 0\n"
+
+// The files format-patch.patch applies to.
+const (
+	modulesScript = `#!/bin/sh
+# SPDX-License-Identifier: GPL-2.0
+# Installs the modules into the gokrazy root file system.
+
+set -e
+
+MODULES_DIR="$1"
+make INSTALL_MOD_PATH="$MODULES_DIR" modules_install
+find "$MODULES_DIR" -name build -type l -delete
+find "$MODULES_DIR" -name source -type l -delete
+`
+	firmwareScript = `#!/bin/sh
+# SPDX-License-Identifier: GPL-2.0
+# Copies the firmware files into the gokrazy root file system.
+
+set -e
+
+cp -r firmware "$1"
+`
+)
+
+func packageMakefile(script string) string {
+	return "# SPDX-License-Identifier: GPL-2.0\n\nPHONY += gokrazy-pkg\ngokrazy-pkg:\n" +
+		"\t$(CONFIG_SHELL) $(srctree)/scripts/package/" + script + " $(objtree)/gokrazy\n"
+}
+
+func TestApply(t *testing.T) {
+	for _, tt := range []struct {
+		patch  string
+		strip  int
+		before map[string]file
+		after  map[string]file
+		// results are the expected HunkResult strings.
+		results []string
+		wantErr string
+	}{
+		{
+			patch:   "rename.patch",
+			strip:   1,
+			before:  map[string]file{"old.txt": {content: "one\ntwo\nthree\n"}},
+			after:   map[string]file{"new.txt": {content: "one\nTWO\nthree\n"}},
+			results: []string{"new.txt: hunk #1 succeeded at 1"},
+		},
+		{
+			patch:  "mode.patch",
+			strip:  1,
+			before: map[string]file{"script.sh": {content: "#!/bin/sh\n"}},
+			after:  map[string]file{"script.sh": {content: "#!/bin/sh\n", mode: 0755}},
+		},
+		{
+			// Written by git format-patch: a mail with a commit
+			// message, diffstat and signature, a mode change and a
+			// rename with a mode change and a hunk.
+			patch: "format-patch.patch",
+			strip: 1,
+			before: map[string]file{
+				"scripts/Makefile.package":            {content: packageMakefile("gokrazy-modules.sh")},
+				"scripts/package/gokrazy-firmware.sh": {content: firmwareScript},
+				"scripts/package/gokrazy-modules.sh":  {content: modulesScript},
+			},
+			after: map[string]file{
+				"scripts/Makefile.package":            {content: packageMakefile("install-modules.sh")},
+				"scripts/package/gokrazy-firmware.sh": {content: firmwareScript, mode: 0755},
+				"scripts/package/install-modules.sh": {
+					content: strings.Replace(modulesScript,
+						"find \"$MODULES_DIR\" -name build -type l -delete\nfind \"$MODULES_DIR\" -name source -type l -delete\n",
+						"find \"$MODULES_DIR\" \\( -name build -o -name source \\) -type l -delete\n", 1),
+					mode: 0755,
+				},
+			},
+			results: []string{
+				"scripts/Makefile.package: hunk #1 succeeded at 2",
+				"scripts/package/install-modules.sh: hunk #1 succeeded at 6",
+			},
+		},
+		{
+			patch: "delete.patch",
+			strip: 1,
+			before: map[string]file{
+				"obsolete/file.txt": {content: "first\nsecond\n"},
+				"README":            {content: "kept\n"},
+			},
+			after:   map[string]file{"README": {content: "kept\n"}},
+			results: []string{"obsolete/file.txt: hunk #1 succeeded at 1"},
+		},
+		{
+			patch:   "offset.patch",
+			strip:   1,
+			before:  map[string]file{"Makefile": {content: makefile}},
+			after:   map[string]file{"Makefile": {content: strings.Replace(makefile, "7", "8", 1)}},
+			results: []string{"Makefile: hunk #1 succeeded at 4 (offset 3 lines)"},
+		},
+		{
+			patch:   "fuzz.patch",
+			strip:   1,
+			before:  map[string]file{"letters": {content: "x\nb\nc\nd\ne\nf\ng\n"}},
+			after:   map[string]file{"letters": {content: "x\nb\nc\nD\ne\nf\ng\n"}},
+			results: []string{"letters: hunk #1 succeeded at 1 with fuzz 1"},
+		},
+		{
+			patch: "nonewline.patch",
+			strip: 1,
+			before: map[string]file{
+				"motd":  {content: "hello\nworld"},
+				"issue": {content: "Welcome"},
+			},
+			after: map[string]file{
+				"motd":  {content: "hello\ngokrazy"},
+				"issue": {content: "Welcome\n"},
+			},
+			results: []string{
+				"motd: hunk #1 succeeded at 1",
+				"issue: hunk #1 succeeded at 1",
+			},
+		},
+		{
+			patch:   "fails.patch",
+			strip:   1,
+			before:  map[string]file{"Makefile": {content: makefile}},
+			after:   map[string]file{"Makefile": {content: makefile}},
+			results: []string{"Makefile: hunk #1 FAILED at 1"},
+			wantErr: "Makefile: hunk #1 FAILED at 1",
+		},
+		{
+			patch:   "traversal.patch",
+			strip:   1,
+			before:  map[string]file{},
+			after:   map[string]file{},
+			results: []string{"../../evil: hunk #1 succeeded at 1"},
+			wantErr: "path outside of the tree",
+		},
+		{
+			patch:   "absolute.patch",
+			strip:   1,
+			before:  map[string]file{"README": {content: "readme\n"}},
+			after:   map[string]file{"README": {content: "readme\n"}},
+			wantErr: "path outside of the tree",
+		},
+	} {
+		t.Run(tt.patch, func(t *testing.T) {
+			files, err := ParseFile(filepath.Join("testdata", tt.patch), tt.strip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The tree is a subdirectory, so that escaping it would
+			// be noticed.
+			root := t.TempDir()
+			dir := filepath.Join(root, "linux", "src")
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			writeTree(t, dir, tt.before)
+			results, err := Apply(DirTree(dir), files, Options{MaxFuzz: 2})
+			if tt.wantErr != "" {
+				var applyErr *ApplyError
+				if !errors.As(err, &applyErr) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Apply() = %v, want *ApplyError containing %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, r := range results {
+				got = append(got, r.String())
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.results, "\n") {
+				t.Errorf("Apply() results:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.results, "\n"))
+			}
+			compareTree(t, readTree(t, dir), tt.after)
+			if outside := readTree(t, root); len(outside) != len(tt.after) {
+				t.Errorf("Apply() wrote outside of the tree: %v", outside)
+			}
+		})
+	}
+}
+
+// TestLogo parses the gokrazy logo patch, which creates a file, and applies it
+// to a tree containing the lines its hunks expect.
+func TestLogo(t *testing.T) {
+	files, err := ParseFile(filepath.Join("..", "..", "patches", "0001-gokrazy-logo.patch"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const ppm = "drivers/video/logo/logo_gokrazy_clut224.ppm"
+	before := make(map[string]file)
+	after := make(map[string]file)
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+		if f.IsNew() {
+			if f.NewMode != 0644 || len(f.Hunks) != 1 {
+				t.Fatalf("%s: mode %o, %d hunks, want mode 644 and 1 hunk", f.Name(), f.NewMode, len(f.Hunks))
+			}
+			after[f.NewName] = file{content: strings.Join(f.Hunks[0].New(), "")}
+			continue
+		}
+		// Place the old lines of each hunk at the line it expects.
+		var old, new []string
+		for _, h := range f.Hunks {
+			for len(old) < h.OldStart-1 {
+				filler := "filler\n"
+				old = append(old, filler)
+				new = append(new, filler)
+			}
+			old = append(old, h.Old()...)
+			new = append(new, h.New()...)
+		}
+		before[f.OldName] = file{content: strings.Join(old, "")}
+		after[f.NewName] = file{content: strings.Join(new, "")}
+	}
+	want := []string{
+		"drivers/video/logo/Kconfig",
+		"drivers/video/logo/Makefile",
+		"drivers/video/logo/logo.c",
+		ppm,
+		"include/linux/linux_logo.h",
+	}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Fatalf("Parse() files = %v, want %v", names, want)
+	}
+	if got := after[ppm].content; !strings.HasPrefix(got, "P3\n") || strings.Count(got, "\n") != 883 {
+		t.Errorf("%s: %d lines, want a PPM of 883 lines", ppm, strings.Count(got, "\n"))
+	}
+
+	dir := t.TempDir()
+	writeTree(t, dir, before)
+	if _, err := Apply(DirTree(dir), files, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	compareTree(t, readTree(t, dir), after)
+
+	// Applying the patch again fails, as the logo already exists.
+	if _, err := Apply(&Overlay{Base: DirTree(dir)}, files, Options{}); err == nil ||
+		!strings.Contains(err.Error(), ppm+": file to be created already exists") {
+		t.Errorf("Apply() twice = %v, want an error about %s", err, ppm)
+	}
+}
+
+// TestDiffRoundTrip checks that the output of Diff parses and applies.
+func TestDiffRoundTrip(t *testing.T) {
+	var long []string
+	for i := 0; i < 40; i++ {
+		long = append(long, strings.Repeat("x", i)+"\n")
+	}
+	changed := append([]string{}, long...)
+	changed[2] = "changed near the start\n"
+	changed[35] = "changed near the end\n"
+
+	for _, tt := range []struct {
+		name     string
+		old, new string
+	}{
+		{"modify", "a\nb\nc\n", "a\nB\nc\n"},
+		{"append", "a\nb\n", "a\nb\nc\nd\n"},
+		{"prepend", "c\nd\n", "a\nb\nc\nd\n"},
+		{"from empty", "", "a\n"},
+		{"to empty", "a\nb\n", ""},
+		{"add newline at end", "a\nb", "a\nb\n"},
+		{"remove newline at end", "a\nb\n", "a\nb"},
+		{"no newline at end", "a\nb", "A\nb"},
+		{"two hunks", strings.Join(long, ""), strings.Join(changed, "")},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := Diff("a/url.go", "b/url.go", []byte(tt.old), []byte(tt.new))
+			if diff == "" {
+				t.Fatalf("Diff() is empty")
+			}
+			files, err := Parse(strings.NewReader(diff), 1)
+			if err != nil {
+				t.Fatalf("Parse(%q) = %v", diff, err)
+			}
+			dir := t.TempDir()
+			writeTree(t, dir, map[string]file{"url.go": {content: tt.old}})
+			if _, err := Apply(DirTree(dir), files, Options{}); err != nil {
+				t.Fatalf("Apply(%q) = %v", diff, err)
+			}
+			compareTree(t, readTree(t, dir), map[string]file{"url.go": {content: tt.new}})
+		})
+	}
+
+	if diff := Diff("a/url.go", "b/url.go", []byte("same\n"), []byte("same\n")); diff != "" {
+		t.Errorf("Diff() of equal content = %q, want \"\"", diff)
+	}
+}
@@ -0,0 +1,104 @@
+package patch
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/kversion"
+)
+
+// SeriesEntry is a line of a quilt-style series file:
+//
+//	<patch> [-p<N>] [<version constraint>…]
+//
+// e.g. "0001-gokrazy-logo.patch -p1 >=6.6 <6.9". The patch is only applied
+// if the kernel version satisfies all constraints.
+type SeriesEntry struct {
+	// Patch is the file name as listed in the series file, which is
+	// relative to and below the directory of the series. Path is the patch
+	// file name joined with that directory.
+	Patch       string
+	Path        string
+	Strip       int
+	Constraints []kversion.Constraint
+	// Line is the position in the series file, for error messages.
+	Line string
+}
+
+// Applies reports whether the patch is to be applied to kernel version v.
+func (e SeriesEntry) Applies(v kversion.Version) bool {
+	for _, c := range e.Constraints {
+		if !c.Match(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadSeries parses the series file in dir. Entries which are absolute or
+// lead out of dir (via "..") are rejected. Without a series file, all
+// *.patch files of dir are applied in lexical order with -p1.
+func ReadSeries(dir string) ([]SeriesEntry, error) {
+	f, err := os.Open(filepath.Join(dir, "series"))
+	if os.IsNotExist(err) {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.patch"))
+		if err != nil {
+			return nil, err
+		}
+		var entries []SeriesEntry
+		for _, path := range paths {
+			name := filepath.Base(path)
+			entries = append(entries, SeriesEntry{Patch: name, Path: path, Strip: 1, Line: name})
+		}
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []SeriesEntry
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := scanner.Text()
+		if idx := strings.IndexByte(line, '#'); idx > -1 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		e := SeriesEntry{
+			Patch: fields[0],
+			Path:  filepath.Join(dir, filepath.FromSlash(fields[0])),
+			Strip: 1,
+			Line:  fmt.Sprintf("%s:%d", f.Name(), lineno),
+		}
+		if !filepath.IsLocal(filepath.FromSlash(e.Patch)) {
+			return nil, fmt.Errorf("%s: patch %q is outside of %s", e.Line, e.Patch, dir)
+		}
+		for _, field := range fields[1:] {
+			if strings.HasPrefix(field, "-p") {
+				n, err := strconv.Atoi(strings.TrimPrefix(field, "-p"))
+				if err != nil {
+					return nil, fmt.Errorf("%s: invalid strip level %q", e.Line, field)
+				}
+				e.Strip = n
+				continue
+			}
+			c, err := kversion.ParseConstraint(field)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", e.Line, err)
+			}
+			e.Constraints = append(e.Constraints, c)
+		}
+		if _, err := os.Stat(e.Path); err != nil {
+			return nil, fmt.Errorf("%s: patch listed in series is missing: %v", e.Line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
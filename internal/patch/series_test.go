@@ -0,0 +1,78 @@
+package patch
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadSeries(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		series  string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:   "subdirectory",
+			series: "# comment\n0001-first.patch\nfixes/0002-second.patch -p0 >=6.6\n",
+			want:   []string{"0001-first.patch", "fixes/0002-second.patch"},
+		},
+		{
+			name:   "cleaned",
+			series: "fixes/../0001-first.patch\n",
+			want:   []string{"fixes/../0001-first.patch"},
+		},
+		{
+			name:    "parent directory",
+			series:  "../outside.patch\n",
+			wantErr: `patch "../outside.patch" is outside of`,
+		},
+		{
+			name:    "nested parent directory",
+			series:  "fixes/../../outside.patch\n",
+			wantErr: `patch "fixes/../../outside.patch" is outside of`,
+		},
+		{
+			name:    "absolute",
+			series:  "/etc/passwd\n",
+			wantErr: `patch "/etc/passwd" is outside of`,
+		},
+		{
+			name:    "missing",
+			series:  "0003-missing.patch\n",
+			wantErr: "patch listed in series is missing",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			dir := filepath.Join(root, "patches")
+			writeTree(t, root, map[string]file{
+				"outside.patch":                   {content: "\n"},
+				"patches/0001-first.patch":        {content: "\n"},
+				"patches/fixes/0002-second.patch": {content: "\n"},
+				"patches/series":                  {content: tt.series},
+			})
+			entries, err := ReadSeries(dir)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ReadSeries() = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range entries {
+				got = append(got, e.Patch)
+				if want := filepath.Join(dir, filepath.FromSlash(e.Patch)); e.Path != want {
+					t.Errorf("%s: Path = %s, want %s", e.Patch, e.Path, want)
+				}
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("ReadSeries() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
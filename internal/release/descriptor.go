@@ -0,0 +1,55 @@
+package release
+
+import (
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/kversion"
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/source"
+)
+
+// Descriptor describes the kernel release which amd64-build-kernel builds. It
+// is generated into its url.go by WriteURLFile.
+type Descriptor struct {
+	// Version is the kernel version, e.g. 6.8.2.
+	Version string `json:"version"`
+	// Moniker is the release channel, e.g. stable or longterm.
+	Moniker string `json:"moniker"`
+	// SourceURL is the URL of the source tarball.
+	SourceURL string `json:"source_url"`
+	// SHA256 is the checksum of the source tarball, taken from the signed
+	// kernel.org checksums.
+	SHA256 string `json:"sha256"`
+	// Released is the release date (seconds since the epoch), used as the
+	// build timestamp.
+	Released int64 `json:"released"`
+	// IncrementalURL is the URL of the patch from the previous release of
+	// the same series, if any.
+	IncrementalURL string `json:"incremental_url"`
+}
+
+// ParsedVersion returns the parsed Version.
+func (d Descriptor) ParsedVersion() (kversion.Version, error) {
+	return kversion.Parse(d.Version)
+}
+
+// NewDescriptor returns the descriptor of r, including the checksum of its
+// source tarball, which is verified using the kernel.org signing keys (see
+// source.Keyring for keyringPath).
+func NewDescriptor(r *Release, keyringPath string) (Descriptor, error) {
+	d := Descriptor{
+		Version:        r.Version,
+		Moniker:        r.Moniker,
+		SourceURL:      r.Source,
+		Released:       int64(r.Released.Timestamp),
+		IncrementalURL: r.Patch.Incremental,
+	}
+	v, err := d.ParsedVersion()
+	if err != nil {
+		return Descriptor{}, err
+	}
+	if v.RC != 0 {
+		return Descriptor{}, errReleaseCandidate(r.Version)
+	}
+	if d.SHA256, err = source.Checksum(r.Source, keyringPath); err != nil {
+		return Descriptor{}, err
+	}
+	return d, nil
+}
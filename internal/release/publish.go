@@ -0,0 +1,403 @@
+package release
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnchanged is returned by CommitUpdate and PushBuild if the files to
+// commit do not differ from the committed version.
+var ErrUnchanged = errors.New("nothing to commit")
+
+// Publisher commits kernel updates and builds to a git repository and pushes
+// them. All changes are made in a temporary worktree (see Open) checked out
+// from Branch, so that the working copy of the user is never modified:
+// neither its checked out branch nor any local changes.
+type Publisher struct {
+	// Dir is the working copy of the repository. Empty means the current
+	// directory.
+	Dir string
+	PublishConfig
+
+	// DryRun makes the publisher append the git commands which would modify
+	// the repository or the remote to Commands instead of running them.
+	DryRun   bool
+	Commands [][]string
+
+	// worktree is the root of the temporary worktree, if opened, and wd the
+	// directory within it which corresponds to Dir.
+	worktree string
+	wd       string
+	// update is the commit made by CommitUpdate which was not pushed yet,
+	// if any.
+	update string
+}
+
+// git runs a git command in dir and returns its output.
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%v: %v\n%s", cmd.Args, err, out)
+	}
+	return string(out), nil
+}
+
+// run runs a git command in the worktree, which modifies the repository or
+// the remote.
+func (p *Publisher) run(args ...string) error {
+	if p.DryRun {
+		p.Commands = append(p.Commands, append([]string{"git"}, args...))
+		return nil
+	}
+	if p.worktree == "" {
+		return fmt.Errorf("BUG: git %s called before Open", strings.Join(args, " "))
+	}
+	_, err := git(p.wd, args...)
+	return err
+}
+
+// CheckClean returns an error if the working copy has uncommitted changes
+// to tracked files: the build uses the committed state (e.g. of the
+// patches), which would silently differ from what the user sees.
+func (p *Publisher) CheckClean() error {
+	out, err := git(p.Dir, "status", "--porcelain", "--untracked-files=no")
+	if err != nil {
+		return err
+	}
+	if out = strings.TrimSpace(out); out != "" {
+		return fmt.Errorf("refusing to publish: the working copy has uncommitted changes, commit or stash them first:\n%s", out)
+	}
+	return nil
+}
+
+// branchRev returns the local branch or, if there is none (e.g. in a fresh
+// clone, which only has the default branch), the remote-tracking branch of
+// Remote.
+func (p *Publisher) branchRev(branch string) string {
+	if _, err := git(p.Dir, "rev-parse", "--verify", "--quiet", "refs/heads/"+branch); err != nil {
+		return p.Remote + "/" + branch
+	}
+	return branch
+}
+
+// Open creates a temporary worktree with a detached checkout of Branch (see
+// branchRev) and returns the directory within it which corresponds to Dir.
+// Paths passed to Changed, CommitUpdate and PushBuild are relative to that
+// directory. Call Close to remove the worktree.
+func (p *Publisher) Open() (string, error) {
+	rev := p.branchRev(p.Branch)
+	if p.DryRun {
+		p.worktree, p.wd = "<worktree>", "<worktree>"
+		p.Commands = append(p.Commands, []string{"git", "worktree", "add", "--detach", p.worktree, rev})
+		return p.wd, nil
+	}
+	prefix, err := git(p.Dir, "rev-parse", "--show-prefix")
+	if err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp("", "amd64-kernel-publish")
+	if err != nil {
+		return "", err
+	}
+	if _, err := git(p.Dir, "worktree", "add", "--detach", dir, rev); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	p.worktree = dir
+	p.wd = filepath.Join(dir, strings.TrimSpace(prefix))
+	return p.wd, nil
+}
+
+// Close removes the worktree created by Open, discarding an update which was
+// not pushed.
+func (p *Publisher) Close() error {
+	if p.worktree == "" {
+		return nil
+	}
+	if p.update != "" && !p.DryRun {
+		log.Printf("not pushing the update to %s (commit %s)", p.Branch, p.update)
+	}
+	dir := p.worktree
+	p.worktree, p.wd, p.update = "", "", ""
+	if p.DryRun {
+		p.Commands = append(p.Commands, []string{"git", "worktree", "remove", "--force", dir})
+		return nil
+	}
+	if _, err := git(p.Dir, "worktree", "remove", "--force", dir); err != nil {
+		os.RemoveAll(dir)
+		git(p.Dir, "worktree", "prune")
+		return err
+	}
+	return nil
+}
+
+// ReadFile returns the content of path (relative to Dir) as committed on
+// Branch (see branchRev), without opening a worktree.
+func (p *Publisher) ReadFile(path string) ([]byte, error) {
+	return p.show(p.branchRev(p.Branch), path)
+}
+
+// ReadBuildFile returns the content of path (relative to Dir) as committed
+// on the build branch of version (see branchRev).
+func (p *Publisher) ReadBuildFile(version, path string) ([]byte, error) {
+	branch, err := p.BuildBranchName(version)
+	if err != nil {
+		return nil, err
+	}
+	return p.show(p.branchRev(branch), path)
+}
+
+// ReadTree writes the files below path (relative to Dir) as committed on
+// Branch (see branchRev) into dir, without opening a worktree.
+func (p *Publisher) ReadTree(path, dir string) error {
+	cmd := exec.Command("git", "archive", "--format=tar", p.branchRev(p.Branch)+":./"+filepath.ToSlash(path))
+	cmd.Dir = p.Dir
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if err := extract(tar.NewReader(stdout), dir); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%v: %v\n%s", cmd.Args, err, stderr.String())
+	}
+	return nil
+}
+
+// extract writes the directories and regular files of tr into dir.
+func extract(tr *tar.Reader, dir string) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.FromSlash(hdr.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("refusing to extract %q: path outside of %s", hdr.Name, dir)
+		}
+		dest := filepath.Join(dir, name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dest, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, hdr.FileInfo().Mode().Perm())
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// show returns the content of path (relative to Dir) in rev.
+func (p *Publisher) show(rev, path string) ([]byte, error) {
+	cmd := exec.Command("git", "show", rev+":./"+filepath.ToSlash(path))
+	cmd.Dir = p.Dir
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v\n%s", cmd.Args, err, stderr.String())
+	}
+	return out, nil
+}
+
+// Changed reports whether path differs from the committed version.
+func (p *Publisher) Changed(path string) (bool, error) {
+	if p.DryRun {
+		return true, nil
+	}
+	out, err := git(p.wd, "status", "--porcelain", "--", path)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(out) != "", nil
+}
+
+// commit stages paths (including deletions) and commits them.
+func (p *Publisher) commit(msg string, paths []string) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("BUG: nothing to commit")
+	}
+	if err := p.run(append([]string{"add", "--all", "--"}, paths...)...); err != nil {
+		return err
+	}
+	if !p.DryRun {
+		cmd := exec.Command("git", "diff", "--cached", "--quiet")
+		cmd.Dir = p.wd
+		if err := cmd.Run(); err == nil {
+			return ErrUnchanged
+		} else if _, ok := err.(*exec.ExitError); !ok {
+			return err
+		}
+	}
+	return p.run(p.commitArgs(msg)...)
+}
+
+// commitArgs returns the git arguments committing with msg, using the
+// identity and signing settings of the config.
+func (p *Publisher) commitArgs(msg string) []string {
+	var args []string
+	if p.CommitterName != "" {
+		args = append(args, "-c", "user.name="+p.CommitterName)
+	}
+	if p.CommitterEmail != "" {
+		args = append(args, "-c", "user.email="+p.CommitterEmail)
+	}
+	if p.Sign != "" {
+		args = append(args, "-c", "gpg.format="+map[string]string{"gpg": "openpgp", "ssh": "ssh"}[p.Sign])
+		if p.SigningKey != "" {
+			args = append(args, "-c", "user.signingkey="+p.SigningKey)
+		}
+	}
+	args = append(args, "commit")
+	if p.AuthorName != "" {
+		args = append(args, fmt.Sprintf("--author=%s <%s>", p.AuthorName, p.AuthorEmail))
+	}
+	if p.Sign != "" {
+		args = append(args, "--gpg-sign")
+	}
+	return append(args, "-m", msg)
+}
+
+// CommitUpdate commits paths (e.g. the url.go written by WriteURLFile) in the
+// worktree. The commit is pushed to Branch by PushUpdate or, once the build
+// succeeded, together with the build by PushBuild.
+func (p *Publisher) CommitUpdate(version string, paths ...string) error {
+	msg, err := expand("update_message", p.UpdateMessage, version)
+	if err != nil {
+		return err
+	}
+	if err := p.commit(msg, paths); err != nil {
+		return err
+	}
+	if p.DryRun {
+		p.update = "<update>"
+		return nil
+	}
+	rev, err := git(p.wd, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	p.update = strings.TrimSpace(rev)
+	return nil
+}
+
+// PushUpdate pushes the commit of CommitUpdate, if any, to Branch.
+func (p *Publisher) PushUpdate() error {
+	return p.push()
+}
+
+// UpdateURLFile writes the url.go file for d (see URLFile) to path (relative
+// to the directory returned by Open) and commits it with CommitUpdate. If
+// push is set, the update is pushed right away; otherwise it is left for
+// PushBuild. It returns ErrUnchanged if url.go already selects d.
+func (p *Publisher) UpdateURLFile(path string, d Descriptor, push bool) error {
+	if p.worktree == "" {
+		return fmt.Errorf("BUG: UpdateURLFile called before Open")
+	}
+	if err := WriteURLFile(filepath.Join(p.wd, path), d); err != nil {
+		return err
+	}
+	if err := p.CommitUpdate(d.Version, path); err != nil {
+		return err
+	}
+	if !push {
+		return nil
+	}
+	return p.PushUpdate()
+}
+
+// push pushes the commit of CommitUpdate, if any, to Branch and refspecs to
+// Remote. The push is atomic, so that the build branch is never pushed
+// without the update it was built from, or vice versa.
+func (p *Publisher) push(refspecs ...string) error {
+	update := p.update
+	if update != "" {
+		refspecs = append([]string{update + ":refs/heads/" + p.Branch}, refspecs...)
+	}
+	if len(refspecs) == 0 {
+		return nil
+	}
+	if err := p.run(append([]string{"push", "--atomic", p.Remote}, refspecs...)...); err != nil {
+		return err
+	}
+	if update == "" {
+		return nil
+	}
+	p.update = ""
+	return p.updateBranch(update)
+}
+
+// updateBranch moves the local Branch to the pushed update, so that the next
+// Open starts from it. A Branch checked out in the working copy is left
+// alone.
+func (p *Publisher) updateBranch(update string) error {
+	if !p.DryRun {
+		current, err := git(p.Dir, "symbolic-ref", "--quiet", "--short", "HEAD")
+		if err == nil && strings.TrimSpace(current) == p.Branch {
+			log.Printf("%s is checked out, run git pull --ff-only to update it", p.Branch)
+			return nil
+		}
+	}
+	return p.run("branch", "--force", p.Branch, update)
+}
+
+// PushBuild commits the build artifacts paths on top of the update and
+// pushes them to the build branch of version, together with the update (see
+// CommitUpdate). notes, if not empty, is appended to the commit message (e.g.
+// the config diff to the previous version). If the artifacts are unchanged,
+// only the update is pushed and ErrUnchanged is returned.
+func (p *Publisher) PushBuild(version, notes string, paths ...string) error {
+	msg, err := expand("build_message", p.BuildMessage, version)
+	if err != nil {
+		return err
+	}
+	if notes != "" {
+		msg = strings.TrimRight(msg, "\n") + "\n\n" + notes
+	}
+	branch, err := p.BuildBranchName(version)
+	if err != nil {
+		return err
+	}
+	if err := p.commit(msg, paths); err == ErrUnchanged {
+		if err := p.push(); err != nil {
+			return err
+		}
+		return ErrUnchanged
+	} else if err != nil {
+		return err
+	}
+	return p.push("HEAD:refs/heads/" + branch)
+}
@@ -0,0 +1,320 @@
+package release
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mustGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := git(dir, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(out)
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newRepo returns a working copy with main checked out, whose development
+// branch (containing cmd/amd64-build-kernel/url.go) was pushed to the bare
+// repository remote.
+func newRepo(t *testing.T) (work, remote string) {
+	t.Helper()
+	// Isolate the tests from the git configuration of the user.
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_AUTHOR_NAME", "Test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.org")
+	t.Setenv("GIT_COMMITTER_NAME", "Test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.org")
+
+	dir := t.TempDir()
+	remote = filepath.Join(dir, "remote.git")
+	work = filepath.Join(dir, "work")
+	mustGit(t, dir, "init", "--quiet", "--bare", remote)
+	mustGit(t, dir, "init", "--quiet", "-b", "main", work)
+	writeFile(t, filepath.Join(work, "README.md"), "kernel\n")
+	writeFile(t, filepath.Join(work, "cmd/amd64-build-kernel/url.go"), "package main // 6.8.1\n")
+	mustGit(t, work, "add", "README.md", "cmd")
+	mustGit(t, work, "commit", "--quiet", "-m", "Upgrade to version 6.8.1")
+	mustGit(t, work, "branch", "development")
+	mustGit(t, work, "remote", "add", "origin", remote)
+	mustGit(t, work, "push", "--quiet", "origin", "development")
+	return work, remote
+}
+
+func TestPublisher(t *testing.T) {
+	work, remote := newRepo(t)
+	config := DefaultPublishConfig
+	config.AuthorName = "gokrazy bot"
+	config.AuthorEmail = "bot@example.org"
+	p := &Publisher{
+		Dir:           filepath.Join(work, "cmd", "amd64-build-kernel"),
+		PublishConfig: config,
+	}
+	if err := p.CheckClean(); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := p.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := filepath.Base(wd), "amd64-build-kernel"; got != want {
+		t.Errorf("Open() = %s, want a directory named %s", wd, want)
+	}
+	writeFile(t, filepath.Join(wd, "url.go"), "package main // 6.8.2\n")
+	if changed, err := p.Changed("url.go"); err != nil || !changed {
+		t.Errorf("Changed(url.go) = %v, %v, want true", changed, err)
+	}
+	if err := p.CommitUpdate("6.8.2", "url.go"); err != nil {
+		t.Fatal(err)
+	}
+	// The update is pushed together with the build.
+	if got, want := mustGit(t, remote, "log", "-1", "--format=%s", "development"), "Upgrade to version 6.8.1"; got != want {
+		t.Errorf("remote development before PushBuild: %q, want %q", got, want)
+	}
+	writeFile(t, filepath.Join(wd, "vmlinuz"), "kernel image")
+	if err := p.PushBuild("6.8.2", "CONFIG_EXAMPLE: n -> y", "vmlinuz"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(wd); !os.IsNotExist(err) {
+		t.Errorf("worktree %s not removed: %v", wd, err)
+	}
+
+	if got, want := mustGit(t, remote, "log", "-1", "--format=%s", "development"), "Upgrade to version 6.8.2"; got != want {
+		t.Errorf("remote development: %q, want %q", got, want)
+	}
+	if got, want := mustGit(t, remote, "log", "-1", "--format=%an <%ae>", "development"), "gokrazy bot <bot@example.org>"; got != want {
+		t.Errorf("remote development author: %q, want %q", got, want)
+	}
+	if got, want := mustGit(t, remote, "log", "-1", "--format=%B", "build-6.8.2"), "Built to version 6.8.2\n\nCONFIG_EXAMPLE: n -> y"; got != want {
+		t.Errorf("remote build-6.8.2 message: %q, want %q", got, want)
+	}
+	if got, want := mustGit(t, remote, "rev-parse", "build-6.8.2^"), mustGit(t, remote, "rev-parse", "development"); got != want {
+		t.Errorf("build-6.8.2 is not based on the update: parent %s, want %s", got, want)
+	}
+	// The local branch follows the pushed update, the working copy is
+	// unchanged.
+	if got, want := mustGit(t, work, "rev-parse", "development"), mustGit(t, remote, "rev-parse", "development"); got != want {
+		t.Errorf("local development = %s, want %s", got, want)
+	}
+	if got := mustGit(t, work, "symbolic-ref", "--short", "HEAD"); got != "main" {
+		t.Errorf("working copy switched to %s", got)
+	}
+	if b, err := p.ReadFile("url.go"); err != nil || string(b) != "package main // 6.8.2\n" {
+		t.Errorf("ReadFile(url.go) = %q, %v", b, err)
+	}
+	if b, err := p.ReadBuildFile("6.8.2", "vmlinuz"); err != nil || string(b) != "kernel image" {
+		t.Errorf("ReadBuildFile(6.8.2, vmlinuz) = %q, %v", b, err)
+	}
+
+	// Committing the same url.go again is reported.
+	if _, err := p.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.CommitUpdate("6.8.2", "url.go"); !errors.Is(err, ErrUnchanged) {
+		t.Errorf("CommitUpdate() without changes = %v, want %v", err, ErrUnchanged)
+	}
+}
+
+func TestPublisherPushUpdate(t *testing.T) {
+	for _, push := range []bool{true, false} {
+		t.Run(fmt.Sprintf("push=%v", push), func(t *testing.T) {
+			work, remote := newRepo(t)
+			before := mustGit(t, remote, "rev-parse", "development")
+			p := &Publisher{Dir: work, PublishConfig: DefaultPublishConfig}
+			wd, err := p.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			writeFile(t, filepath.Join(wd, "cmd/amd64-build-kernel/url.go"), "package main // 6.8.2\n")
+			if err := p.CommitUpdate("6.8.2", "cmd/amd64-build-kernel/url.go"); err != nil {
+				t.Fatal(err)
+			}
+			if push {
+				if err := p.PushUpdate(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// e.g. a failed build
+			if err := p.Close(); err != nil {
+				t.Fatal(err)
+			}
+			remoteRev := mustGit(t, remote, "rev-parse", "development")
+			if pushed := remoteRev != before; pushed != push {
+				t.Errorf("update pushed: %v, want %v", pushed, push)
+			}
+			if got := mustGit(t, work, "rev-parse", "development"); got != remoteRev {
+				t.Errorf("local development = %s, want %s", got, remoteRev)
+			}
+		})
+	}
+}
+
+func TestPublisherUpdateURLFile(t *testing.T) {
+	d := Descriptor{
+		Version:   "6.8.2",
+		Moniker:   "stable",
+		SourceURL: "https://cdn.kernel.org/pub/linux/kernel/v6.x/linux-6.8.2.tar.xz",
+		Released:  1711624393,
+	}
+	want, err := URLFile(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, push := range []bool{true, false} {
+		t.Run(fmt.Sprintf("push=%v", push), func(t *testing.T) {
+			work, remote := newRepo(t)
+			before := mustGit(t, remote, "rev-parse", "development")
+			p := &Publisher{Dir: work, PublishConfig: DefaultPublishConfig}
+			if err := p.UpdateURLFile("cmd/amd64-build-kernel/url.go", d, push); err == nil {
+				t.Errorf("UpdateURLFile() before Open succeeded")
+			}
+			if _, err := p.Open(); err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			if err := p.UpdateURLFile("cmd/amd64-build-kernel/url.go", d, push); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.UpdateURLFile("cmd/amd64-build-kernel/url.go", d, push); !errors.Is(err, ErrUnchanged) {
+				t.Errorf("UpdateURLFile() again = %v, want %v", err, ErrUnchanged)
+			}
+			if err := p.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if pushed := mustGit(t, remote, "rev-parse", "development") != before; pushed != push {
+				t.Errorf("update pushed: %v, want %v", pushed, push)
+			}
+			if !push {
+				return
+			}
+			if got := mustGit(t, remote, "show", "development:cmd/amd64-build-kernel/url.go"); got != strings.TrimSpace(string(want)) {
+				t.Errorf("pushed url.go:\n%s\nwant:\n%s", got, want)
+			}
+			if got := mustGit(t, remote, "log", "-1", "--format=%s", "development"); got != "Upgrade to version 6.8.2" {
+				t.Errorf("commit message = %q", got)
+			}
+		})
+	}
+}
+
+// TestPublisherRemoteBranch covers a fresh clone, which has no local
+// development branch.
+func TestPublisherRemoteBranch(t *testing.T) {
+	work, remote := newRepo(t)
+	mustGit(t, work, "fetch", "--quiet", "origin")
+	mustGit(t, work, "branch", "--quiet", "--delete", "--force", "development")
+	p := &Publisher{Dir: work, PublishConfig: DefaultPublishConfig}
+	if b, err := p.ReadFile("cmd/amd64-build-kernel/url.go"); err != nil || string(b) != "package main // 6.8.1\n" {
+		t.Errorf("ReadFile(url.go) = %q, %v", b, err)
+	}
+	wd, err := p.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	writeFile(t, filepath.Join(wd, "cmd/amd64-build-kernel/url.go"), "package main // 6.8.2\n")
+	if err := p.CommitUpdate("6.8.2", "cmd/amd64-build-kernel/url.go"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PushUpdate(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := mustGit(t, work, "rev-parse", "development"), mustGit(t, remote, "rev-parse", "development"); got != want {
+		t.Errorf("local development = %s, want %s", got, want)
+	}
+}
+
+func TestPublisherReadTree(t *testing.T) {
+	work, _ := newRepo(t)
+	// development has a series, the working copy on main does not.
+	mustGit(t, work, "checkout", "--quiet", "development")
+	writeFile(t, filepath.Join(work, "patches/series"), "fixes/0001-fix.patch\n")
+	writeFile(t, filepath.Join(work, "patches/fixes/0001-fix.patch"), "--- a/x\n")
+	mustGit(t, work, "add", "patches")
+	mustGit(t, work, "commit", "--quiet", "-m", "Add a patch")
+	mustGit(t, work, "checkout", "--quiet", "main")
+	writeFile(t, filepath.Join(work, "patches/0002-local.patch"), "untracked\n")
+
+	p := &Publisher{Dir: work, PublishConfig: DefaultPublishConfig, DryRun: true}
+	dir := filepath.Join(t.TempDir(), "patches")
+	if err := p.ReadTree("patches", dir); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if want := []string{"fixes/0001-fix.patch", "series"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadTree(patches) = %v, want %v", got, want)
+	}
+	if len(p.Commands) > 0 {
+		t.Errorf("ReadTree() ran %q", p.Commands)
+	}
+	if err := p.ReadTree("missing", t.TempDir()); err == nil {
+		t.Errorf("ReadTree(missing) succeeded")
+	}
+}
+
+func TestPublisherCheckClean(t *testing.T) {
+	work, _ := newRepo(t)
+	writeFile(t, filepath.Join(work, "README.md"), "modified\n")
+	p := &Publisher{Dir: work, PublishConfig: DefaultPublishConfig}
+	if err := p.CheckClean(); err == nil || !strings.Contains(err.Error(), "README.md") {
+		t.Errorf("CheckClean() = %v, want an error listing README.md", err)
+	}
+}
+
+func TestPublisherDryRun(t *testing.T) {
+	work, _ := newRepo(t)
+	p := &Publisher{Dir: work, PublishConfig: DefaultPublishConfig, DryRun: true}
+	if _, err := p.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CommitUpdate("6.8.2", "url.go"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PushBuild("6.8.2", "", "vmlinuz"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"git", "worktree", "add", "--detach", "<worktree>", "development"},
+		{"git", "add", "--all", "--", "url.go"},
+		{"git", "commit", "-m", "Upgrade to version 6.8.2"},
+		{"git", "add", "--all", "--", "vmlinuz"},
+		{"git", "commit", "-m", "Built to version 6.8.2"},
+		{"git", "push", "--atomic", "origin", "<update>:refs/heads/development", "HEAD:refs/heads/build-6.8.2"},
+		{"git", "branch", "--force", "development", "<update>"},
+		{"git", "worktree", "remove", "--force", "<worktree>"},
+	}
+	if !reflect.DeepEqual(p.Commands, want) {
+		t.Errorf("Commands:\n%q\nwant:\n%q", p.Commands, want)
+	}
+}
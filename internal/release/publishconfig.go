@@ -0,0 +1,170 @@
+package release
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// PublishConfig configures where and how Publisher commits and pushes. The
+// branch names and commit messages are text/template templates, executed
+// with a struct providing .Version (e.g. 6.8.2).
+type PublishConfig struct {
+	// Remote is the remote to push to.
+	Remote string `json:"remote"`
+	// Branch is the base branch to which updates of url.go are committed.
+	Branch string `json:"branch"`
+	// BuildBranch is the branch (a template) to which builds are pushed.
+	BuildBranch string `json:"build_branch"`
+
+	UpdateMessage string `json:"update_message"`
+	BuildMessage  string `json:"build_message"`
+
+	// AuthorName and AuthorEmail override the author of the commits,
+	// CommitterName and CommitterEmail their committer. Empty means the git
+	// configuration applies.
+	AuthorName     string `json:"author_name"`
+	AuthorEmail    string `json:"author_email"`
+	CommitterName  string `json:"committer_name"`
+	CommitterEmail string `json:"committer_email"`
+
+	// Sign is empty (use the git configuration), "gpg" or "ssh".
+	Sign string `json:"sign"`
+	// SigningKey is the key to sign with: a GPG key ID, or for ssh the path
+	// to a key (or a literal public key). Empty means user.signingkey.
+	SigningKey string `json:"signing_key"`
+}
+
+// DefaultPublishConfig is the branch layout of the upstream repository.
+var DefaultPublishConfig = PublishConfig{
+	Remote:        "origin",
+	Branch:        "development",
+	BuildBranch:   "build-{{.Version}}",
+	UpdateMessage: "Upgrade to version {{.Version}}",
+	BuildMessage:  "Built to version {{.Version}}",
+}
+
+// expand executes the template tmpl (a field of the config named name) for
+// version.
+func expand(name, tmpl, version string) (string, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("publish config: %s: %v", name, err)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, struct{ Version string }{version}); err != nil {
+		return "", fmt.Errorf("publish config: %s: %v", name, err)
+	}
+	return buf.String(), nil
+}
+
+// BuildBranchName returns the name of the branch the build of version is
+// pushed to.
+func (c *PublishConfig) BuildBranchName(version string) (string, error) {
+	return expand("build_branch", c.BuildBranch, version)
+}
+
+// Validate checks that the config is complete and its templates expand.
+func (c *PublishConfig) Validate() error {
+	for name, val := range map[string]string{
+		"remote":         c.Remote,
+		"branch":         c.Branch,
+		"build_branch":   c.BuildBranch,
+		"update_message": c.UpdateMessage,
+		"build_message":  c.BuildMessage,
+	} {
+		if strings.TrimSpace(val) == "" {
+			return fmt.Errorf("publish config: %s must not be empty", name)
+		}
+	}
+	for name, tmpl := range map[string]string{
+		"build_branch":   c.BuildBranch,
+		"update_message": c.UpdateMessage,
+		"build_message":  c.BuildMessage,
+	} {
+		if _, err := expand(name, tmpl, "6.8.2"); err != nil {
+			return err
+		}
+	}
+	if (c.AuthorName == "") != (c.AuthorEmail == "") {
+		return fmt.Errorf("publish config: author_name and author_email must be set together")
+	}
+	switch c.Sign {
+	case "", "gpg", "ssh":
+	default:
+		return fmt.Errorf("publish config: invalid sign %q: want gpg or ssh", c.Sign)
+	}
+	if c.SigningKey != "" && c.Sign == "" {
+		return fmt.Errorf("publish config: signing_key requires sign")
+	}
+	return nil
+}
+
+// PublishFlags are the command line flags configuring publishing, shared by
+// amd64-rebuild-kernel and amd64-update-kernel. Flags which are set override
+// the config file, which overrides DefaultPublishConfig.
+type PublishFlags struct {
+	config string
+	values map[string]*string
+}
+
+// publishFlagFields maps flag names to the fields of PublishConfig.
+var publishFlagFields = []struct {
+	name, usage string
+	field       func(*PublishConfig) *string
+}{
+	{"remote", "Remote to push to", func(c *PublishConfig) *string { return &c.Remote }},
+	{"branch", "Base branch to which url.go updates are committed", func(c *PublishConfig) *string { return &c.Branch }},
+	{"build-branch", "Template for the branch to which builds are pushed, e.g. build-{{.Version}}", func(c *PublishConfig) *string { return &c.BuildBranch }},
+	{"update-message", "Template for the commit message of url.go updates", func(c *PublishConfig) *string { return &c.UpdateMessage }},
+	{"build-message", "Template for the commit message of builds", func(c *PublishConfig) *string { return &c.BuildMessage }},
+	{"author-name", "Author name of the commits", func(c *PublishConfig) *string { return &c.AuthorName }},
+	{"author-email", "Author email of the commits", func(c *PublishConfig) *string { return &c.AuthorEmail }},
+	{"committer-name", "Committer name of the commits", func(c *PublishConfig) *string { return &c.CommitterName }},
+	{"committer-email", "Committer email of the commits", func(c *PublishConfig) *string { return &c.CommitterEmail }},
+	{"sign", "Sign commits using gpg or ssh. Empty means the git configuration (commit.gpgsign) applies", func(c *PublishConfig) *string { return &c.Sign }},
+	{"signing-key", "Key to sign commits with (GPG key ID or SSH key path). Empty means user.signingkey", func(c *PublishConfig) *string { return &c.SigningKey }},
+}
+
+// AddPublishFlags registers the publishing flags in fs.
+func AddPublishFlags(fs *flag.FlagSet) *PublishFlags {
+	f := &PublishFlags{values: make(map[string]*string)}
+	fs.StringVar(&f.config, "publish-config",
+		"",
+		"Path to a JSON file configuring remote, branches, commit messages, identity and signing for publishing (see release.PublishConfig)")
+	for _, ff := range publishFlagFields {
+		def := *ff.field(&DefaultPublishConfig)
+		usage := ff.usage
+		if def != "" {
+			usage += fmt.Sprintf(" (default %q)", def)
+		}
+		f.values[ff.name] = fs.String(ff.name, "", usage)
+	}
+	return f
+}
+
+// Config returns the validated publish config.
+func (f *PublishFlags) Config() (PublishConfig, error) {
+	c := DefaultPublishConfig
+	if f.config != "" {
+		b, err := os.ReadFile(f.config)
+		if err != nil {
+			return c, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&c); err != nil {
+			return c, fmt.Errorf("%s: %v", f.config, err)
+		}
+	}
+	for _, ff := range publishFlagFields {
+		if v := *f.values[ff.name]; v != "" {
+			*ff.field(&c) = v
+		}
+	}
+	return c, c.Validate()
+}
@@ -0,0 +1,164 @@
+// Package release resolves kernel releases from kernel.org's releases.json,
+// records the selected release in the url.go file of amd64-build-kernel and
+// publishes updates and builds to the git repository.
+package release
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/kversion"
+)
+
+// ReleasesURL is where kernel.org publishes the current releases.
+const ReleasesURL = "https://www.kernel.org/releases.json"
+
+// ChannelUsage documents the channels accepted by Resolve, e.g. for flag
+// help texts.
+const ChannelUsage = "Release channel to follow: stable, mainline (unless it is a release candidate, which cannot be verified), longterm (the newest longterm series), longterm:<major.minor> (e.g. longterm:6.6) or an explicit version (e.g. 6.6.30)"
+
+type Response struct {
+	LatestStable struct {
+		Version string `json:"version"`
+	} `json:"latest_stable"`
+	Releases []Release `json:"releases"`
+}
+
+type Release struct {
+	Iseol    bool        `json:"iseol"`
+	Version  string      `json:"version"`
+	Moniker  string      `json:"moniker"`
+	Source   string      `json:"source"`
+	Pgp      interface{} `json:"pgp"`
+	Released struct {
+		Timestamp int    `json:"timestamp"`
+		Isodate   string `json:"isodate"`
+	} `json:"released"`
+	Gitweb    string      `json:"gitweb"`
+	Changelog interface{} `json:"changelog"`
+	Diffview  string      `json:"diffview"`
+	Patch     struct {
+		Full        string `json:"full"`
+		Incremental string `json:"incremental"`
+	} `json:"patch"`
+}
+
+// Fetch downloads and decodes the releases.json file at url.
+func Fetch(url string) (*Response, error) {
+	r, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		return nil, fmt.Errorf("unexpected HTTP status code for %s: got %d, want %d", url, got, want)
+	}
+	var resp Response
+	if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("%s: %v", url, err)
+	}
+	return &resp, nil
+}
+
+// Resolve fetches the releases.json file at url and returns the release
+// channel ch currently points to, see Select.
+func Resolve(url, ch string) (*Release, error) {
+	resp, err := Fetch(url)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Select(ch)
+}
+
+// Find returns the release with version v, or nil if there is none.
+func (resp *Response) Find(v string) *Release {
+	for i := range resp.Releases {
+		if resp.Releases[i].Version == v {
+			return &resp.Releases[i]
+		}
+	}
+	return nil
+}
+
+// Select returns the release which channel ch (see ChannelUsage) currently
+// points to. End-of-life releases are never selected by a channel, only when
+// requested explicitly by version. Release candidates are never selected, as
+// their source cannot be verified: while the mainline channel points to a
+// release candidate, Select returns an error.
+func (resp *Response) Select(ch string) (*Release, error) {
+	moniker, series, _ := strings.Cut(ch, ":")
+	switch moniker {
+	case "stable", "mainline", "longterm":
+	default:
+		// an explicit version
+		if _, err := kversion.Parse(ch); err != nil {
+			return nil, fmt.Errorf("invalid channel %q: want stable, mainline, longterm[:<major.minor>] or a version", ch)
+		}
+		r := resp.Find(ch)
+		if r == nil {
+			return nil, fmt.Errorf("Linux %s is not listed in releases.json", ch)
+		}
+		if v, _ := kversion.Parse(ch); v.RC != 0 {
+			return nil, errReleaseCandidate(r.Version)
+		}
+		if r.Iseol {
+			log.Printf("WARNING: Linux %s is end of life", r.Version)
+		}
+		return r, nil
+	}
+	if series != "" && moniker != "longterm" {
+		return nil, fmt.Errorf("invalid channel %q: only longterm takes a series", ch)
+	}
+
+	var (
+		best        *Release
+		bestVersion kversion.Version
+		eol         bool
+		rc          string
+	)
+	for i := range resp.Releases {
+		r := &resp.Releases[i]
+		if r.Moniker != moniker {
+			continue
+		}
+		v, err := kversion.Parse(r.Version)
+		if err != nil {
+			log.Printf("skipping release %q: %v", r.Version, err)
+			continue
+		}
+		if series != "" && v.Series() != series {
+			continue
+		}
+		if r.Iseol {
+			eol = true
+			continue
+		}
+		if v.RC != 0 {
+			rc = r.Version
+			continue
+		}
+		if best == nil || v.Compare(bestVersion) > 0 {
+			best, bestVersion = r, v
+		}
+	}
+	if best == nil {
+		if rc != "" {
+			return nil, fmt.Errorf("channel %s: %v", ch, errReleaseCandidate(rc))
+		}
+		if eol {
+			return nil, fmt.Errorf("channel %s: all releases are end of life, pick another channel", ch)
+		}
+		return nil, fmt.Errorf("channel %s: no release found in releases.json", ch)
+	}
+	return best, nil
+}
+
+// errReleaseCandidate is returned for release candidates, which kernel.org
+// publishes neither signed checksums nor signatures for, so that their
+// source cannot be verified.
+func errReleaseCandidate(version string) error {
+	return fmt.Errorf("Linux %s is a release candidate, which cannot be verified as kernel.org does not sign release candidates; wait for the release or pick another channel", version)
+}
@@ -0,0 +1,127 @@
+package release
+
+import (
+	"bytes"
+	"go/format"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// serveReleases serves testdata/releases.json like www.kernel.org.
+func serveReleases(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/releases.json" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, "testdata/releases.json")
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/releases.json"
+}
+
+func TestResolve(t *testing.T) {
+	url := serveReleases(t)
+	for _, tt := range []struct {
+		channel string
+		want    string
+		wantErr string
+	}{
+		{channel: "stable", want: "6.8.2"},
+		// kernel.org signs no release candidates.
+		{channel: "mainline", wantErr: "6.9-rc1 is a release candidate"},
+		{channel: "6.9-rc1", wantErr: "6.9-rc1 is a release candidate"},
+		{channel: "longterm", want: "6.6.23"},
+		{channel: "longterm:6.1", want: "6.1.83"},
+		{channel: "6.1.83", want: "6.1.83"},
+		// End-of-life releases are only selected explicitly.
+		{channel: "6.7.11", want: "6.7.11"},
+		{channel: "longterm:4.14", wantErr: "all releases are end of life"},
+		{channel: "longterm:5.4", wantErr: "no release found"},
+		{channel: "6.8.1", wantErr: "not listed in releases.json"},
+		{channel: "stable:6.8", wantErr: "only longterm takes a series"},
+		{channel: "linux-next", wantErr: "invalid channel"},
+	} {
+		t.Run(tt.channel, func(t *testing.T) {
+			r, err := Resolve(url, tt.channel)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Resolve(%q) = %v, want error containing %q", tt.channel, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Version != tt.want {
+				t.Errorf("Resolve(%q) = %s, want %s", tt.channel, r.Version, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveHTTPError(t *testing.T) {
+	url := serveReleases(t)
+	if _, err := Resolve(strings.TrimSuffix(url, "releases.json")+"missing.json", "stable"); err == nil ||
+		!strings.Contains(err.Error(), "unexpected HTTP status code") {
+		t.Fatalf("Resolve() = %v, want an HTTP status error", err)
+	}
+}
+
+func TestResolveRelease(t *testing.T) {
+	r, err := Resolve(serveReleases(t), "stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://cdn.kernel.org/pub/linux/kernel/v6.x/linux-6.8.2.tar.xz"; r.Source != want {
+		t.Errorf("Source = %q, want %q", r.Source, want)
+	}
+	if want := "https://cdn.kernel.org/pub/linux/kernel/v6.x/incr/patch-6.8.1-2.xz"; r.Patch.Incremental != want {
+		t.Errorf("Patch.Incremental = %q, want %q", r.Patch.Incremental, want)
+	}
+	if want := 1711624393; r.Released.Timestamp != want {
+		t.Errorf("Released.Timestamp = %d, want %d", r.Released.Timestamp, want)
+	}
+}
+
+func TestSelectMainline(t *testing.T) {
+	resp := &Response{Releases: []Release{
+		{Version: "6.9", Moniker: "mainline"},
+		{Version: "6.8.2", Moniker: "stable"},
+	}}
+	r, err := resp.Select("mainline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Version != "6.9" {
+		t.Errorf("Select(mainline) = %s, want 6.9", r.Version)
+	}
+}
+
+func TestURLFile(t *testing.T) {
+	d := Descriptor{
+		Version:        "6.8.2",
+		Moniker:        "stable",
+		SourceURL:      "https://cdn.kernel.org/pub/linux/kernel/v6.x/linux-6.8.2.tar.xz",
+		SHA256:         strings.Repeat("0", 64),
+		Released:       1711624393,
+		IncrementalURL: "https://cdn.kernel.org/pub/linux/kernel/v6.x/incr/patch-6.8.1-2.xz",
+	}
+	b, err := URLFile(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatted, err := format.Source(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, formatted) {
+		t.Errorf("URLFile() is not gofmt-formatted:\n%s", b)
+	}
+	if v, err := URLFileVersion(b); err != nil || v != d.Version {
+		t.Errorf("URLFileVersion() = %q, %v, want %q", v, err, d.Version)
+	}
+}
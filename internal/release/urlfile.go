@@ -0,0 +1,56 @@
+package release
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"os"
+	"regexp"
+	"text/template"
+)
+
+var urlTemplate = template.Must(template.New("url.go").Parse(`package main
+
+import "development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/release"
+
+// latest is the kernel release to build, see https://www.kernel.org/releases.json
+var latest = release.Descriptor{
+	Version:        {{ printf "%q" .Version }},
+	Moniker:        {{ printf "%q" .Moniker }},
+	SourceURL:      {{ printf "%q" .SourceURL }},
+	SHA256:         {{ printf "%q" .SHA256 }},
+	Released:       {{ .Released }},
+	IncrementalURL: {{ printf "%q" .IncrementalURL }},
+}
+`))
+
+// URLFile returns the contents of the url.go file of amd64-build-kernel,
+// which selects the kernel release to build, for d.
+func URLFile(d Descriptor) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := urlTemplate.Execute(&buf, d); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
+
+// WriteURLFile writes the url.go file for d (see URLFile) to path.
+func WriteURLFile(path string, d Descriptor) error {
+	b, err := URLFile(d)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+var urlFileVersionRe = regexp.MustCompile(`(?m)^\s*Version:\s*"([^"]*)",`)
+
+// URLFileVersion returns the version of the release selected by the url.go
+// file b, see URLFile.
+func URLFileVersion(b []byte) (string, error) {
+	m := urlFileVersionRe.FindSubmatch(b)
+	if m == nil {
+		return "", fmt.Errorf("url.go: no Version found")
+	}
+	return string(m[1]), nil
+}
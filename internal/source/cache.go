@@ -0,0 +1,302 @@
+package source
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cache is a content-addressed store of downloaded files:
+//
+//	blobs/sha256/<sha256 of content>  the downloaded file
+//	urls/<sha256 of URL>              sha256 of the content last downloaded from URL
+//	partial/<sha256 of URL>           an interrupted download of URL
+type Cache struct {
+	Dir string
+}
+
+func hashString(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func (c *Cache) blobPath(sum string) string {
+	return filepath.Join(c.Dir, "blobs", "sha256", sum)
+}
+
+func (c *Cache) urlPath(url string) string {
+	return filepath.Join(c.Dir, "urls", hashString(url))
+}
+
+func (c *Cache) partialPath(url string) string {
+	return filepath.Join(c.Dir, "partial", hashString(url))
+}
+
+// Lookup returns the path and checksum of the cached content of url, if any.
+// The content is re-hashed so that a corrupted cache entry is never used.
+func (c *Cache) Lookup(url string) (path, sum string, ok bool) {
+	b, err := os.ReadFile(c.urlPath(url))
+	if err != nil {
+		return "", "", false
+	}
+	sum = strings.TrimSpace(string(b))
+	path = c.blobPath(sum)
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", false
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", "", false
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != sum {
+		log.Printf("cache: discarding corrupt entry %s (sha256 %s)", path, got)
+		os.Remove(path)
+		return "", "", false
+	}
+	// Record the access for eviction.
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return path, sum, true
+}
+
+// Open returns the content of url as a stream: the cached copy, if any, or
+// the download, resuming a previously interrupted download using an HTTP
+// range request where possible. The download is stored in the cache by
+// Stream.Finish. A cached copy is re-hashed while it is read, so that a
+// corrupted cache entry is detected (and removed) by Stream.Finish.
+func (c *Cache) Open(url string) (*Stream, error) {
+	if b, err := os.ReadFile(c.urlPath(url)); err == nil {
+		want := strings.TrimSpace(string(b))
+		if f, err := os.Open(c.blobPath(want)); err == nil {
+			log.Printf("cache: using %s for %s", f.Name(), url)
+			// Record the access for eviction.
+			now := time.Now()
+			os.Chtimes(f.Name(), now, now)
+			return newStream(f, func(sum string) (string, error) {
+				if sum != want {
+					c.Remove(url)
+					return "", fmt.Errorf("cache: %s was corrupt (sha256 %s) and was removed, run again to download %s", f.Name(), sum, url)
+				}
+				return f.Name(), nil
+			}, f), nil
+		}
+	}
+	return c.download(url)
+}
+
+// download starts the download of url into the cache, see Open.
+func (c *Cache) download(url string) (*Stream, error) {
+	for _, dir := range []string{"blobs/sha256", "urls", "partial"} {
+		if err := os.MkdirAll(filepath.Join(c.Dir, dir), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	partial := c.partialPath(url)
+	out, err := os.OpenFile(partial, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := out.Seek(0, io.SeekEnd)
+	if err != nil {
+		out.Close()
+		return nil, err
+	}
+	resp, err := get(url, offset)
+	if err != nil {
+		out.Close()
+		return nil, err
+	}
+	var r io.Reader
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		contentRange := resp.Header.Get("Content-Range")
+		if start, ok := rangeStart(contentRange); !ok || start != offset {
+			if offset == 0 {
+				resp.Body.Close()
+				out.Close()
+				return nil, fmt.Errorf("%s: unexpected Content-Range %q, want the content from byte 0", url, contentRange)
+			}
+			log.Printf("cache: got Content-Range %q for %s, want the content from byte %d; starting over", contentRange, url, offset)
+			return c.restart(url, resp, out)
+		}
+		log.Printf("cache: resuming download of %s at byte %d", url, offset)
+		// What was downloaded before is read (and hashed) first.
+		r = io.MultiReader(io.NewSectionReader(out, 0, offset), io.TeeReader(resp.Body, out))
+	case http.StatusOK:
+		// Range not supported (or nothing to resume): start over.
+		if err := truncate(out); err != nil {
+			resp.Body.Close()
+			out.Close()
+			return nil, err
+		}
+		r = io.TeeReader(resp.Body, out)
+	default:
+		// The partial download is complete or stale; start over.
+		return c.restart(url, resp, out)
+	}
+	return newStream(r, func(sum string) (string, error) {
+		if err := out.Close(); err != nil {
+			return "", err
+		}
+		path := c.blobPath(sum)
+		if err := os.Rename(partial, path); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(c.urlPath(url), []byte(sum+"\n"), 0644); err != nil {
+			return "", err
+		}
+		return path, nil
+	}, resp.Body, out), nil
+}
+
+// restart discards the partial download out of url and the response resp,
+// then downloads url from the start.
+func (c *Cache) restart(url string, resp *http.Response, out *os.File) (*Stream, error) {
+	resp.Body.Close()
+	err := truncate(out)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.download(url)
+}
+
+// rangeStart returns the first byte position of a Content-Range header value
+// such as "bytes 5000-21999/22000".
+func rangeStart(contentRange string) (int64, bool) {
+	rest, ok := strings.CutPrefix(contentRange, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	first, _, ok := strings.Cut(rest, "-")
+	if !ok {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	return start, err == nil
+}
+
+// Download fetches url into the cache, see Open.
+func (c *Cache) Download(url string) (path, sum string, _ error) {
+	s, err := c.download(url)
+	if err != nil {
+		return "", "", err
+	}
+	defer s.Close()
+	return s.Finish()
+}
+
+func truncate(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := f.Seek(0, io.SeekStart)
+	return err
+}
+
+// Remove removes the cached content of url, e.g. after it failed
+// verification, so that the next Download fetches it again.
+func (c *Cache) Remove(url string) error {
+	path, _, ok := c.Lookup(url)
+	if err := os.Remove(c.urlPath(url)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	log.Printf("cache: removing %s (content of %s)", path, url)
+	return os.Remove(path)
+}
+
+// Evict removes blobs and interrupted downloads which were not used within
+// maxAge, then the least recently used of them until the cache is smaller
+// than maxSize, and finally the urls/ entries of removed blobs. The blobs with
+// the checksums keep (e.g. the one about to be used) are never removed, even
+// if they alone exceed maxSize.
+func (c *Cache) Evict(maxAge time.Duration, maxSize int64, keep ...string) error {
+	kept := make(map[string]bool)
+	for _, sum := range keep {
+		kept[c.blobPath(sum)] = true
+	}
+	blobs, err := filepath.Glob(filepath.Join(c.Dir, "blobs", "sha256", "*"))
+	if err != nil {
+		return err
+	}
+	partials, err := filepath.Glob(filepath.Join(c.Dir, "partial", "*"))
+	if err != nil {
+		return err
+	}
+	var infos []os.FileInfo
+	var paths []string
+	for _, path := range append(blobs, partials...) {
+		st, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		infos = append(infos, st)
+		paths = append(paths, path)
+	}
+	sort.Sort(byModTime{infos, paths})
+
+	var size int64
+	for _, st := range infos {
+		size += st.Size()
+	}
+	for i, st := range infos {
+		expired := maxAge > 0 && time.Since(st.ModTime()) > maxAge
+		tooBig := maxSize > 0 && size > maxSize
+		if kept[paths[i]] || !expired && !tooBig {
+			continue
+		}
+		log.Printf("cache: evicting %s (%d bytes, last used %v)", paths[i], st.Size(), st.ModTime())
+		if err := os.Remove(paths[i]); err != nil {
+			return err
+		}
+		size -= st.Size()
+	}
+
+	urls, err := filepath.Glob(filepath.Join(c.Dir, "urls", "*"))
+	if err != nil {
+		return err
+	}
+	for _, path := range urls {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(c.blobPath(strings.TrimSpace(string(b)))); !os.IsNotExist(err) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// byModTime sorts files from least to most recently used.
+type byModTime struct {
+	infos []os.FileInfo
+	paths []string
+}
+
+func (b byModTime) Len() int           { return len(b.infos) }
+func (b byModTime) Less(i, j int) bool { return b.infos[i].ModTime().Before(b.infos[j].ModTime()) }
+func (b byModTime) Swap(i, j int) {
+	b.infos[i], b.infos[j] = b.infos[j], b.infos[i]
+	b.paths[i], b.paths[j] = b.paths[j], b.paths[i]
+}
@@ -0,0 +1,247 @@
+package source
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// serveFile serves content with support for range requests, counting the
+// requests.
+func serveFile(t *testing.T, content []byte) (*httptest.Server, *int) {
+	t.Helper()
+	requests := new(int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		http.ServeContent(w, r, "linux-6.8.2.tar.xz", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, requests
+}
+
+func readStream(t *testing.T, s *Stream) ([]byte, string, string) {
+	t.Helper()
+	defer s.Close()
+	b, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, sum, err := s.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, path, sum
+}
+
+func TestCacheOpen(t *testing.T) {
+	content := bytes.Repeat([]byte("gokrazy kernel source\n"), 1000)
+	h := sha256.Sum256(content)
+	want := hex.EncodeToString(h[:])
+	srv, requests := serveFile(t, content)
+	url := srv.URL + "/linux-6.8.2.tar.xz"
+	c := &Cache{Dir: t.TempDir()}
+
+	// An interrupted download is resumed.
+	if err := os.MkdirAll(c.Dir+"/partial", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(c.partialPath(url), content[:5000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := c.Open(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, path, sum := readStream(t, s)
+	if !bytes.Equal(b, content) {
+		t.Fatalf("resumed download has %d bytes, want %d", len(b), len(content))
+	}
+	if sum != want || path != c.blobPath(want) {
+		t.Fatalf("Finish() = %s, %s, want %s, %s", path, sum, c.blobPath(want), want)
+	}
+
+	// The second Open is served from the cache.
+	s, err = c.Open(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _, _ := readStream(t, s); !bytes.Equal(b, content) {
+		t.Fatalf("cached content differs")
+	}
+	if *requests != 1 {
+		t.Errorf("%d HTTP requests, want 1", *requests)
+	}
+
+	// Eviction keeps the blob in use, even though it exceeds the size.
+	if err := c.Evict(0, 1, want); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("blob in use was evicted: %v", err)
+	}
+	if err := c.Evict(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("blob not evicted: %v", err)
+	}
+	if _, err := os.Stat(c.urlPath(url)); !os.IsNotExist(err) {
+		t.Fatalf("URL entry of the evicted blob not removed: %v", err)
+	}
+}
+
+func TestCacheOpenContentRange(t *testing.T) {
+	content := bytes.Repeat([]byte("gokrazy kernel source\n"), 1000)
+	for _, tt := range []struct {
+		name         string
+		contentRange string
+		wantRequests int
+	}{
+		{"range ignored", "bytes 0-21999/22000", 2},
+		{"other range", "bytes 4000-21999/22000", 2},
+		{"invalid", "pages 5000-21999/22000", 2},
+		{"resumed", "bytes 5000-21999/22000", 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// The server answers range requests with content
+			// starting at the offset the client asked for, but
+			// labels it with tt.contentRange.
+			requests := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if r.Header.Get("Range") == "" {
+					w.Write(content)
+					return
+				}
+				w.Header().Set("Content-Range", tt.contentRange)
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(content[5000:])
+			}))
+			defer srv.Close()
+			url := srv.URL + "/linux-6.8.2.tar.xz"
+			c := &Cache{Dir: t.TempDir()}
+			if err := os.MkdirAll(c.Dir+"/partial", 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(c.partialPath(url), content[:5000], 0644); err != nil {
+				t.Fatal(err)
+			}
+			s, err := c.Open(url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b, _, _ := readStream(t, s); !bytes.Equal(b, content) {
+				t.Errorf("download has %d bytes, want the content (%d bytes)", len(b), len(content))
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("%d HTTP requests, want %d", requests, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestCacheEvict(t *testing.T) {
+	c := &Cache{Dir: t.TempDir()}
+	write := func(path, content string, age time.Duration) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(-age)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const (
+		oldURL    = "https://cdn.kernel.org/pub/linux/kernel/v6.x/linux-6.7.tar.xz"
+		newURL    = "https://cdn.kernel.org/pub/linux/kernel/v6.x/linux-6.8.tar.xz"
+		oldSum    = "0000000000000000000000000000000000000000000000000000000000000001"
+		newSum    = "0000000000000000000000000000000000000000000000000000000000000002"
+		staleURL  = "https://cdn.kernel.org/pub/linux/kernel/v6.x/patch-6.7.1.xz"
+		resumeURL = "https://cdn.kernel.org/pub/linux/kernel/v6.x/linux-6.8.1.tar.xz"
+		day       = 24 * time.Hour
+	)
+	write(c.blobPath(oldSum), "linux 6.7", 60*day)
+	write(c.urlPath(oldURL), oldSum+"\n", 60*day)
+	write(c.blobPath(newSum), "linux 6.8", day)
+	write(c.urlPath(newURL), newSum+"\n", day)
+	write(c.partialPath(staleURL), "patch", 60*day)
+	write(c.partialPath(resumeURL), "linux", 2*day)
+
+	if err := c.Evict(30*day, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		path string
+		want bool
+	}{
+		{c.blobPath(oldSum), false},
+		{c.urlPath(oldURL), false},
+		{c.partialPath(staleURL), false},
+		{c.blobPath(newSum), true},
+		{c.urlPath(newURL), true},
+		{c.partialPath(resumeURL), true},
+	} {
+		_, err := os.Stat(tt.path)
+		if got := err == nil; got != tt.want {
+			t.Errorf("%s exists = %v, want %v (%v)", tt.path, got, tt.want, err)
+		}
+	}
+
+	// The interrupted download counts towards the size, too, and is
+	// evicted first because it was used less recently.
+	if err := c.Evict(0, int64(len("linux 6.8"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(c.partialPath(resumeURL)); !os.IsNotExist(err) {
+		t.Errorf("interrupted download not evicted: %v", err)
+	}
+	if _, err := os.Stat(c.blobPath(newSum)); err != nil {
+		t.Errorf("blob evicted although the cache fits: %v", err)
+	}
+}
+
+func TestCacheOpenCorrupt(t *testing.T) {
+	content := []byte("linux source")
+	srv, requests := serveFile(t, content)
+	url := srv.URL + "/linux-6.8.2.tar.xz"
+	c := &Cache{Dir: t.TempDir()}
+	path, _, err := c.Download(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := c.Open(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, _, err := s.Finish(); err == nil {
+		t.Fatalf("Finish() of a corrupt cache entry succeeded")
+	}
+	if _, _, ok := c.Lookup(url); ok {
+		t.Fatalf("corrupt cache entry was not removed")
+	}
+	s, err = c.Open(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _, _ := readStream(t, s); !bytes.Equal(b, content) {
+		t.Fatalf("content after re-download differs")
+	}
+	if *requests != 2 {
+		t.Errorf("%d HTTP requests, want 2", *requests)
+	}
+}
@@ -0,0 +1,136 @@
+package source
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Stream is the content of a URL while it is read, either from the cache or
+// from the network. Its sha256 checksum is computed along the way.
+type Stream struct {
+	r       io.Reader
+	h       hash.Hash
+	closers []io.Closer
+	// finish is called with the checksum once the content was read
+	// completely and returns the path of the cached copy, if any.
+	finish func(sum string) (string, error)
+}
+
+func newStream(r io.Reader, finish func(string) (string, error), closers ...io.Closer) *Stream {
+	return &Stream{r: r, h: sha256.New(), closers: closers, finish: finish}
+}
+
+func (s *Stream) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.h.Write(p[:n])
+	return n, err
+}
+
+// Finish reads the rest of the content and returns its sha256 checksum and,
+// for a stream of a Cache, the path of the cached copy. A download is only
+// stored in the cache once it is finished.
+func (s *Stream) Finish() (path, sum string, _ error) {
+	if _, err := io.Copy(io.Discard, s); err != nil {
+		return "", "", err
+	}
+	sum = hex.EncodeToString(s.h.Sum(nil))
+	if s.finish != nil {
+		var err error
+		if path, err = s.finish(sum); err != nil {
+			return "", "", err
+		}
+	}
+	return path, sum, nil
+}
+
+// Close releases the stream. A download of a Cache which was not finished is
+// kept, so that the next Open resumes it.
+func (s *Stream) Close() error {
+	var err error
+	for _, c := range s.closers {
+		// Finish closes the cached download itself.
+		if cerr := c.Close(); err == nil && !errors.Is(cerr, os.ErrClosed) {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// get starts a GET request of url, optionally resuming at offset, and
+// returns the response unless it has an unexpected status code.
+func get(url string, offset int64) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+		return resp, nil
+	}
+	resp.Body.Close()
+	return nil, fmt.Errorf("unexpected HTTP status code for %s: got %d, want %d", url, resp.StatusCode, http.StatusOK)
+}
+
+// Open returns the content of url as a stream: the copy in cache (if not nil),
+// or the download, see Cache.Open.
+func Open(url string, cache *Cache) (*Stream, error) {
+	if cache != nil {
+		return cache.Open(url)
+	}
+	resp, err := get(url, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected HTTP status code for %s: got %d, want %d", url, resp.StatusCode, http.StatusOK)
+	}
+	return newStream(resp.Body, nil, resp.Body), nil
+}
+
+// Download downloads url into dir (or uses the copy in cache, if not nil) and
+// returns the path and sha256 checksum of the file.
+func Download(url, dir string, cache *Cache) (string, string, error) {
+	if cache != nil {
+		if path, sum, ok := cache.Lookup(url); ok {
+			log.Printf("using cached kernel source %s", path)
+			return path, sum, nil
+		}
+		return cache.Download(url)
+	}
+
+	out, err := os.Create(filepath.Join(dir, path.Base(url)))
+	if err != nil {
+		return "", "", err
+	}
+	defer out.Close()
+	s, err := Open(url, nil)
+	if err != nil {
+		return "", "", err
+	}
+	defer s.Close()
+	if _, err := io.Copy(out, s); err != nil {
+		return "", "", err
+	}
+	_, sum, err := s.Finish()
+	if err != nil {
+		return "", "", err
+	}
+	return out.Name(), sum, out.Close()
+}
@@ -0,0 +1,174 @@
+package source
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Decompress returns a reader of the decompressed contents of r, selecting
+// the format by the file name (e.g. linux-6.8.2.tar.xz or patch-6.8.2.xz).
+// The returned function releases the decompressor and must be called once
+// the reader was consumed.
+func Decompress(name string, r io.Reader) (io.Reader, func() error, error) {
+	switch {
+	case strings.HasSuffix(name, ".gz"), strings.HasSuffix(name, ".tgz"):
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", name, err)
+		}
+		return zr, zr.Close, nil
+	case strings.HasSuffix(name, ".xz"):
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", name, err)
+		}
+		return xr, func() error { return nil }, nil
+	case strings.HasSuffix(name, ".zst"):
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", name, err)
+		}
+		return zr, func() error { zr.Close(); return nil }, nil
+	case strings.HasSuffix(name, ".tar"):
+		return r, func() error { return nil }, nil
+	default:
+		return nil, nil, fmt.Errorf("%s: unsupported compression format", name)
+	}
+}
+
+// Extract unpacks the (compressed) tarball name, read from r, into dir and
+// returns the name of the single top-level directory of the archive. Entries
+// which would be written outside of dir are rejected. Decompression and
+// unpacking are streamed, so r can be a download. If decompressed is not
+// nil, the whole decompressed tarball (including the padding after the end
+// of the archive) is also written to it, e.g. to check its signature.
+func Extract(dir, name string, r io.Reader, decompressed io.Writer) (string, error) {
+	dr, done, err := Decompress(name, r)
+	if err != nil {
+		return "", err
+	}
+	if decompressed != nil {
+		dr = io.TeeReader(dr, decompressed)
+	}
+	topdir, err := untar(dir, dr)
+	if err == nil {
+		// The padding is part of the data covered by the signature.
+		_, err = io.Copy(io.Discard, dr)
+	}
+	if derr := done(); err == nil {
+		err = derr
+	}
+	if err != nil {
+		return "", fmt.Errorf("extracting %s: %v", name, err)
+	}
+	return topdir, nil
+}
+
+// throughSymlink returns the first of name and its parent directories which
+// is a symlink created by untar, if any.
+func throughSymlink(symlinks map[string]bool, name string) (string, bool) {
+	for p := name; p != "."; p = filepath.Dir(p) {
+		if symlinks[p] {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+func untar(dir string, r io.Reader) (string, error) {
+	var topdir string
+	// symlinks contains all symlinks created so far, so that no entry can be
+	// written through a symlink pointing outside of dir.
+	symlinks := make(map[string]bool)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			// e.g. the git commit id in kernel.org tarballs
+			continue
+		}
+		name := filepath.Clean(hdr.Name)
+		if !filepath.IsLocal(name) {
+			return "", fmt.Errorf("refusing to extract %q: path outside of destination", hdr.Name)
+		}
+		if p, ok := throughSymlink(symlinks, name); ok {
+			return "", fmt.Errorf("refusing to extract %q: path traverses symlink %q", hdr.Name, p)
+		}
+		top := strings.Split(name, string(filepath.Separator))[0]
+		if topdir == "" {
+			topdir = top
+		} else if top != topdir {
+			return "", fmt.Errorf("archive contains more than one top-level directory: %q and %q", topdir, top)
+		}
+
+		dest := filepath.Join(dir, name)
+		mode := hdr.FileInfo().Mode().Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dest, mode|0700); err != nil {
+				return "", err
+			}
+
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return "", err
+			}
+			f, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+			if err != nil {
+				return "", err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return "", err
+			}
+			if err := f.Close(); err != nil {
+				return "", err
+			}
+
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return "", err
+			}
+			if err := os.Symlink(hdr.Linkname, dest); err != nil {
+				return "", err
+			}
+			symlinks[name] = true
+			continue
+
+		case tar.TypeLink:
+			// The target must neither be a symlink nor be reached through
+			// one: the link would then refer to a file outside of dir.
+			target := filepath.Clean(hdr.Linkname)
+			if _, ok := throughSymlink(symlinks, target); ok || !filepath.IsLocal(target) {
+				return "", fmt.Errorf("refusing to extract %q: hard link to %q", hdr.Name, hdr.Linkname)
+			}
+			if err := os.Link(filepath.Join(dir, target), dest); err != nil {
+				return "", err
+			}
+
+		default:
+			return "", fmt.Errorf("refusing to extract %q: unsupported entry type %q", hdr.Name, hdr.Typeflag)
+		}
+		if err := os.Chtimes(dest, hdr.ModTime, hdr.ModTime); err != nil {
+			return "", err
+		}
+	}
+	if topdir == "" {
+		return "", fmt.Errorf("archive is empty")
+	}
+	return topdir, nil
+}
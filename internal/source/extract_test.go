@@ -0,0 +1,197 @@
+package source
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// entry is a tar archive entry for makeTar.
+type entry struct {
+	name     string
+	typ      byte
+	linkname string
+	content  string
+}
+
+func makeTar(t *testing.T, entries ...entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typ,
+			Linkname: e.linkname,
+			Mode:     0644,
+			Size:     int64(len(e.content)),
+			ModTime:  time.Unix(1711843200, 0),
+		}
+		if e.typ == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		if e.typ != tar.TypeReg {
+			hdr.Size = 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, e.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// compress compresses b in the format of the file name.
+func compress(t *testing.T, name string, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch {
+	case strings.HasSuffix(name, ".gz"):
+		w = gzip.NewWriter(&buf)
+	case strings.HasSuffix(name, ".xz"):
+		w, err = xz.NewWriter(&buf)
+	case strings.HasSuffix(name, ".zst"):
+		w, err = zstd.NewWriter(&buf)
+	default:
+		return b
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+var linuxTree = []entry{
+	{name: "linux-6.8.2/", typ: tar.TypeDir},
+	{name: "linux-6.8.2/Makefile", typ: tar.TypeReg, content: "VERSION = 6\nPATCHLEVEL = 8\nSUBLEVEL = 2\nEXTRAVERSION =\n"},
+	{name: "linux-6.8.2/scripts/dtc/include-prefixes/arm", typ: tar.TypeSymlink, linkname: "../../../arch/arm/boot/dts"},
+	{name: "linux-6.8.2/COPYING-link", typ: tar.TypeLink, linkname: "linux-6.8.2/Makefile"},
+}
+
+func TestExtract(t *testing.T) {
+	archive := makeTar(t, linuxTree...)
+	for _, name := range []string{
+		"linux-6.8.2.tar",
+		"linux-6.8.2.tar.gz",
+		"linux-6.8.2.tar.xz",
+		"linux-6.8.2.tar.zst",
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			var decompressed bytes.Buffer
+			topdir, err := Extract(dir, name, bytes.NewReader(compress(t, name, archive)), &decompressed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := topdir, "linux-6.8.2"; got != want {
+				t.Errorf("Extract() = %q, want %q", got, want)
+			}
+			if !bytes.Equal(decompressed.Bytes(), archive) {
+				t.Errorf("decompressed tarball differs from the archive (%d bytes, want %d)", decompressed.Len(), len(archive))
+			}
+			v, err := KernelVersion(filepath.Join(dir, topdir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := v.String(), "6.8.2"; got != want {
+				t.Errorf("KernelVersion() = %s, want %s", got, want)
+			}
+			target, err := os.Readlink(filepath.Join(dir, "linux-6.8.2/scripts/dtc/include-prefixes/arm"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "../../../arch/arm/boot/dts"; target != want {
+				t.Errorf("symlink target = %q, want %q", target, want)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "linux-6.8.2/COPYING-link")); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestExtractRejects(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		entries []entry
+		wantErr string
+	}{
+		{
+			name:    "parent directory",
+			entries: []entry{{name: "linux/../../evil", typ: tar.TypeReg}},
+			wantErr: "path outside of destination",
+		},
+		{
+			name:    "absolute path",
+			entries: []entry{{name: "/etc/evil", typ: tar.TypeReg}},
+			wantErr: "path outside of destination",
+		},
+		{
+			name: "write through symlink",
+			entries: []entry{
+				{name: "linux/escape", typ: tar.TypeSymlink, linkname: "/tmp"},
+				{name: "linux/escape/evil", typ: tar.TypeReg},
+			},
+			wantErr: `path traverses symlink "linux/escape"`,
+		},
+		{
+			name: "hard link to symlink",
+			entries: []entry{
+				{name: "linux/passwd", typ: tar.TypeSymlink, linkname: "/etc/passwd"},
+				{name: "linux/evil", typ: tar.TypeLink, linkname: "linux/passwd"},
+			},
+			wantErr: "hard link",
+		},
+		{
+			name: "hard link through symlinked directory",
+			entries: []entry{
+				{name: "linux/etc", typ: tar.TypeSymlink, linkname: "/etc"},
+				{name: "linux/evil", typ: tar.TypeLink, linkname: "linux/etc/passwd"},
+			},
+			wantErr: "hard link",
+		},
+		{
+			name:    "hard link outside",
+			entries: []entry{{name: "linux/evil", typ: tar.TypeLink, linkname: "../outside"}},
+			wantErr: "hard link",
+		},
+		{
+			name: "two top-level directories",
+			entries: []entry{
+				{name: "linux/a", typ: tar.TypeReg},
+				{name: "other/b", typ: tar.TypeReg},
+			},
+			wantErr: "more than one top-level directory",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			_, err := Extract(dir, "evil.tar", bytes.NewReader(makeTar(t, tt.entries...)), nil)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Extract() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// kernelOrgSigningKeys are the primary key fingerprints of the developers who
+// sign kernel.org releases, see https://www.kernel.org/signature.html
+var kernelOrgSigningKeys = map[string]string{
+	"ABAF11C65A2970B130ABE3C479BE3E4300411886": "torvalds@kernel.org",
+	"647F28654894E3BD457199BE38DBBDC86092693E": "gregkh@kernel.org",
+}
+
+// PinnedKeyringPath is the path (relative to the repository) of the pinned
+// keyring, which UpdateKeyring writes.
+const PinnedKeyringPath = "internal/source/keyring/kernel.org.asc"
+
+// pinnedKeyring contains the keys of kernelOrgSigningKeys, ASCII armored in
+// kernel.org.asc. Signatures are verified using it unless a keyring is
+// specified explicitly, so that a build does not depend on fetching keys.
+//
+//go:embed keyring
+var pinnedKeyring embed.FS
+
+// Keyring writes the keyring to verify signatures with into a file in dir
+// and returns its path: the OpenPGP keyring (binary or ASCII armored) at
+// keyringPath if not empty, the pinned kernel.org keyring otherwise. Either
+// way, only signatures made by kernelOrgSigningKeys are accepted by
+// VerifySignature.
+func Keyring(dir, keyringPath string) (string, error) {
+	var b []byte
+	var err error
+	if keyringPath != "" {
+		b, err = os.ReadFile(keyringPath)
+	} else {
+		b, err = pinnedKeyring.ReadFile("keyring/kernel.org.asc")
+		if err != nil {
+			err = fmt.Errorf("no pinned kernel.org keyring: %v (run amd64-update-keyring to create %s, or use -keyring)", err, PinnedKeyringPath)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	// gpgv only reads binary keyrings.
+	if b, err = dearmor(b); err != nil {
+		return "", err
+	}
+	keyring := filepath.Join(dir, "kernel.org.gpg")
+	if err := os.WriteFile(keyring, b, 0644); err != nil {
+		return "", err
+	}
+	return keyring, nil
+}
+
+// dearmor returns the binary content of the ASCII armored OpenPGP blocks of
+// b, or b itself if it is not armored.
+func dearmor(b []byte) ([]byte, error) {
+	if !bytes.Contains(b, []byte("-----BEGIN PGP ")) {
+		return b, nil
+	}
+	var (
+		out      []byte
+		body     strings.Builder
+		inBlock  bool
+		inHeader bool
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "-----BEGIN PGP "):
+			inBlock, inHeader = true, true
+			body.Reset()
+		case !inBlock:
+		case strings.HasPrefix(line, "-----END PGP "):
+			data, err := base64.StdEncoding.DecodeString(body.String())
+			if err != nil {
+				return nil, fmt.Errorf("invalid ASCII armor: %v", err)
+			}
+			out = append(out, data...)
+			inBlock = false
+		case inHeader && strings.Contains(line, ": "):
+			// armor header, e.g. "Comment: …"
+		case inHeader && line == "":
+			inHeader = false
+		case strings.HasPrefix(line, "="):
+			// CRC24 checksum, redundant with the packet checks of gpgv
+		default:
+			inHeader = false
+			body.WriteString(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if inBlock || len(out) == 0 {
+		return nil, fmt.Errorf("invalid ASCII armor: no complete block")
+	}
+	return out, nil
+}
+
+// UpdateKeyring fetches the keys of kernelOrgSigningKeys using WKD and
+// writes them, ASCII armored, to path (see PinnedKeyringPath). It fails
+// unless every fetched key has its pinned fingerprint.
+func UpdateKeyring(path string) error {
+	home, err := os.MkdirTemp("", "kernel-keyring")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(home)
+	var emails []string
+	for _, email := range kernelOrgSigningKeys {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+	locate := exec.Command("gpg",
+		append([]string{
+			"--homedir", home,
+			"--batch",
+			"--auto-key-locate", "clear,nodefault,wkd",
+			"--locate-keys",
+		}, emails...)...)
+	locate.Stdout = os.Stdout
+	locate.Stderr = os.Stderr
+	if err := locate.Run(); err != nil {
+		return fmt.Errorf("%v: %v", locate.Args, err)
+	}
+
+	list := exec.Command("gpg", "--homedir", home, "--batch", "--with-colons", "--list-keys")
+	list.Stderr = os.Stderr
+	out, err := list.Output()
+	if err != nil {
+		return fmt.Errorf("%v: %v", list.Args, err)
+	}
+	found := make(map[string]bool)
+	primary := false
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(line, ":")
+		switch {
+		case fields[0] == "pub":
+			primary = true
+		case fields[0] == "sub":
+			primary = false
+		case fields[0] == "fpr" && primary && len(fields) > 9:
+			fpr := fields[9]
+			if _, ok := kernelOrgSigningKeys[fpr]; !ok {
+				return fmt.Errorf("WKD returned key %s, which is not pinned", fpr)
+			}
+			found[fpr] = true
+			primary = false
+		}
+	}
+	var fprs []string
+	for fpr, email := range kernelOrgSigningKeys {
+		if !found[fpr] {
+			return fmt.Errorf("WKD returned no key %s for %s", fpr, email)
+		}
+		fprs = append(fprs, fpr)
+	}
+	sort.Strings(fprs)
+
+	export := exec.Command("gpg",
+		append([]string{
+			"--homedir", home,
+			"--batch",
+			"--armor",
+			"--export-options", "export-minimal",
+			"--output", path,
+			"--yes",
+			"--export",
+		}, fprs...)...)
+	export.Stderr = os.Stderr
+	if err := export.Run(); err != nil {
+		return fmt.Errorf("%v: %v", export.Args, err)
+	}
+	return nil
+}
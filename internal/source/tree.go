@@ -0,0 +1,199 @@
+package source
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/kversion"
+)
+
+// TreeDigest returns a sha256 digest over the regular files and symlinks
+// below dir: their paths, executable bits, contents and link targets.
+// Directories, timestamps and ownership are not included, so that a tree
+// unpacked from a tarball and a tree produced by patching an older tree have
+// the same digest if (and only if) they have the same content. Note that the
+// digest cannot be compared with the sha256 of the tarball itself.
+func TreeDigest(dir string) (string, error) {
+	type entry struct{ path, line string }
+	var entries []entry
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		switch {
+		case d.IsDir():
+			return nil
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry{rel, fmt.Sprintf("l %s %q\n", rel, target)})
+		case d.Type().IsRegular():
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			sum, err := hashFile(path)
+			if err != nil {
+				return err
+			}
+			kind := "f"
+			if info.Mode()&0111 != 0 {
+				kind = "x"
+			}
+			entries = append(entries, entry{rel, fmt.Sprintf("%s %s %s\n", kind, rel, sum)})
+		default:
+			return fmt.Errorf("%s: unsupported file type %v", path, d.Type())
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].path < entries[j].path })
+	h := sha256.New()
+	for _, e := range entries {
+		io.WriteString(h, e.line)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// TreeInfo describes how a cached source tree was produced: either unpacked
+// from a tarball, or by applying an incremental patch to the tree of the
+// previous release.
+type TreeInfo struct {
+	Version    string `json:"version"`
+	TreeSHA256 string `json:"tree_sha256"`
+
+	// SourceURL and SourceSHA256 describe the tarball the tree was
+	// unpacked from or, for an upgraded tree, compared with, if any.
+	SourceURL    string `json:"source_url,omitempty"`
+	SourceSHA256 string `json:"source_sha256,omitempty"`
+
+	BaseVersion    string `json:"base_version,omitempty"`
+	BaseTreeSHA256 string `json:"base_tree_sha256,omitempty"`
+	PatchURL       string `json:"patch_url,omitempty"`
+	PatchSHA256    string `json:"patch_sha256,omitempty"`
+}
+
+// CopyTree copies the directory src to dest, which must not exist, preserving
+// modes, timestamps and symlinks.
+func CopyTree(dest, src string) error {
+	cp := exec.Command("cp", "-a", src, dest)
+	cp.Stderr = os.Stderr
+	if err := cp.Run(); err != nil {
+		return fmt.Errorf("%v: %v", cp.Args, err)
+	}
+	return nil
+}
+
+func (c *Cache) treePath(version string) string {
+	return filepath.Join(c.Dir, "trees", version)
+}
+
+// LookupTree returns the path of the cached, unmodified source tree of
+// version. The tree is only returned if it still has the digest it was
+// stored with.
+func (c *Cache) LookupTree(version string) (string, *TreeInfo, error) {
+	dir := c.treePath(version)
+	b, err := os.ReadFile(dir + ".json")
+	if err != nil {
+		return "", nil, err
+	}
+	var info TreeInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return "", nil, fmt.Errorf("%s.json: %v", dir, err)
+	}
+	sum, err := TreeDigest(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	if sum != info.TreeSHA256 {
+		return "", nil, fmt.Errorf("cached tree %s was modified: digest %s, want %s", dir, sum, info.TreeSHA256)
+	}
+	return dir, &info, nil
+}
+
+// StoreTree stores a copy of the unmodified source tree srcdir described by
+// info, replacing the cached trees of older releases of the same series:
+// incremental patches only lead from one release of a series to the next.
+func (c *Cache) StoreTree(srcdir string, info *TreeInfo) error {
+	v, err := kversion.Parse(info.Version)
+	if err != nil {
+		return err
+	}
+	dir := c.treePath(info.Version)
+	tmp := dir + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	if err := CopyTree(tmp, srcdir); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(dir+".json", append(b, '\n'), 0644); err != nil {
+		return err
+	}
+
+	others, err := filepath.Glob(filepath.Join(c.Dir, "trees", "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, other := range others {
+		version := filepath.Base(other[:len(other)-len(".json")])
+		ov, err := kversion.Parse(version)
+		// Newer trees are kept, e.g. when building an older release
+		// again.
+		if err != nil || ov.Series() != v.Series() || ov.Compare(v) >= 0 {
+			continue
+		}
+		log.Printf("cache: evicting source tree of Linux %s", version)
+		if err := os.Remove(other); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(c.treePath(version)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package source
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// storeTestTree stores a tree of version in cache, containing only a
+// Makefile.
+func storeTestTree(t *testing.T, cache *Cache, version string) {
+	t.Helper()
+	srcdir := filepath.Join(t.TempDir(), "linux-"+version)
+	if err := os.MkdirAll(srcdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcdir, "Makefile"), []byte("# "+version+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	digest, err := TreeDigest(srcdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.StoreTree(srcdir, &TreeInfo{Version: version, TreeSHA256: digest}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// cachedTrees returns the versions of the trees in cache which LookupTree
+// returns.
+func cachedTrees(t *testing.T, cache *Cache) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(cache.Dir, "trees", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []string
+	for _, m := range matches {
+		version := filepath.Base(m[:len(m)-len(".json")])
+		if _, _, err := cache.LookupTree(version); err != nil {
+			t.Errorf("LookupTree(%s): %v", version, err)
+			continue
+		}
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+func TestStoreTree(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		cached []string
+		store  string
+		want   []string
+	}{
+		{
+			name:   "older release of the series",
+			cached: []string{"6.7.12", "6.8.1"},
+			store:  "6.8.2",
+			want:   []string{"6.7.12", "6.8.2"},
+		},
+		{
+			name:   "newer release of the series",
+			cached: []string{"6.8", "6.8.3"},
+			store:  "6.8.2",
+			want:   []string{"6.8.2", "6.8.3"},
+		},
+		{
+			name:   "same release",
+			cached: []string{"6.8.2"},
+			store:  "6.8.2",
+			want:   []string{"6.8.2"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := &Cache{Dir: t.TempDir()}
+			for _, version := range tt.cached {
+				storeTestTree(t, cache, version)
+			}
+			storeTestTree(t, cache, tt.store)
+			got := cachedTrees(t, cache)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("cached trees = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
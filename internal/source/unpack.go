@@ -0,0 +1,122 @@
+package source
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// VerifyOptions configure how Unpack verifies a tarball.
+type VerifyOptions struct {
+	// KeyringPath is the keyring to verify signatures with, see
+	// Keyring.
+	KeyringPath string
+	// SHA256, if not empty, is the checksum the tarball must have in
+	// addition to matching the signed kernel.org checksums, e.g. the one
+	// pinned in url.go.
+	SHA256 string
+	// Insecure skips the verification.
+	Insecure bool
+}
+
+// Unpack streams the tarball at url (the copy in cache, if not nil and it
+// has one) through decompression and unpacking into dir, without an
+// intermediate file. Meanwhile, its checksum is compared with the signed
+// kernel.org checksums and the decompressed tarball is checked against its
+// detached signature. If the verification fails, nothing is left in dir and
+// the tarball is removed from the cache. Unpack returns the path of the
+// source tree and the sha256 checksum of the tarball.
+func Unpack(dir, url string, cache *Cache, opts VerifyOptions) (string, string, error) {
+	tmp, err := os.MkdirTemp("", "verify-kernel")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	var keyring, want, signPath string
+	if !opts.Insecure {
+		if keyring, err = Keyring(tmp, opts.KeyringPath); err != nil {
+			return "", "", err
+		}
+		if want, err = ExpectedChecksum(tmp, keyring, url); err != nil {
+			return "", "", err
+		}
+		if opts.SHA256 != "" && opts.SHA256 != want {
+			return "", "", fmt.Errorf("%s: pinned checksum %s does not match sha256sums.asc (%s)", url, opts.SHA256, want)
+		}
+		if signPath, err = downloadSignature(tmp, url); err != nil {
+			return "", "", err
+		}
+	}
+
+	s, err := Open(url, cache)
+	if err != nil {
+		return "", "", err
+	}
+	defer s.Close()
+	// The archive is unpacked next to its final location and only moved
+	// there once it was verified.
+	unpacked, err := os.MkdirTemp(dir, ".unpack")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(unpacked)
+
+	if opts.Insecure {
+		topdir, err := Extract(unpacked, url, s, nil)
+		if err != nil {
+			return "", "", err
+		}
+		_, sum, err := s.Finish()
+		if err != nil {
+			return "", "", err
+		}
+		log.Printf("WARNING: not verifying %s (sha256 %s)", url, sum)
+		return move(dir, unpacked, topdir, sum)
+	}
+	sig := startSignatureCheck(keyring, signPath)
+	topdir, err := Extract(unpacked, url, s, sig)
+	sigErr := sig.wait(err)
+	// A corrupt download is usually what makes the extraction fail, so the
+	// checksum is checked first.
+	_, sum, ferr := s.Finish()
+	if ferr == nil && sum != want {
+		discard(cache, url)
+		return "", "", fmt.Errorf("%s: checksum mismatch: got %s, want %s", url, sum, want)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if ferr != nil {
+		return "", "", ferr
+	}
+	log.Printf("sha256 checksum %s matches sha256sums.asc", sum)
+	if sigErr != nil {
+		discard(cache, url)
+		return "", "", sigErr
+	}
+	return move(dir, unpacked, topdir, sum)
+}
+
+// move moves the directory topdir, unpacked into tmp, to dir.
+func move(dir, tmp, topdir, sum string) (string, string, error) {
+	srcdir := filepath.Join(dir, topdir)
+	if err := os.RemoveAll(srcdir); err != nil {
+		return "", "", err
+	}
+	if err := os.Rename(filepath.Join(tmp, topdir), srcdir); err != nil {
+		return "", "", err
+	}
+	return srcdir, sum, nil
+}
+
+// discard removes the copy of url which failed verification from cache.
+func discard(cache *Cache, url string) {
+	if cache == nil {
+		return
+	}
+	if err := cache.Remove(url); err != nil {
+		log.Printf("cache: removing %s failed: %v", url, err)
+	}
+}
@@ -0,0 +1,205 @@
+// Package source downloads, verifies and unpacks kernel.org source tarballs.
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// VerifySignature runs gpgv on the specified signature and returns an error
+// unless it was made by one of kernelOrgSigningKeys. Signed data is read from
+// data if sigPath is a detached signature, and written to output if sigPath
+// is a clearsigned file and output is non-empty.
+func VerifySignature(keyring, sigPath string, data io.Reader, output string) error {
+	args := []string{"--keyring", keyring, "--status-fd", "1"}
+	if output != "" {
+		args = append(args, "--output", output)
+	}
+	args = append(args, sigPath)
+	if data != nil {
+		args = append(args, "-")
+	}
+	gpgv := exec.Command("gpgv", args...)
+	gpgv.Stdin = data
+	gpgv.Stderr = os.Stderr
+	status, err := gpgv.Output()
+	if err != nil {
+		return fmt.Errorf("%v: %v", gpgv.Args, err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(status))
+	for scanner.Scan() {
+		// [GNUPG:] VALIDSIG <fpr> <date> <timestamp> <expire> <version> <reserved> <pubkey-algo> <hash-algo> <sig-class> <primary-key-fpr>
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "[GNUPG:]" || fields[1] != "VALIDSIG" {
+			continue
+		}
+		primary := fields[len(fields)-1]
+		if signer, ok := kernelOrgSigningKeys[primary]; ok {
+			log.Printf("good signature on %s by %s (%s)", filepath.Base(sigPath), signer, primary)
+			return nil
+		}
+		return fmt.Errorf("%s: signed by unexpected key %s", sigPath, primary)
+	}
+	return fmt.Errorf("%s: no valid signature found", sigPath)
+}
+
+// DownloadFile downloads url to dest.
+func DownloadFile(dest, url string) error {
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		return fmt.Errorf("unexpected HTTP status code for %s: got %d, want %d", url, got, want)
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// ExpectedChecksum returns the sha256 checksum kernel.org publishes for url in
+// the signed sha256sums.asc file next to it. dir is used for temporary files.
+func ExpectedChecksum(dir, keyring, url string) (string, error) {
+	// Not path.Dir, which would turn https:// into https:/.
+	sumsURL := url[:strings.LastIndex(url, "/")+1] + "sha256sums.asc"
+	sumsPath := filepath.Join(dir, "sha256sums.asc")
+	if err := DownloadFile(sumsPath, sumsURL); err != nil {
+		return "", err
+	}
+	verifiedPath := filepath.Join(dir, "sha256sums")
+	if err := VerifySignature(keyring, sumsPath, nil, verifiedPath); err != nil {
+		return "", err
+	}
+	b, err := os.ReadFile(verifiedPath)
+	if err != nil {
+		return "", err
+	}
+	filename := path.Base(url)
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == filename {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("%s: no checksum for %s", sumsURL, filename)
+}
+
+// Checksum returns the sha256 checksum of the tarball at url from the signed
+// kernel.org checksums. See Keyring for keyringPath.
+func Checksum(url, keyringPath string) (string, error) {
+	dir, err := os.MkdirTemp("", "verify-kernel")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	keyring, err := Keyring(dir, keyringPath)
+	if err != nil {
+		return "", err
+	}
+	return ExpectedChecksum(dir, keyring, url)
+}
+
+// signatureURL returns the URL of the detached signature kernel.org
+// publishes for the uncompressed content of the file at url.
+func signatureURL(url string) string {
+	for _, ext := range []string{".xz", ".gz", ".zst"} {
+		if strings.HasSuffix(url, ext) {
+			return strings.TrimSuffix(url, ext) + ".sign"
+		}
+	}
+	return url + ".sign"
+}
+
+// downloadSignature downloads the detached signature of url into dir.
+func downloadSignature(dir, url string) (string, error) {
+	signURL := signatureURL(url)
+	signPath := filepath.Join(dir, path.Base(signURL))
+	if err := DownloadFile(signPath, signURL); err != nil {
+		return "", err
+	}
+	return signPath, nil
+}
+
+// verifyDetached verifies the compressed file downloaded from url to file
+// using the detached signature kernel.org publishes for its uncompressed
+// content.
+func verifyDetached(dir, keyring, url, file string) error {
+	signPath, err := downloadSignature(dir, url)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, done, err := Decompress(url, f)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return VerifySignature(keyring, signPath, r, "")
+}
+
+// signatureCheck verifies a detached signature (see VerifySignature) over
+// the data written to it, while it is written.
+type signatureCheck struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func startSignatureCheck(keyring, sigPath string) *signatureCheck {
+	pr, pw := io.Pipe()
+	c := &signatureCheck{pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := VerifySignature(keyring, sigPath, pr, "")
+		// Fail further writes instead of blocking them if gpgv exited
+		// early.
+		pr.CloseWithError(fmt.Errorf("signature check ended: %v", err))
+		c.done <- err
+	}()
+	return c
+}
+
+func (c *signatureCheck) Write(p []byte) (int, error) {
+	return c.pw.Write(p)
+}
+
+// wait ends the data (with err, if the data is incomplete) and returns the
+// result of the signature check.
+func (c *signatureCheck) wait(err error) error {
+	c.pw.CloseWithError(err)
+	return <-c.done
+}
+
+// VerifyPatch verifies the .xz compressed patch downloaded from url to file
+// using its detached signature. Unlike Unpack, it does not look up a checksum
+// in sha256sums.asc.
+func VerifyPatch(url, file, keyringPath string) error {
+	dir, err := os.MkdirTemp("", "verify-kernel")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	keyring, err := Keyring(dir, keyringPath)
+	if err != nil {
+		return err
+	}
+	return verifyDetached(dir, keyring, url, file)
+}
@@ -0,0 +1,340 @@
+package source
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// signer is a throwaway OpenPGP key standing in for a kernel.org signing key.
+type signer struct {
+	home string
+	fpr  string
+}
+
+func gpg(t *testing.T, home string, stdin []byte, args ...string) []byte {
+	t.Helper()
+	cmd := exec.Command("gpg", append([]string{"--homedir", home, "--batch", "--no-tty"}, args...)...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%v: %v\n%s", cmd.Args, err, stderr.Bytes())
+	}
+	return out
+}
+
+// newSigner generates a key. If trusted, its fingerprint is accepted by
+// VerifySignature for the duration of the test.
+func newSigner(t *testing.T, uid string, trusted bool) *signer {
+	t.Helper()
+	for _, tool := range []string{"gpg", "gpgv", "gpgconf"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found: %v", tool, err)
+		}
+	}
+	home, err := os.MkdirTemp("", "gnupg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		exec.Command("gpgconf", "--homedir", home, "--kill", "gpg-agent").Run()
+		os.RemoveAll(home)
+	})
+	gpg(t, home, nil, "--passphrase", "", "--quick-gen-key", uid, "ed25519", "sign", "never")
+	var fpr string
+	for _, line := range strings.Split(string(gpg(t, home, nil, "--with-colons", "--list-keys")), "\n") {
+		if fields := strings.Split(line, ":"); fields[0] == "fpr" {
+			fpr = fields[9]
+			break
+		}
+	}
+	if trusted {
+		kernelOrgSigningKeys[fpr] = uid
+		t.Cleanup(func() { delete(kernelOrgSigningKeys, fpr) })
+	}
+	return &signer{home: home, fpr: fpr}
+}
+
+func (s *signer) clearsign(t *testing.T, data []byte) []byte {
+	return gpg(t, s.home, data, "--clearsign")
+}
+
+func (s *signer) detachSign(t *testing.T, data []byte) []byte {
+	return gpg(t, s.home, data, "--armor", "--detach-sign")
+}
+
+// writeKeyring writes the public keys of signers into a keyring file, ASCII
+// armored if armor is set.
+func writeKeyring(t *testing.T, armor bool, signers ...*signer) string {
+	t.Helper()
+	var b []byte
+	for _, s := range signers {
+		args := []string{"--export", s.fpr}
+		if armor {
+			args = append([]string{"--armor"}, args...)
+		}
+		b = append(b, gpg(t, s.home, nil, args...)...)
+	}
+	keyring := filepath.Join(t.TempDir(), "keyring")
+	if err := os.WriteFile(keyring, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+// serveKernelOrg serves files below /pub/linux/kernel/v6.x/ like
+// cdn.kernel.org.
+func serveKernelOrg(t *testing.T, files map[string][]byte) string {
+	t.Helper()
+	const dir = "/pub/linux/kernel/v6.x/"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, ok := files[strings.TrimPrefix(r.URL.Path, dir)]
+		if !ok || !strings.HasPrefix(r.URL.Path, dir) {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(b)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + dir
+}
+
+func sha256sum(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// release are the files kernel.org publishes for a release.
+type release struct {
+	tarball []byte
+	files   map[string][]byte
+}
+
+// newRelease returns the files of Linux 6.8.2, with the checksums and the
+// tarball signed by s.
+func newRelease(t *testing.T, s *signer) *release {
+	archive := makeTar(t, linuxTree...)
+	tarball := compress(t, ".xz", archive)
+	sums := fmt.Sprintf("%s  linux-6.8.1.tar.xz\n%s  linux-6.8.2.tar.xz\n", strings.Repeat("0", 64), sha256sum(tarball))
+	return &release{
+		tarball: tarball,
+		files: map[string][]byte{
+			"linux-6.8.2.tar.xz":   tarball,
+			"linux-6.8.2.tar.sign": s.detachSign(t, archive),
+			"sha256sums.asc":       s.clearsign(t, []byte(sums)),
+		},
+	}
+}
+
+func TestUnpack(t *testing.T) {
+	kernelOrg := newSigner(t, "Test Release Signer <test@kernel.org>", true)
+	other := newSigner(t, "Someone Else <other@example.org>", false)
+	keyring := writeKeyring(t, true, kernelOrg, other)
+	good := newRelease(t, kernelOrg)
+
+	for _, tt := range []struct {
+		name string
+		// modify changes the published files of good.
+		modify  func(files map[string][]byte)
+		sha256  string
+		wantErr string
+	}{
+		{
+			name: "verified",
+		},
+		{
+			name:   "pinned checksum",
+			sha256: sha256sum(good.tarball),
+		},
+		{
+			name:    "pinned checksum mismatch",
+			sha256:  strings.Repeat("1", 64),
+			wantErr: "pinned checksum",
+		},
+		{
+			name: "tampered tarball",
+			modify: func(files map[string][]byte) {
+				files["linux-6.8.2.tar.xz"] = compress(t, ".xz", makeTar(t, linuxTree[:2]...))
+			},
+			wantErr: "checksum mismatch",
+		},
+		{
+			name: "checksums signed by another key",
+			modify: func(files map[string][]byte) {
+				files["sha256sums.asc"] = other.clearsign(t, []byte(sha256sum(good.tarball)+"  linux-6.8.2.tar.xz\n"))
+			},
+			wantErr: "signed by unexpected key",
+		},
+		{
+			name: "signature of other content",
+			modify: func(files map[string][]byte) {
+				files["linux-6.8.2.tar.sign"] = kernelOrg.detachSign(t, []byte("not the tarball"))
+			},
+			wantErr: "gpgv",
+		},
+		{
+			name: "tarball signed by another key",
+			modify: func(files map[string][]byte) {
+				files["linux-6.8.2.tar.sign"] = other.detachSign(t, makeTar(t, linuxTree...))
+			},
+			wantErr: "signed by unexpected key",
+		},
+		{
+			name: "no signature",
+			modify: func(files map[string][]byte) {
+				delete(files, "linux-6.8.2.tar.sign")
+			},
+			wantErr: "unexpected HTTP status code",
+		},
+		{
+			name: "not listed in checksums",
+			modify: func(files map[string][]byte) {
+				files["sha256sums.asc"] = kernelOrg.clearsign(t, []byte(sha256sum(good.tarball)+"  linux-6.8.3.tar.xz\n"))
+			},
+			wantErr: "no checksum for linux-6.8.2.tar.xz",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			files := make(map[string][]byte)
+			for name, b := range good.files {
+				files[name] = b
+			}
+			if tt.modify != nil {
+				tt.modify(files)
+			}
+			url := serveKernelOrg(t, files) + "linux-6.8.2.tar.xz"
+			dir := t.TempDir()
+			cache := &Cache{Dir: t.TempDir()}
+			srcdir, sum, err := Unpack(dir, url, cache, VerifyOptions{KeyringPath: keyring, SHA256: tt.sha256})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Unpack() = %v, want error containing %q", err, tt.wantErr)
+				}
+				if entries, _ := os.ReadDir(dir); len(entries) > 0 {
+					t.Errorf("Unpack() left %s behind", entries[0].Name())
+				}
+				if path, _, ok := cache.Lookup(url); ok {
+					t.Errorf("unverified download %s is still cached", path)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := filepath.Join(dir, "linux-6.8.2"); srcdir != want {
+				t.Errorf("Unpack() = %s, want %s", srcdir, want)
+			}
+			if want := sha256sum(good.tarball); sum != want {
+				t.Errorf("Unpack() sha256 = %s, want %s", sum, want)
+			}
+			if _, err := os.Stat(filepath.Join(srcdir, "Makefile")); err != nil {
+				t.Error(err)
+			}
+			if _, _, ok := cache.Lookup(url); !ok {
+				t.Errorf("verified download is not cached")
+			}
+		})
+	}
+}
+
+func TestVerifyPatch(t *testing.T) {
+	kernelOrg := newSigner(t, "Test Release Signer <test@kernel.org>", true)
+	keyring := writeKeyring(t, false, kernelOrg)
+	diff := []byte("--- a/Makefile\n+++ b/Makefile\n@@ -3 +3 @@\n-SUBLEVEL = 1\n+SUBLEVEL = 2\n")
+	compressed := compress(t, ".xz", diff)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "patch-6.8.1-2.xz")
+	if err := os.WriteFile(file, compressed, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		sign    []byte
+		wantErr bool
+	}{
+		{"signed", diff, false},
+		{"signature of other content", []byte("something else"), true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			base := serveKernelOrg(t, map[string][]byte{
+				"incr/patch-6.8.1-2.sign": kernelOrg.detachSign(t, tt.sign),
+			})
+			err := VerifyPatch(base+"incr/patch-6.8.1-2.xz", file, keyring)
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("VerifyPatch() = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	kernelOrg := newSigner(t, "Test Release Signer <test@kernel.org>", true)
+	keyring := writeKeyring(t, true, kernelOrg)
+	r := newRelease(t, kernelOrg)
+	base := serveKernelOrg(t, r.files)
+
+	sum, err := Checksum(base+"linux-6.8.2.tar.xz", keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := sha256sum(r.tarball); sum != want {
+		t.Errorf("Checksum() = %s, want %s", sum, want)
+	}
+	if _, err := Checksum(base+"linux-6.8.3.tar.xz", keyring); err == nil {
+		t.Errorf("Checksum() of an unlisted tarball succeeded")
+	}
+}
+
+// TestPinnedKeyring checks that the pinned keyring is committed and contains
+// exactly the keys of kernelOrgSigningKeys.
+func TestPinnedKeyring(t *testing.T) {
+	b, err := pinnedKeyring.ReadFile(path.Join("keyring", "kernel.org.asc"))
+	if err != nil {
+		t.Fatalf("%s is missing, create it with amd64-update-keyring (see keyring/README): %v", PinnedKeyringPath, err)
+	}
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip(err)
+	}
+	cmd := exec.Command("gpg", "--batch", "--with-colons", "--import-options", "show-only", "--import")
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Env = append(os.Environ(), "GNUPGHOME="+t.TempDir())
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%v: %v", cmd.Args, err)
+	}
+	found := make(map[string]bool)
+	primary := false
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(line, ":")
+		switch fields[0] {
+		case "pub":
+			primary = true
+		case "fpr":
+			if primary {
+				found[fields[9]] = true
+			}
+			primary = false
+		}
+	}
+	for fpr, email := range kernelOrgSigningKeys {
+		if !found[fpr] {
+			t.Errorf("pinned keyring lacks %s (%s)", fpr, email)
+		}
+		delete(found, fpr)
+	}
+	for fpr := range found {
+		t.Errorf("pinned keyring contains unexpected key %s", fpr)
+	}
+}
@@ -0,0 +1,28 @@
+package source
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"development.thatwebsite.xyz/gokrazy/kernel-amd64/internal/kversion"
+)
+
+// KernelVersion returns the version of the kernel source in srcdir, as
+// declared in its top-level Makefile.
+func KernelVersion(srcdir string) (kversion.Version, error) {
+	b, err := os.ReadFile(filepath.Join(srcdir, "Makefile"))
+	if err != nil {
+		return kversion.Version{}, err
+	}
+	vars := make(map[string]string)
+	for _, line := range strings.Split(string(b), "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		vars[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return kversion.Parse(fmt.Sprintf("%s.%s.%s%s", vars["VERSION"], vars["PATCHLEVEL"], vars["SUBLEVEL"], vars["EXTRAVERSION"]))
+}
@@ -0,0 +1,345 @@
+// Package squashfs writes squashfs 4.0 images which the kernel can mount.
+//
+// The images are minimal: all metadata and data is stored uncompressed, there
+// are no fragments, extended attributes or export tables, and all files are
+// owned by root. That is enough for test images (see amd64-boot-test) and
+// keeps the writer independent of the compressors the kernel is built with.
+package squashfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	magic        = 0x73717368
+	blockLog     = 17
+	blockSize    = 1 << blockLog
+	metadataSize = 8192
+
+	// compressionZlib is declared in the superblock. Nothing is compressed,
+	// but the kernel requires a compressor it supports.
+	compressionZlib = 1
+
+	// Superblock flags.
+	flagUncompressedInodes    = 0x0001
+	flagUncompressedData      = 0x0002
+	flagUncompressedFragments = 0x0008
+	flagNoFragments           = 0x0010
+	flagNoXattrs              = 0x0200
+	flagUncompressedIDs       = 0x0800
+
+	// metadataUncompressed marks a metadata block as stored uncompressed,
+	// dataUncompressed a data block.
+	metadataUncompressed = 0x8000
+	dataUncompressed     = 1 << 24
+
+	invalidBlock = 0xffffffffffffffff
+	noFragment   = 0xffffffff
+
+	// Basic inode types.
+	typeDir     = 1
+	typeFile    = 2
+	typeSymlink = 3
+	typeCharDev = 5
+
+	// maxDirEntries is the maximum number of entries per directory header.
+	maxDirEntries = 256
+)
+
+type node struct {
+	name     string
+	typ      uint16
+	perm     fs.FileMode
+	data     []byte // file content or symlink target
+	rdev     uint32 // device number of a device node, encoded like new_encode_dev
+	children map[string]*node
+
+	// Assigned while writing.
+	ino      uint32
+	inodeRef uint64 // metadata block start << 16 | offset
+}
+
+// Writer writes a squashfs image. Entries can be added in any order, but
+// parent directories must be added first. The image is written by Close.
+type Writer struct {
+	w    io.Writer
+	root *node
+}
+
+// NewWriter returns a Writer writing to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w:    w,
+		root: &node{typ: typeDir, perm: 0755, children: make(map[string]*node)},
+	}
+}
+
+func (w *Writer) add(name string, n *node) error {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		return fmt.Errorf("squashfs: invalid name")
+	}
+	parent := w.root
+	if dir := path.Dir(name); dir != "." {
+		for _, elem := range strings.Split(dir, "/") {
+			parent = parent.children[elem]
+			if parent == nil || parent.typ != typeDir {
+				return fmt.Errorf("squashfs: %s: parent directory %s not added", name, dir)
+			}
+		}
+	}
+	n.name = path.Base(name)
+	if _, ok := parent.children[n.name]; ok {
+		return fmt.Errorf("squashfs: %s: already exists", name)
+	}
+	parent.children[n.name] = n
+	return nil
+}
+
+// Dir adds a directory.
+func (w *Writer) Dir(name string, perm fs.FileMode) error {
+	return w.add(name, &node{typ: typeDir, perm: perm, children: make(map[string]*node)})
+}
+
+// File adds a regular file with the specified content.
+func (w *Writer) File(name string, perm fs.FileMode, data []byte) error {
+	return w.add(name, &node{typ: typeFile, perm: perm, data: data})
+}
+
+// Symlink adds a symbolic link to target.
+func (w *Writer) Symlink(name, target string) error {
+	return w.add(name, &node{typ: typeSymlink, perm: 0777, data: []byte(target)})
+}
+
+// CharDev adds a character device node, e.g. /dev/console (5, 1), which the
+// kernel opens for init before any devtmpfs is mounted.
+func (w *Writer) CharDev(name string, perm fs.FileMode, major, minor uint32) error {
+	rdev := minor&0xff | major<<8 | (minor&^0xff)<<12
+	return w.add(name, &node{typ: typeCharDev, perm: perm, rdev: rdev})
+}
+
+func (n *node) sortedChildren() []*node {
+	children := make([]*node, 0, len(n.children))
+	for _, c := range n.children {
+		children = append(children, c)
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].name < children[j].name })
+	return children
+}
+
+// metadata is a table of metadata blocks, all stored uncompressed.
+type metadata struct {
+	buf bytes.Buffer
+}
+
+// ref returns the reference to the current end of the table: the start of
+// its metadata block on disk (relative to the table) and the offset within.
+func (m *metadata) ref() (block uint32, offset uint16) {
+	n := m.buf.Len()
+	return uint32(n / metadataSize * (metadataSize + 2)), uint16(n % metadataSize)
+}
+
+// bytes returns the table split into metadata blocks.
+func (m *metadata) bytes() []byte {
+	var out []byte
+	b := m.buf.Bytes()
+	for len(b) > 0 {
+		n := len(b)
+		if n > metadataSize {
+			n = metadataSize
+		}
+		out = binary.LittleEndian.AppendUint16(out, uint16(n)|metadataUncompressed)
+		out = append(out, b[:n]...)
+		b = b[n:]
+	}
+	return out
+}
+
+func (m *metadata) write(v ...interface{}) {
+	for _, v := range v {
+		binary.Write(&m.buf, binary.LittleEndian, v)
+	}
+}
+
+// image is the state while writing the image.
+type image struct {
+	data    bytes.Buffer // data blocks, starting after the superblock
+	inodes  metadata
+	dirs    metadata
+	nextIno uint32
+}
+
+const superblockSize = 96
+
+// number assigns inode numbers, children before their parent (like
+// mksquashfs), so that the root directory has the highest number.
+func (im *image) number(n *node) {
+	for _, c := range n.sortedChildren() {
+		im.number(c)
+	}
+	im.nextIno++
+	n.ino = im.nextIno
+}
+
+// writeInode writes the inode of n (and, first, of its children).
+func (im *image) writeInode(n *node, parentIno uint32) error {
+	header := func(typ uint16) {
+		im.inodes.write(typ, uint16(n.perm.Perm()), uint16(0), uint16(0), uint32(0), n.ino)
+	}
+	switch n.typ {
+	case typeFile:
+		start := uint32(superblockSize + im.data.Len())
+		var sizes []uint32
+		for b := n.data; len(b) > 0; {
+			size := len(b)
+			if size > blockSize {
+				size = blockSize
+			}
+			im.data.Write(b[:size])
+			sizes = append(sizes, uint32(size)|dataUncompressed)
+			b = b[size:]
+		}
+		block, offset := im.inodes.ref()
+		n.inodeRef = uint64(block)<<16 | uint64(offset)
+		header(typeFile)
+		im.inodes.write(start, uint32(noFragment), uint32(0), uint32(len(n.data)), sizes)
+
+	case typeSymlink:
+		block, offset := im.inodes.ref()
+		n.inodeRef = uint64(block)<<16 | uint64(offset)
+		header(typeSymlink)
+		im.inodes.write(uint32(1), uint32(len(n.data)), n.data)
+
+	case typeCharDev:
+		block, offset := im.inodes.ref()
+		n.inodeRef = uint64(block)<<16 | uint64(offset)
+		header(typeCharDev)
+		im.inodes.write(uint32(1), n.rdev)
+
+	case typeDir:
+		children := n.sortedChildren()
+		nlink := uint32(2)
+		for _, c := range children {
+			if err := im.writeInode(c, n.ino); err != nil {
+				return err
+			}
+			if c.typ == typeDir {
+				nlink++
+			}
+		}
+		dirBlock, dirOffset := im.dirs.ref()
+		listing, err := dirListing(children)
+		if err != nil {
+			return err
+		}
+		im.dirs.buf.Write(listing)
+		if len(listing)+3 > 0xffff {
+			return fmt.Errorf("squashfs: directory %s too large", n.name)
+		}
+		block, offset := im.inodes.ref()
+		n.inodeRef = uint64(block)<<16 | uint64(offset)
+		header(typeDir)
+		im.inodes.write(dirBlock, nlink, uint16(len(listing)+3), dirOffset, parentIno)
+	}
+	return nil
+}
+
+// dirListing returns the directory table entries of children, which must be
+// sorted by name and have their inodes written.
+func dirListing(children []*node) ([]byte, error) {
+	var buf bytes.Buffer
+	for i := 0; i < len(children); {
+		// All entries of a header must share the inode metadata block and
+		// be within an int16 inode number distance of the base.
+		base := children[i]
+		j := i + 1
+		for j < len(children) && j-i < maxDirEntries &&
+			children[j].inodeRef>>16 == base.inodeRef>>16 &&
+			int64(children[j].ino)-int64(base.ino) <= 32767 &&
+			int64(children[j].ino)-int64(base.ino) >= -32768 {
+			j++
+		}
+		binary.Write(&buf, binary.LittleEndian, []uint32{uint32(j - i - 1), uint32(base.inodeRef >> 16), base.ino})
+		for _, c := range children[i:j] {
+			if len(c.name) > 256 {
+				return nil, fmt.Errorf("squashfs: name too long: %q", c.name)
+			}
+			binary.Write(&buf, binary.LittleEndian, []uint16{
+				uint16(c.inodeRef & 0xffff),
+				uint16(int16(int64(c.ino) - int64(base.ino))),
+				c.typ,
+				uint16(len(c.name) - 1),
+			})
+			buf.WriteString(c.name)
+		}
+		i = j
+	}
+	return buf.Bytes(), nil
+}
+
+// Close writes the image. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	im := &image{}
+	im.number(w.root)
+	if err := im.writeInode(w.root, im.nextIno+1); err != nil {
+		return err
+	}
+
+	inodeTable := im.inodes.bytes()
+	dirTable := im.dirs.bytes()
+	inodeTableStart := uint64(superblockSize + im.data.Len())
+	dirTableStart := inodeTableStart + uint64(len(inodeTable))
+	// The id table holds a single id (0, root), referenced by an index of
+	// metadata block locations.
+	idBlockStart := dirTableStart + uint64(len(dirTable))
+	ids := binary.LittleEndian.AppendUint32(nil, 0)
+	idBlock := append(binary.LittleEndian.AppendUint16(nil, uint16(len(ids))|metadataUncompressed), ids...)
+	idTableStart := idBlockStart + uint64(len(idBlock))
+	bytesUsed := idTableStart + 8
+
+	var sb bytes.Buffer
+	binary.Write(&sb, binary.LittleEndian, struct {
+		Magic, Inodes, MkfsTime, BlockSize, Fragments    uint32
+		Compression, BlockLog, Flags, IDs, Major, Minor  uint16
+		RootInode, BytesUsed, IDTable, XattrTable        uint64
+		InodeTable, DirTable, FragmentTable, ExportTable uint64
+	}{
+		Magic:         magic,
+		Inodes:        im.nextIno,
+		BlockSize:     blockSize,
+		Compression:   compressionZlib,
+		BlockLog:      blockLog,
+		Flags:         flagUncompressedInodes | flagUncompressedData | flagUncompressedFragments | flagNoFragments | flagNoXattrs | flagUncompressedIDs,
+		IDs:           1,
+		Major:         4,
+		Minor:         0,
+		RootInode:     w.root.inodeRef,
+		BytesUsed:     bytesUsed,
+		IDTable:       idTableStart,
+		XattrTable:    invalidBlock,
+		InodeTable:    inodeTableStart,
+		DirTable:      dirTableStart,
+		FragmentTable: invalidBlock,
+		ExportTable:   invalidBlock,
+	})
+
+	out := sb.Bytes()
+	out = append(out, im.data.Bytes()...)
+	out = append(out, inodeTable...)
+	out = append(out, dirTable...)
+	out = append(out, idBlock...)
+	out = binary.LittleEndian.AppendUint64(out, idBlockStart)
+	// Block devices (e.g. loop devices) have a size in multiples of 4096.
+	if rem := len(out) % 4096; rem != 0 {
+		out = append(out, make([]byte, 4096-rem)...)
+	}
+	_, err := w.w.Write(out)
+	return err
+}
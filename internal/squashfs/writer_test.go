@@ -0,0 +1,355 @@
+package squashfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"path"
+	"testing"
+)
+
+// superblock is the decoded squashfs superblock.
+type superblock struct {
+	Magic, Inodes, MkfsTime, BlockSize, Fragments    uint32
+	Compression, BlockLog, Flags, IDs, Major, Minor  uint16
+	RootInode, BytesUsed, IDTable, XattrTable        uint64
+	InodeTable, DirTable, FragmentTable, ExportTable uint64
+}
+
+// table is a decoded metadata table.
+type table struct {
+	data []byte
+	// blocks maps the start of each metadata block (relative to the table)
+	// to its position in data.
+	blocks map[uint32]int
+}
+
+// decodeTable decodes the uncompressed metadata blocks in b.
+func decodeTable(t *testing.T, name string, b []byte) *table {
+	t.Helper()
+	tab := &table{blocks: make(map[uint32]int)}
+	for off := 0; off < len(b); {
+		h := binary.LittleEndian.Uint16(b[off:])
+		if h&metadataUncompressed == 0 {
+			t.Fatalf("%s: metadata block at %d is compressed", name, off)
+		}
+		n := int(h &^ metadataUncompressed)
+		if n == 0 || n > metadataSize || off+2+n > len(b) {
+			t.Fatalf("%s: metadata block at %d has invalid size %d", name, off, n)
+		}
+		if off+2+n < len(b) && n != metadataSize {
+			t.Errorf("%s: metadata block at %d has %d bytes, only the last may be shorter than %d", name, off, n, metadataSize)
+		}
+		tab.blocks[uint32(off)] = len(tab.data)
+		tab.data = append(tab.data, b[off+2:off+2+n]...)
+		off += 2 + n
+	}
+	return tab
+}
+
+// at returns the table data starting at block and offset.
+func (tab *table) at(t *testing.T, block uint32, offset uint16) []byte {
+	t.Helper()
+	pos, ok := tab.blocks[block]
+	if !ok {
+		t.Fatalf("no metadata block starts at %d", block)
+	}
+	return tab.data[pos+int(offset):]
+}
+
+// file is a decoded file system entry.
+type file struct {
+	typ  uint16
+	perm fs.FileMode
+	data string // file content or symlink target
+	rdev uint32
+}
+
+// reader decodes an image, checking its structure.
+type reader struct {
+	t      *testing.T
+	img    []byte
+	sb     superblock
+	inodes *table
+	dirs   *table
+	files  map[string]file
+	// seen records the inode numbers, which must be unique.
+	seen map[uint32]string
+}
+
+func read(t *testing.T, img []byte) *reader {
+	t.Helper()
+	r := &reader{t: t, img: img, files: make(map[string]file), seen: make(map[uint32]string)}
+	if err := binary.Read(bytes.NewReader(img), binary.LittleEndian, &r.sb); err != nil {
+		t.Fatal(err)
+	}
+	sb := r.sb
+	if sb.Magic != magic || sb.Major != 4 || sb.Minor != 0 {
+		t.Fatalf("magic %#x, version %d.%d, want %#x, 4.0", sb.Magic, sb.Major, sb.Minor, magic)
+	}
+	if sb.BlockSize != 1<<sb.BlockLog {
+		t.Errorf("block size %d, block log %d", sb.BlockSize, sb.BlockLog)
+	}
+	const wantFlags = flagUncompressedInodes | flagUncompressedData | flagUncompressedFragments | flagNoFragments | flagNoXattrs | flagUncompressedIDs
+	if sb.Flags != wantFlags {
+		t.Errorf("flags %#x, want %#x", sb.Flags, wantFlags)
+	}
+	if sb.Compression != compressionZlib || sb.Fragments != 0 || sb.MkfsTime != 0 {
+		t.Errorf("compression %d, %d fragments, mkfs time %d", sb.Compression, sb.Fragments, sb.MkfsTime)
+	}
+	for name, v := range map[string]uint64{"xattr": sb.XattrTable, "fragment": sb.FragmentTable, "export": sb.ExportTable} {
+		if v != invalidBlock {
+			t.Errorf("%s table at %#x, want none", name, v)
+		}
+	}
+	if len(img)%4096 != 0 || sb.BytesUsed > uint64(len(img)) {
+		t.Errorf("image size %d, %d bytes used", len(img), sb.BytesUsed)
+	}
+
+	// The id table index points to a single metadata block with uid 0.
+	if sb.IDs != 1 || sb.IDTable+8 != sb.BytesUsed {
+		t.Fatalf("%d ids, id table at %d, %d bytes used", sb.IDs, sb.IDTable, sb.BytesUsed)
+	}
+	idBlock := binary.LittleEndian.Uint64(img[sb.IDTable:])
+	ids := decodeTable(t, "id table", img[idBlock:sb.IDTable])
+	if !bytes.Equal(ids.data, []byte{0, 0, 0, 0}) {
+		t.Errorf("ids = % x, want uid 0", ids.data)
+	}
+	if !(sb.InodeTable < sb.DirTable && sb.DirTable <= idBlock) {
+		t.Fatalf("tables out of order: inodes at %d, directories at %d, ids at %d", sb.InodeTable, sb.DirTable, idBlock)
+	}
+	r.inodes = decodeTable(t, "inode table", img[sb.InodeTable:sb.DirTable])
+	r.dirs = decodeTable(t, "directory table", img[sb.DirTable:idBlock])
+
+	root := r.walk("/", sb.RootInode, sb.Inodes+1)
+	if root.typ != typeDir {
+		t.Errorf("root inode type %d, want a directory", root.typ)
+	}
+	if uint32(len(r.seen)) != sb.Inodes {
+		t.Errorf("%d inodes found, superblock says %d", len(r.seen), sb.Inodes)
+	}
+	return r
+}
+
+// walk decodes the inode at ref, and all entries below it if it is a
+// directory. parentIno is the expected parent inode number of a directory.
+func (r *reader) walk(name string, ref uint64, parentIno uint32) file {
+	t := r.t
+	b := r.inodes.at(t, uint32(ref>>16), uint16(ref))
+	var h struct {
+		Type, Mode, UID, GID uint16
+		Mtime, Ino           uint32
+	}
+	rd := bytes.NewReader(b)
+	binary.Read(rd, binary.LittleEndian, &h)
+	if h.UID != 0 || h.GID != 0 || h.Mtime != 0 {
+		t.Errorf("%s: uid index %d, gid index %d, mtime %d, want 0", name, h.UID, h.GID, h.Mtime)
+	}
+	if h.Ino == 0 || h.Ino > r.sb.Inodes {
+		t.Errorf("%s: inode number %d out of range 1-%d", name, h.Ino, r.sb.Inodes)
+	}
+	if prev, ok := r.seen[h.Ino]; ok {
+		t.Errorf("%s: inode number %d already used by %s", name, h.Ino, prev)
+	}
+	r.seen[h.Ino] = name
+	f := file{typ: h.Type, perm: fs.FileMode(h.Mode)}
+
+	switch h.Type {
+	case typeFile:
+		var fh struct {
+			Start, Fragment, Offset, Size uint32
+		}
+		binary.Read(rd, binary.LittleEndian, &fh)
+		if fh.Fragment != noFragment || fh.Offset != 0 {
+			t.Errorf("%s: fragment %#x offset %d, want none", name, fh.Fragment, fh.Offset)
+		}
+		pos := int(fh.Start)
+		for remaining := int(fh.Size); remaining > 0; {
+			var size uint32
+			binary.Read(rd, binary.LittleEndian, &size)
+			if size&dataUncompressed == 0 {
+				t.Fatalf("%s: data block at %d is compressed", name, pos)
+			}
+			n := int(size &^ dataUncompressed)
+			if n != blockSize && n != remaining {
+				t.Errorf("%s: data block of %d bytes, want %d or the remaining %d", name, n, blockSize, remaining)
+			}
+			f.data += string(r.img[pos : pos+n])
+			pos += n
+			remaining -= n
+		}
+
+	case typeSymlink:
+		var sh struct{ Nlink, Size uint32 }
+		binary.Read(rd, binary.LittleEndian, &sh)
+		target := make([]byte, sh.Size)
+		rd.Read(target)
+		f.data = string(target)
+
+	case typeCharDev:
+		var ch struct{ Nlink, Rdev uint32 }
+		binary.Read(rd, binary.LittleEndian, &ch)
+		f.rdev = ch.Rdev
+
+	case typeDir:
+		var dh struct {
+			Block, Nlink uint32
+			Size, Offset uint16
+			Parent       uint32
+		}
+		binary.Read(rd, binary.LittleEndian, &dh)
+		if dh.Parent != parentIno {
+			t.Errorf("%s: parent inode %d, want %d", name, dh.Parent, parentIno)
+		}
+		listing := r.dirs.at(t, dh.Block, dh.Offset)[:dh.Size-3]
+		subdirs := uint32(0)
+		var prev string
+		for lr := bytes.NewReader(listing); lr.Len() > 0; {
+			var hdr struct{ Count, Start, Ino uint32 }
+			binary.Read(lr, binary.LittleEndian, &hdr)
+			if hdr.Count >= maxDirEntries {
+				t.Errorf("%s: directory header with %d entries", name, hdr.Count+1)
+			}
+			for i := uint32(0); i <= hdr.Count; i++ {
+				var e struct {
+					Offset    uint16
+					InoOffset int16
+					Type      uint16
+					NameSize  uint16
+				}
+				binary.Read(lr, binary.LittleEndian, &e)
+				child := make([]byte, int(e.NameSize)+1)
+				lr.Read(child)
+				if string(child) <= prev {
+					t.Errorf("%s: entry %q not sorted after %q", name, child, prev)
+				}
+				prev = string(child)
+				childName := path.Join(name, string(child))
+				cf := r.walk(childName, uint64(hdr.Start)<<16|uint64(e.Offset), h.Ino)
+				if cf.typ != e.Type {
+					t.Errorf("%s: directory entry type %d, inode type %d", childName, e.Type, cf.typ)
+				}
+				if childIno := uint32(int64(hdr.Ino) + int64(e.InoOffset)); r.seen[childIno] != childName {
+					t.Errorf("%s: directory entry inode number %d is %s", childName, childIno, r.seen[childIno])
+				}
+				if cf.typ == typeDir {
+					subdirs++
+				}
+			}
+		}
+		if dh.Nlink != 2+subdirs {
+			t.Errorf("%s: nlink %d, want %d", name, dh.Nlink, 2+subdirs)
+		}
+
+	default:
+		t.Fatalf("%s: unexpected inode type %d", name, h.Type)
+	}
+	r.files[name] = f
+	return f
+}
+
+func TestWriter(t *testing.T) {
+	// A file of more than one block, and enough files in one directory to
+	// span several inode and directory metadata blocks and directory
+	// headers.
+	large := bytes.Repeat([]byte("0123456789abcdef"), blockSize/16+1000)
+	want := map[string]file{
+		"/":                        {typ: typeDir, perm: 0755},
+		"/dev":                     {typ: typeDir, perm: 0755},
+		"/dev/console":             {typ: typeCharDev, perm: 0600, rdev: 5<<8 | 1},
+		"/dev/ttyS64":              {typ: typeCharDev, perm: 0620, rdev: 4<<8 | 64&0xff | (320&^0xff)<<12},
+		"/gokrazy":                 {typ: typeDir, perm: 0755},
+		"/gokrazy/init":            {typ: typeFile, perm: 0755, data: string(large)},
+		"/gokrazy/empty":           {typ: typeFile, perm: 0644},
+		"/gokrazy/expected.config": {typ: typeFile, perm: 0644, data: "CONFIG_A=y\n"},
+		"/init":                    {typ: typeSymlink, perm: 0777, data: "gokrazy/init"},
+		"/many":                    {typ: typeDir, perm: 0700},
+	}
+	for i := 0; i < 1000; i++ {
+		want[fmt.Sprintf("/many/%04d", i)] = file{typ: typeFile, perm: 0644, data: fmt.Sprint(i)}
+	}
+
+	// build writes the tree of want, adding the parent directories first
+	// and the rest in map order.
+	build := func() []byte {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		var err error
+		for _, name := range []string{"dev", "gokrazy", "many"} {
+			if err == nil {
+				err = w.Dir(name, want["/"+name].perm)
+			}
+		}
+		for name, f := range want {
+			if err != nil {
+				break
+			}
+			switch {
+			case name == "/" || name == "/dev" || name == "/gokrazy" || name == "/many":
+			case f.typ == typeDir:
+				err = w.Dir(name, f.perm)
+			case f.typ == typeFile:
+				err = w.File(name, f.perm, []byte(f.data))
+			case f.typ == typeSymlink:
+				err = w.Symlink(name, f.data)
+			}
+		}
+		if err == nil {
+			err = w.CharDev("dev/console", 0600, 5, 1)
+		}
+		if err == nil {
+			err = w.CharDev("dev/ttyS64", 0620, 4, 320)
+		}
+		if err == nil {
+			err = w.Close()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	img := build()
+	r := read(t, img)
+	if len(r.inodes.blocks) < 2 || len(r.dirs.blocks) < 2 {
+		t.Errorf("%d inode and %d directory metadata blocks, want several of each", len(r.inodes.blocks), len(r.dirs.blocks))
+	}
+	for name, w := range want {
+		got, ok := r.files[name]
+		if !ok {
+			t.Errorf("%s: missing", name)
+			continue
+		}
+		if got != w {
+			t.Errorf("%s: got type %d perm %v rdev %#x and %d bytes, want type %d perm %v rdev %#x and %d bytes",
+				name, got.typ, got.perm, got.rdev, len(got.data), w.typ, w.perm, w.rdev, len(w.data))
+		}
+	}
+	for name := range r.files {
+		if _, ok := want[name]; !ok {
+			t.Errorf("%s: unexpected entry", name)
+		}
+	}
+
+	// The image is reproducible.
+	if again := build(); !bytes.Equal(img, again) {
+		t.Errorf("writing the same tree twice resulted in different images")
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	w := NewWriter(&bytes.Buffer{})
+	if err := w.File("gokrazy/init", 0755, nil); err == nil {
+		t.Errorf("File() without parent directory succeeded")
+	}
+	if err := w.File("init", 0755, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Symlink("init", "gokrazy/init"); err == nil {
+		t.Errorf("adding init twice succeeded")
+	}
+	if err := w.File("init/x", 0644, nil); err == nil {
+		t.Errorf("File() below a file succeeded")
+	}
+}